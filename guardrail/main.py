@@ -1,7 +1,11 @@
-from typing import List, Optional, Any, Dict
+import os
+from typing import List, Literal, Optional, Any, Dict, Union
 from pydantic import BaseModel
 from fastapi import FastAPI
 
+# Bump this whenever the rules below change so the proxy drops cached verdicts
+POLICY_VERSION = os.getenv("POLICY_VERSION", "1")
+
 
 # 1. Mirror the OpenAI structure (simplified)
 class Message(BaseModel):
@@ -19,38 +23,123 @@ class OpenAIChatRequest(BaseModel):
         extra = "allow"
 
 
+# Contract version, must match domain.GuardrailContractVersion in the proxy.
+# Version 1 only had allowed/reason/sanitized_input, version 2 added actions
+# and violations, version 3 wraps requests in an envelope with metadata.
+CONTRACT_VERSION = "3"
+SUPPORTED_VERSIONS = ["1", "2", "3"]
+
+
+class RequestMetadata(BaseModel):
+    request_id: str = ""
+    key_id: str = ""
+    team: str = ""
+    model: str = ""
+    direction: Literal["input", "output"] = "input"
+    policy_id: str = ""
+    # API the request is for; other endpoints are checked as a chat view of their inputs
+    endpoint: str = ""
+
+
+class ValidationEnvelope(BaseModel):
+    version: str
+    payload: OpenAIChatRequest
+    metadata: RequestMetadata = RequestMetadata()
+
+
+class Span(BaseModel):
+    path: str = ""
+    start: int
+    end: int
+
+
+class Violation(BaseModel):
+    category: str
+    score: float = 1.0
+    severity: Literal["low", "medium", "high", "critical"] = "medium"
+    detector: str = ""
+    spans: List[Span] = []
+
+
 class ValidationResponse(BaseModel):
+    version: str = CONTRACT_VERSION
     allowed: bool
+    # None lets the proxy derive the action from allowed, which always wins:
+    # a verdict that isn't allowed blocks whatever its action
+    action: Optional[Literal["allow", "redact", "block", "warn", "reroute"]] = None
     reason: str = ""
+    violations: List[Violation] = []
+    reroute_model: Optional[str] = None
     # Return the FULL modified request body to send to OpenAI
     sanitized_input: Optional[Dict[str, Any]] = None
+    # Placeholders in sanitized_input mapped to the values they replaced, the
+    # proxy restores them in the response
+    tokens: Dict[str, str] = {}
+    policy_version: str = POLICY_VERSION
 
 
 app = FastAPI()
 
 
+@app.get("/capabilities")
+async def capabilities():
+    # The proxy only sends the envelope once it sees version 3 here
+    return {"versions": SUPPORTED_VERSIONS, "policy_version": POLICY_VERSION}
+
+
 @app.post("/validate", response_model=ValidationResponse)
-async def validate_prompt(req: OpenAIChatRequest):
+async def validate_prompt(body: Union[ValidationEnvelope, OpenAIChatRequest]):
+    if isinstance(body, ValidationEnvelope):
+        req, metadata = body.payload, body.metadata
+    else:
+        req, metadata = body, RequestMetadata(model=body.model)
+
     # Extract the last user message
-    last_message = req.messages[-1].content
+    last_index = len(req.messages) - 1
+    last_message = req.messages[last_index].content
+    path = f"messages.{last_index}.content"
 
-    print(f"[Guardrail] Scanning: {last_message[:20]}...")
+    print(
+        f"[Guardrail] {metadata.request_id or '-'} "
+        f"key={metadata.key_id or '-'} policy={metadata.policy_id or '-'} "
+        f"Scanning: {last_message[:20]}..."
+    )
 
     if "ATTACK" in last_message:
+        start = last_message.index("ATTACK")
         return ValidationResponse(
-            allowed=False, reason="Malicious keyword detected"
+            allowed=False,
+            action="block",
+            reason="Malicious keyword detected",
+            violations=[
+                Violation(
+                    category="KEYWORD",
+                    severity="high",
+                    detector="malicious-keywords",
+                    spans=[Span(path=path, start=start, end=start + len("ATTACK"))],
+                )
+            ],
         )
 
     # Simulate PII masking
     if "password" in last_message:
+        start = last_message.index("password")
         # Modifying the request object directly
-        req.messages[-1].content = last_message.replace(
+        req.messages[last_index].content = last_message.replace(
             "password", "[REDACTED]"
         )
 
         # Return the ENTIRE updated JSON structure
         return ValidationResponse(
             allowed=True,
+            action="redact",
+            violations=[
+                Violation(
+                    category="SECRET",
+                    detector="secrets",
+                    spans=[Span(path=path, start=start, end=start + len("password"))],
+                )
+            ],
             sanitized_input=req.model_dump(),  # Dumps the full OpenAI-compatible JSON
         )
 
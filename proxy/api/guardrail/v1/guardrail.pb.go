@@ -0,0 +1,533 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: guardrail/v1/guardrail.proto
+
+// Contract between the Baldr proxy and the guardrail sidecar when they talk
+// gRPC instead of JSON over HTTP.
+
+package guardrailv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ValidateRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The raw OpenAI-compatible request body, as received by the proxy.
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	// Contract version the proxy speaks. Sidecars built against an older
+	// contract ignore the fields they don't know, so both can coexist.
+	Version       string           `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Metadata      *RequestMetadata `protobuf:"bytes,3,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValidateRequest) Reset() {
+	*x = ValidateRequest{}
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValidateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateRequest) ProtoMessage() {}
+
+func (x *ValidateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateRequest.ProtoReflect.Descriptor instead.
+func (*ValidateRequest) Descriptor() ([]byte, []int) {
+	return file_guardrail_v1_guardrail_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ValidateRequest) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *ValidateRequest) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetMetadata() *RequestMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type RequestMetadata struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	RequestId string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Fingerprint or virtual key ID, never the secret itself.
+	KeyId string `protobuf:"bytes,2,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Team  string `protobuf:"bytes,3,opt,name=team,proto3" json:"team,omitempty"`
+	Model string `protobuf:"bytes,4,opt,name=model,proto3" json:"model,omitempty"`
+	// Either "input" or "output".
+	Direction     string `protobuf:"bytes,5,opt,name=direction,proto3" json:"direction,omitempty"`
+	PolicyId      string `protobuf:"bytes,6,opt,name=policy_id,json=policyId,proto3" json:"policy_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestMetadata) Reset() {
+	*x = RequestMetadata{}
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestMetadata) ProtoMessage() {}
+
+func (x *RequestMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestMetadata.ProtoReflect.Descriptor instead.
+func (*RequestMetadata) Descriptor() ([]byte, []int) {
+	return file_guardrail_v1_guardrail_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RequestMetadata) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *RequestMetadata) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *RequestMetadata) GetTeam() string {
+	if x != nil {
+		return x.Team
+	}
+	return ""
+}
+
+func (x *RequestMetadata) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *RequestMetadata) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *RequestMetadata) GetPolicyId() string {
+	if x != nil {
+		return x.PolicyId
+	}
+	return ""
+}
+
+type ValidateResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Allowed bool                   `protobuf:"varint,1,opt,name=allowed,proto3" json:"allowed,omitempty"`
+	Reason  string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Full replacement request body. Empty when the payload is unchanged.
+	SanitizedInput []byte `protobuf:"bytes,3,opt,name=sanitized_input,json=sanitizedInput,proto3" json:"sanitized_input,omitempty"`
+	// Identifies the policy that produced the verdict.
+	PolicyVersion string `protobuf:"bytes,4,opt,name=policy_version,json=policyVersion,proto3" json:"policy_version,omitempty"`
+	// Verdict schema version. Empty or "1" means only the fields above are set.
+	Version string `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
+	// One of allow, redact, block, warn, reroute. Supersedes allowed when set.
+	Action     string       `protobuf:"bytes,6,opt,name=action,proto3" json:"action,omitempty"`
+	Violations []*Violation `protobuf:"bytes,7,rep,name=violations,proto3" json:"violations,omitempty"`
+	// Target model when action is reroute.
+	RerouteModel string `protobuf:"bytes,8,opt,name=reroute_model,json=rerouteModel,proto3" json:"reroute_model,omitempty"`
+	// Placeholders in sanitized_input mapped to the values they replaced, so
+	// the proxy can restore them in the response.
+	Tokens        map[string]string `protobuf:"bytes,9,rep,name=tokens,proto3" json:"tokens,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValidateResponse) Reset() {
+	*x = ValidateResponse{}
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValidateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateResponse) ProtoMessage() {}
+
+func (x *ValidateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateResponse.ProtoReflect.Descriptor instead.
+func (*ValidateResponse) Descriptor() ([]byte, []int) {
+	return file_guardrail_v1_guardrail_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ValidateResponse) GetAllowed() bool {
+	if x != nil {
+		return x.Allowed
+	}
+	return false
+}
+
+func (x *ValidateResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *ValidateResponse) GetSanitizedInput() []byte {
+	if x != nil {
+		return x.SanitizedInput
+	}
+	return nil
+}
+
+func (x *ValidateResponse) GetPolicyVersion() string {
+	if x != nil {
+		return x.PolicyVersion
+	}
+	return ""
+}
+
+func (x *ValidateResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ValidateResponse) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *ValidateResponse) GetViolations() []*Violation {
+	if x != nil {
+		return x.Violations
+	}
+	return nil
+}
+
+func (x *ValidateResponse) GetRerouteModel() string {
+	if x != nil {
+		return x.RerouteModel
+	}
+	return ""
+}
+
+func (x *ValidateResponse) GetTokens() map[string]string {
+	if x != nil {
+		return x.Tokens
+	}
+	return nil
+}
+
+type Violation struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Category string                 `protobuf:"bytes,1,opt,name=category,proto3" json:"category,omitempty"`
+	// Confidence between 0 and 1.
+	Score float64 `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	// One of low, medium, high, critical.
+	Severity      string  `protobuf:"bytes,3,opt,name=severity,proto3" json:"severity,omitempty"`
+	Detector      string  `protobuf:"bytes,4,opt,name=detector,proto3" json:"detector,omitempty"`
+	Spans         []*Span `protobuf:"bytes,5,rep,name=spans,proto3" json:"spans,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Violation) Reset() {
+	*x = Violation{}
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Violation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Violation) ProtoMessage() {}
+
+func (x *Violation) ProtoReflect() protoreflect.Message {
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Violation.ProtoReflect.Descriptor instead.
+func (*Violation) Descriptor() ([]byte, []int) {
+	return file_guardrail_v1_guardrail_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Violation) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *Violation) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Violation) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *Violation) GetDetector() string {
+	if x != nil {
+		return x.Detector
+	}
+	return ""
+}
+
+func (x *Violation) GetSpans() []*Span {
+	if x != nil {
+		return x.Spans
+	}
+	return nil
+}
+
+// Span locates a match. Path points at the text field of the request body
+// (e.g. "messages.1.content"), offsets are bytes within that field.
+type Span struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Start         int32                  `protobuf:"varint,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           int32                  `protobuf:"varint,3,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Span) Reset() {
+	*x = Span{}
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Span) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Span) ProtoMessage() {}
+
+func (x *Span) ProtoReflect() protoreflect.Message {
+	mi := &file_guardrail_v1_guardrail_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Span.ProtoReflect.Descriptor instead.
+func (*Span) Descriptor() ([]byte, []int) {
+	return file_guardrail_v1_guardrail_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Span) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Span) GetStart() int32 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *Span) GetEnd() int32 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+var File_guardrail_v1_guardrail_proto protoreflect.FileDescriptor
+
+const file_guardrail_v1_guardrail_proto_rawDesc = "" +
+	"\n" +
+	"\x1cguardrail/v1/guardrail.proto\x12\x12baldr.guardrail.v1\"\x86\x01\n" +
+	"\x0fValidateRequest\x12\x18\n" +
+	"\apayload\x18\x01 \x01(\fR\apayload\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12?\n" +
+	"\bmetadata\x18\x03 \x01(\v2#.baldr.guardrail.v1.RequestMetadataR\bmetadata\"\xac\x01\n" +
+	"\x0fRequestMetadata\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x15\n" +
+	"\x06key_id\x18\x02 \x01(\tR\x05keyId\x12\x12\n" +
+	"\x04team\x18\x03 \x01(\tR\x04team\x12\x14\n" +
+	"\x05model\x18\x04 \x01(\tR\x05model\x12\x1c\n" +
+	"\tdirection\x18\x05 \x01(\tR\tdirection\x12\x1b\n" +
+	"\tpolicy_id\x18\x06 \x01(\tR\bpolicyId\"\xaf\x03\n" +
+	"\x10ValidateResponse\x12\x18\n" +
+	"\aallowed\x18\x01 \x01(\bR\aallowed\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12'\n" +
+	"\x0fsanitized_input\x18\x03 \x01(\fR\x0esanitizedInput\x12%\n" +
+	"\x0epolicy_version\x18\x04 \x01(\tR\rpolicyVersion\x12\x18\n" +
+	"\aversion\x18\x05 \x01(\tR\aversion\x12\x16\n" +
+	"\x06action\x18\x06 \x01(\tR\x06action\x12=\n" +
+	"\n" +
+	"violations\x18\a \x03(\v2\x1d.baldr.guardrail.v1.ViolationR\n" +
+	"violations\x12#\n" +
+	"\rreroute_model\x18\b \x01(\tR\frerouteModel\x12H\n" +
+	"\x06tokens\x18\t \x03(\v20.baldr.guardrail.v1.ValidateResponse.TokensEntryR\x06tokens\x1a9\n" +
+	"\vTokensEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa5\x01\n" +
+	"\tViolation\x12\x1a\n" +
+	"\bcategory\x18\x01 \x01(\tR\bcategory\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\x12\x1a\n" +
+	"\bseverity\x18\x03 \x01(\tR\bseverity\x12\x1a\n" +
+	"\bdetector\x18\x04 \x01(\tR\bdetector\x12.\n" +
+	"\x05spans\x18\x05 \x03(\v2\x18.baldr.guardrail.v1.SpanR\x05spans\"B\n" +
+	"\x04Span\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\x05R\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\x05R\x03end2i\n" +
+	"\x10GuardrailService\x12U\n" +
+	"\bValidate\x12#.baldr.guardrail.v1.ValidateRequest\x1a$.baldr.guardrail.v1.ValidateResponseBDZBgithub.com/simone-trubian/baldr/proxy/api/guardrail/v1;guardrailv1b\x06proto3"
+
+var (
+	file_guardrail_v1_guardrail_proto_rawDescOnce sync.Once
+	file_guardrail_v1_guardrail_proto_rawDescData []byte
+)
+
+func file_guardrail_v1_guardrail_proto_rawDescGZIP() []byte {
+	file_guardrail_v1_guardrail_proto_rawDescOnce.Do(func() {
+		file_guardrail_v1_guardrail_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_guardrail_v1_guardrail_proto_rawDesc), len(file_guardrail_v1_guardrail_proto_rawDesc)))
+	})
+	return file_guardrail_v1_guardrail_proto_rawDescData
+}
+
+var file_guardrail_v1_guardrail_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_guardrail_v1_guardrail_proto_goTypes = []any{
+	(*ValidateRequest)(nil),  // 0: baldr.guardrail.v1.ValidateRequest
+	(*RequestMetadata)(nil),  // 1: baldr.guardrail.v1.RequestMetadata
+	(*ValidateResponse)(nil), // 2: baldr.guardrail.v1.ValidateResponse
+	(*Violation)(nil),        // 3: baldr.guardrail.v1.Violation
+	(*Span)(nil),             // 4: baldr.guardrail.v1.Span
+	nil,                      // 5: baldr.guardrail.v1.ValidateResponse.TokensEntry
+}
+var file_guardrail_v1_guardrail_proto_depIdxs = []int32{
+	1, // 0: baldr.guardrail.v1.ValidateRequest.metadata:type_name -> baldr.guardrail.v1.RequestMetadata
+	3, // 1: baldr.guardrail.v1.ValidateResponse.violations:type_name -> baldr.guardrail.v1.Violation
+	5, // 2: baldr.guardrail.v1.ValidateResponse.tokens:type_name -> baldr.guardrail.v1.ValidateResponse.TokensEntry
+	4, // 3: baldr.guardrail.v1.Violation.spans:type_name -> baldr.guardrail.v1.Span
+	0, // 4: baldr.guardrail.v1.GuardrailService.Validate:input_type -> baldr.guardrail.v1.ValidateRequest
+	2, // 5: baldr.guardrail.v1.GuardrailService.Validate:output_type -> baldr.guardrail.v1.ValidateResponse
+	5, // [5:6] is the sub-list for method output_type
+	4, // [4:5] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_guardrail_v1_guardrail_proto_init() }
+func file_guardrail_v1_guardrail_proto_init() {
+	if File_guardrail_v1_guardrail_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_guardrail_v1_guardrail_proto_rawDesc), len(file_guardrail_v1_guardrail_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_guardrail_v1_guardrail_proto_goTypes,
+		DependencyIndexes: file_guardrail_v1_guardrail_proto_depIdxs,
+		MessageInfos:      file_guardrail_v1_guardrail_proto_msgTypes,
+	}.Build()
+	File_guardrail_v1_guardrail_proto = out.File
+	file_guardrail_v1_guardrail_proto_goTypes = nil
+	file_guardrail_v1_guardrail_proto_depIdxs = nil
+}
@@ -0,0 +1,126 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: guardrail/v1/guardrail.proto
+
+// Contract between the Baldr proxy and the guardrail sidecar when they talk
+// gRPC instead of JSON over HTTP.
+
+package guardrailv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	GuardrailService_Validate_FullMethodName = "/baldr.guardrail.v1.GuardrailService/Validate"
+)
+
+// GuardrailServiceClient is the client API for GuardrailService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GuardrailServiceClient interface {
+	// Validate inspects a request body and returns the verdict.
+	Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error)
+}
+
+type guardrailServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGuardrailServiceClient(cc grpc.ClientConnInterface) GuardrailServiceClient {
+	return &guardrailServiceClient{cc}
+}
+
+func (c *guardrailServiceClient) Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValidateResponse)
+	err := c.cc.Invoke(ctx, GuardrailService_Validate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GuardrailServiceServer is the server API for GuardrailService service.
+// All implementations must embed UnimplementedGuardrailServiceServer
+// for forward compatibility.
+type GuardrailServiceServer interface {
+	// Validate inspects a request body and returns the verdict.
+	Validate(context.Context, *ValidateRequest) (*ValidateResponse, error)
+	mustEmbedUnimplementedGuardrailServiceServer()
+}
+
+// UnimplementedGuardrailServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGuardrailServiceServer struct{}
+
+func (UnimplementedGuardrailServiceServer) Validate(context.Context, *ValidateRequest) (*ValidateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Validate not implemented")
+}
+func (UnimplementedGuardrailServiceServer) mustEmbedUnimplementedGuardrailServiceServer() {}
+func (UnimplementedGuardrailServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeGuardrailServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GuardrailServiceServer will
+// result in compilation errors.
+type UnsafeGuardrailServiceServer interface {
+	mustEmbedUnimplementedGuardrailServiceServer()
+}
+
+func RegisterGuardrailServiceServer(s grpc.ServiceRegistrar, srv GuardrailServiceServer) {
+	// If the following call pancis, it indicates UnimplementedGuardrailServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&GuardrailService_ServiceDesc, srv)
+}
+
+func _GuardrailService_Validate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GuardrailServiceServer).Validate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GuardrailService_Validate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GuardrailServiceServer).Validate(ctx, req.(*ValidateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// GuardrailService_ServiceDesc is the grpc.ServiceDesc for GuardrailService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GuardrailService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "baldr.guardrail.v1.GuardrailService",
+	HandlerType: (*GuardrailServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Validate",
+			Handler:    _GuardrailService_Validate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "guardrail/v1/guardrail.proto",
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/bench"
+)
+
+// runBench implements `baldr bench [flags]`. It measures the latency,
+// allocations and throughput the proxy adds over calling the LLM directly,
+// using in-process fake guardrail and LLM servers.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	concurrency := fs.String("concurrency", "1,8,64", "comma separated numbers of concurrent streams")
+	requests := fs.Int("requests", 2000, "requests per measurement")
+	chunks := fs.Int("chunks", 20, "SSE events per response")
+	chunkDelay := fs.Duration("chunk-delay", 0, "pause between SSE events of the fake LLM")
+	guardrail := fs.String("guardrail", "", "guardrail URL instead of the fake sidecar, e.g. native://")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: baldr bench [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := bench.Config{
+		StackConfig: bench.StackConfig{
+			Chunks:       *chunks,
+			ChunkDelay:   *chunkDelay,
+			GuardrailURL: *guardrail,
+		},
+		Requests: *requests,
+	}
+	for _, field := range strings.Split(*concurrency, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "bench: invalid concurrency %q\n", field)
+			return 2
+		}
+		config.Concurrency = append(config.Concurrency, n)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	results, err := bench.Run(ctx, config)
+	bench.Print(os.Stdout, results)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Done in %s\n", time.Since(start).Round(time.Millisecond))
+	return 0
+}
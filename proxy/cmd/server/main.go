@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/simone-trubian/baldr/proxy/internal/adapters"
 	"github.com/simone-trubian/baldr/proxy/internal/core"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
 	"github.com/simone-trubian/baldr/proxy/internal/handlers"
 )
 
@@ -23,6 +29,45 @@ type Config struct {
 	LLMAPIKey            string
 	GuardrailConcurrency int
 	GuarailTimeout       int
+	JudgeTimeout         int
+	GuardrailCacheSize   int
+	GuardrailCacheTTL    int
+	GuardrailPolicy      string
+	GuardrailPipeline    string
+	ResponseGuardrail    string
+	ResponseCacheSize    int
+	ResponseCacheBytes   int
+	ResponseCacheTTL     int
+	ResponseCacheDefault bool
+	ResponseCacheKeys    map[string]bool
+	SemanticCacheSize    int
+	SemanticCacheTTL     int
+	SemanticThreshold    float64
+	EmbeddingsURL        string
+	EmbeddingsModel      string
+	EmbeddingsAPIKey     string
+	ShadowModel          string
+	ShadowPercent        float64
+	ShadowLLMURL         string
+	ShadowLLMAPIKey      string
+	ShadowLog            string
+	ShadowTimeout        int
+	ShadowMaxInFlight    int
+	ModelPricing         string
+	Experiments          string
+	Models               string
+	AdminPort            string
+	AdminToken           string
+	KeysFile             string
+	KeysReadOnly         bool // Replays use the keys without spending them
+	VirtualKeysRequired  bool
+	UsageLog             string
+	MaxTokensPolicy      string
+	RequestPolicies      string
+	ExpressionPolicies   string
+	DefaultMaxTokens     int
+	MaxBodyBytes         int64
+	BodyLimits           map[string]int64
 }
 
 func loadConfig() Config {
@@ -33,47 +78,73 @@ func loadConfig() Config {
 		LLMAPIKey:            getEnv("LLM_API_KEY", ""),
 		GuardrailConcurrency: getEnvInt("GUARDRAIL_MAX_CONCURRENCY", 50), // Default 50 concurrent checks
 		GuarailTimeout:       getEnvInt("GUARDRAIL_TIMEOUT", 1),
+		JudgeTimeout:         getEnvInt("LLM_GUARDRAIL_TIMEOUT", 10),  // Seconds for llm:// pipeline stages
+		GuardrailCacheSize:   getEnvInt("GUARDRAIL_CACHE_SIZE", 1000), // 0 disables the verdict cache
+		GuardrailCacheTTL:    getEnvInt("GUARDRAIL_CACHE_TTL", 60),
+		GuardrailPolicy:      getEnv("GUARDRAIL_POLICY_VERSION", ""),
+		GuardrailPipeline:    getEnv("GUARDRAIL_PIPELINE", ""),    // JSON file composing several guardrails
+		ResponseGuardrail:    getEnv("RESPONSE_GUARDRAIL", ""),    // Guardrail URL checking responses, e.g. wasm:///etc/baldr/pii.wasm
+		ResponseCacheSize:    getEnvInt("RESPONSE_CACHE_SIZE", 0), // 0 disables the response cache
+		ResponseCacheBytes:   getEnvInt("RESPONSE_CACHE_MAX_BYTES", 64<<20),
+		ResponseCacheTTL:     getEnvInt("RESPONSE_CACHE_TTL", 300),
+		ResponseCacheDefault: getEnv("RESPONSE_CACHE_DEFAULT", "on") == "on",
+		ResponseCacheKeys:    getEnvSwitches("RESPONSE_CACHE_KEYS"), // e.g. key-3f9a1c2b4d5e=off,key-77aa01bc22de=on
+		SemanticCacheSize:    getEnvInt("SEMANTIC_CACHE_SIZE", 0),   // Entries per team or key, 0 disables the semantic cache
+		SemanticCacheTTL:     getEnvInt("SEMANTIC_CACHE_TTL", 3600),
+		SemanticThreshold:    getEnvFloat("SEMANTIC_CACHE_THRESHOLD", 0.95),
+		EmbeddingsURL:        getEnv("EMBEDDINGS_URL", "https://generativelanguage.googleapis.com/v1beta/openai/embeddings"),
+		EmbeddingsModel:      getEnv("EMBEDDINGS_MODEL", "text-embedding-004"),
+		EmbeddingsAPIKey:     getEnv("EMBEDDINGS_API_KEY", getEnv("LLM_API_KEY", "")),
+		ShadowModel:          getEnv("SHADOW_MODEL", ""), // Empty disables mirroring
+		ShadowPercent:        getEnvFloat("SHADOW_PERCENT", 5),
+		ShadowLLMURL:         getEnv("SHADOW_LLM_URL", getEnv("LLM_URL", "https://generativelanguage.googleapis.com/v1beta/openai/")),
+		ShadowLLMAPIKey:      getEnv("SHADOW_LLM_API_KEY", getEnv("LLM_API_KEY", "")),
+		ShadowLog:            getEnv("SHADOW_LOG", "shadow.jsonl"),
+		ShadowTimeout:        getEnvInt("SHADOW_TIMEOUT", 60),
+		ShadowMaxInFlight:    getEnvInt("SHADOW_MAX_IN_FLIGHT", 32),
+		ModelPricing:         getEnv("MODEL_PRICING", ""), // JSON file of USD per million tokens by model
+		Experiments:          getEnv("EXPERIMENTS", ""),   // JSON file of A/B experiments
+		Models:               getEnv("MODELS", ""),        // JSON file of providers, aliases and model metadata
+		AdminPort:            getEnv("ADMIN_PORT", ""),    // Empty disables the admin API and virtual keys
+		AdminToken:           getEnv("ADMIN_TOKEN", ""),
+		KeysFile:             getEnv("KEYS_FILE", "keys.json"),
+		VirtualKeysRequired:  getEnv("VIRTUAL_KEYS_REQUIRED", "false") == "true",
+		UsageLog:             getEnv("USAGE_LOG", ""),              // JSONL of usage records, empty keeps usage in memory
+		MaxTokensPolicy:      getEnv("MAX_TOKENS_POLICY", "clamp"), // clamp, reject or inject
+		DefaultMaxTokens:     getEnvInt("DEFAULT_MAX_TOKENS", 0),   // Injected, 0 for all the room left
+		RequestPolicies:      getEnv("REQUEST_POLICIES", ""),       // JSON file of per-key and per-team request policies
+		ExpressionPolicies:   getEnv("EXPRESSION_POLICIES", ""),    // JSON file of CEL policies, evaluated after the guardrail
+		MaxBodyBytes:         int64(getEnvInt("MAX_BODY_BYTES", 4<<20)),
+		BodyLimits:           getEnvLimits("BODY_LIMITS"), // Per route, e.g. /chat/completions=8388608
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
+	}
+
 	// 1. Configuration
 	cfg := loadConfig()
 	log.Printf("Starting Baldr Proxy on port %s", cfg.ServerPort)
 	log.Printf("Guardrail: %s (Concurrency Limit: %d)", cfg.GuardrailURL, cfg.GuardrailConcurrency)
 	log.Printf("Upstream LLM: %s", cfg.LLMURL)
 
-	guardrailConfig := adapters.GuardrailConfig{
-		BaseURL:        cfg.GuardrailURL,
-		Timeout:        time.Duration(cfg.GuarailTimeout) * time.Second,
-		MaxConcurrency: cfg.GuardrailConcurrency,
-	}
-	llmConfig := adapters.LLMConfig{
-		BaseURL: cfg.LLMURL,
-		APIKey:  cfg.LLMAPIKey,
-	}
-	guardrailAdapter := adapters.NewRemoteGuardrail(guardrailConfig)
-	llmAdapter := adapters.NewLLM(llmConfig)
-
 	// 2. Initialize Service (Core Logic)
-	// Dependency Injection happens here
-	service := core.NewBaldrService(guardrailAdapter, llmAdapter)
-
-	// 3. Initialize Handlers (Presentation)
-	handler := handlers.NewHTTPHandler(service)
-
-	// 4. Router Setup
-	mux := http.NewServeMux()
-	// Map the proxy endpoint. You might want to make the path configurable too.
-	mux.HandleFunc("POST /chat/completions", handler.HandleProxy)
+	proxy, err := buildApp(cfg)
+	if err != nil {
+		log.Fatalf("Service setup failed: %v", err)
+	}
 
-	// Health check for Docker/K8s
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	// 3. Initialize Handlers and routes (Presentation)
+	mux := newDataPlane(cfg, proxy)
 
-	// 5. Server Configuration
+	// 4. Server Configuration
 	srv := &http.Server{
 		Addr:         ":" + cfg.ServerPort,
 		Handler:      mux,
@@ -82,13 +153,50 @@ func main() {
 		IdleTimeout:  120 * time.Second, // Keep-alive connections
 	}
 
-	// 6. Graceful Shutdown Routine
+	// The admin API has a listener of its own, so it can stay off the
+	// network the data plane is exposed to
+	var adminSrv *http.Server
+	if proxy.keys != nil {
+		adminMux := http.NewServeMux()
+		admin := handlers.NewAdminHandler(proxy.keys)
+		adminMux.HandleFunc("POST /keys", admin.HandleCreate)
+		adminMux.HandleFunc("GET /keys", admin.HandleList)
+		adminMux.HandleFunc("GET /keys/{id}", admin.HandleGet)
+		adminMux.HandleFunc("PATCH /keys/{id}", admin.HandleUpdate)
+		adminMux.HandleFunc("POST /keys/{id}/rotate", admin.HandleRotate)
+		adminMux.HandleFunc("POST /keys/{id}/suspend", admin.HandleStatus(domain.KeySuspended))
+		adminMux.HandleFunc("POST /keys/{id}/resume", admin.HandleStatus(domain.KeyActive))
+		adminMux.HandleFunc("DELETE /keys/{id}", admin.HandleDelete)
+		usage := handlers.NewUsageHandler(proxy.usage)
+		adminMux.HandleFunc("GET /usage", usage.HandleSummary)
+		adminMux.HandleFunc("GET /usage/report", usage.HandleReport)
+		if proxy.experiments != nil {
+			experiments := handlers.NewExperimentsHandler(proxy.experiments)
+			adminMux.HandleFunc("GET /experiments", experiments.HandleResults)
+		}
+		adminSrv = &http.Server{
+			Addr:         ":" + cfg.AdminPort,
+			Handler:      handlers.RequireToken(cfg.AdminToken, handlers.LimitBody(64<<10, adminMux)),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		log.Printf("Admin API on port %s, virtual keys in %s", cfg.AdminPort, cfg.KeysFile)
+	}
+
+	// 5. Graceful Shutdown Routine
 	// We want to handle SIGINT (Ctrl+C) and SIGTERM (Docker stop)
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server startup failed: %v", err)
 		}
 	}()
+	if adminSrv != nil {
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server startup failed: %v", err)
+			}
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -103,9 +211,321 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
+	// Spend recorded since the last save
+	if err := proxy.Close(); err != nil {
+		log.Printf("Failed to save state: %v", err)
+	}
 
 	log.Println("Server exited properly")
 }
+
+// app is the wired proxy: the service and the parts of it that have routes
+// of their own.
+type app struct {
+	service     *core.BaldrService
+	experiments *adapters.ExperimentStats // Nil without EXPERIMENTS
+	usage       *adapters.UsageLedger
+	keys        *core.KeyManager // Nil without ADMIN_PORT
+	keyStore    *adapters.KeyFileStore
+	shadowStore *adapters.ShadowFileStore // Nil without SHADOW_MODEL
+	// The guardrails hold wasm runtimes and gRPC connections
+	guardrail         io.Closer
+	responseGuardrail io.Closer // Nil without RESPONSE_GUARDRAIL
+}
+
+// Close saves what the app keeps in memory, closes its logs and releases
+// its guardrails.
+func (a *app) Close() error {
+	err := a.usage.Close()
+	if a.keyStore != nil {
+		err = errors.Join(err, a.keyStore.Close())
+	}
+	if a.shadowStore != nil {
+		err = errors.Join(err, a.shadowStore.Close())
+	}
+	for _, guardrail := range []io.Closer{a.guardrail, a.responseGuardrail} {
+		if guardrail != nil {
+			err = errors.Join(err, guardrail.Close())
+		}
+	}
+	return err
+}
+
+// newDataPlane routes the proxy's public endpoints, as served to clients and
+// replayed in process.
+func newDataPlane(cfg Config, proxy *app) *http.ServeMux {
+	handler := handlers.NewHTTPHandler(proxy.service)
+
+	mux := http.NewServeMux()
+	// Map the proxy endpoint. You might want to make the path configurable too.
+	route := func(pattern string, h http.HandlerFunc) {
+		_, path, _ := strings.Cut(pattern, " ")
+		limit, ok := cfg.BodyLimits[path]
+		if !ok {
+			limit = cfg.MaxBodyBytes
+		}
+		var next http.Handler = h
+		if proxy.keys != nil {
+			next = handlers.Authenticate(proxy.keys, cfg.VirtualKeysRequired, next)
+		}
+		mux.Handle(pattern, handlers.LimitBody(limit, next))
+	}
+	// Each endpoint answers with and without the /v1 prefix
+	for _, endpoint := range []domain.Endpoint{
+		domain.EndpointChat,
+		domain.EndpointCompletions,
+		domain.EndpointEmbeddings,
+		domain.EndpointModerations,
+		domain.EndpointResponses,
+	} {
+		route("POST /"+string(endpoint), handler.HandleEndpoint(endpoint))
+		route("POST /v1/"+string(endpoint), handler.HandleEndpoint(endpoint))
+	}
+	models := handlers.NewModelsHandler(proxy.service)
+	route("GET /models", models.HandleModels)
+	route("GET /v1/models", models.HandleModels)
+	if proxy.experiments != nil {
+		// Results are served on the admin API, clients only send feedback
+		experiments := handlers.NewExperimentsHandler(proxy.experiments)
+		route("POST /experiments/feedback", experiments.HandleFeedback)
+	}
+
+	// Health check for Docker/K8s
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	return mux
+}
+
+// buildApp wires the guardrail, upstream and caches into the service.
+func buildApp(cfg Config) (*app, error) {
+	guardrailConfig := adapters.GuardrailConfig{
+		BaseURL:        cfg.GuardrailURL,
+		Timeout:        time.Duration(cfg.GuarailTimeout) * time.Second,
+		MaxConcurrency: cfg.GuardrailConcurrency,
+		JudgeTimeout:   time.Duration(cfg.JudgeTimeout) * time.Second,
+	}
+	llmConfig := adapters.LLMConfig{
+		BaseURL: cfg.LLMURL,
+		APIKey:  cfg.LLMAPIKey,
+	}
+	llmAdapter := adapters.NewLLM(llmConfig)
+
+	guardrailAdapter, err := newGuardrail(cfg, guardrailConfig, llmAdapter)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GuardrailCacheSize > 0 {
+		log.Printf("Guardrail verdict cache: %d entries, TTL %ds", cfg.GuardrailCacheSize, cfg.GuardrailCacheTTL)
+		guardrailAdapter = adapters.NewCachedGuardrail(guardrailAdapter, adapters.GuardrailCacheConfig{
+			MaxEntries:    cfg.GuardrailCacheSize,
+			TTL:           time.Duration(cfg.GuardrailCacheTTL) * time.Second,
+			PolicyVersion: cfg.GuardrailPolicy,
+		})
+	}
+
+	// Dependency Injection happens here
+	service := core.NewBaldrService(guardrailAdapter, llmAdapter)
+	var responseGuardrail ports.GuardrailPort
+	if cfg.ResponseGuardrail != "" {
+		responseConfig := guardrailConfig
+		responseConfig.BaseURL = cfg.ResponseGuardrail
+		if responseGuardrail, err = adapters.NewGuardrail(responseConfig); err != nil {
+			return nil, fmt.Errorf("response guardrail: %w", err)
+		}
+		service.WithResponseGuardrail(responseGuardrail)
+		log.Printf("Response guardrail: %s", cfg.ResponseGuardrail)
+	}
+	if cfg.ResponseCacheSize > 0 {
+		log.Printf("Response cache: %d entries, %d bytes, TTL %ds", cfg.ResponseCacheSize, cfg.ResponseCacheBytes, cfg.ResponseCacheTTL)
+		service.WithResponseCache(adapters.NewResponseCache(adapters.ResponseCacheConfig{
+			MaxEntries: cfg.ResponseCacheSize,
+			MaxBytes:   cfg.ResponseCacheBytes,
+			TTL:        time.Duration(cfg.ResponseCacheTTL) * time.Second,
+		}), core.ResponseCacheConfig{
+			Enabled: cfg.ResponseCacheDefault,
+			Keys:    cfg.ResponseCacheKeys,
+		})
+	}
+
+	if cfg.SemanticCacheSize > 0 {
+		log.Printf("Semantic cache: %d entries per tenant, threshold %.2f, embeddings %s", cfg.SemanticCacheSize, cfg.SemanticThreshold, cfg.EmbeddingsModel)
+		service.WithSemanticCache(adapters.NewEmbeddings(adapters.EmbeddingsConfig{
+			BaseURL: cfg.EmbeddingsURL,
+			APIKey:  cfg.EmbeddingsAPIKey,
+			Model:   cfg.EmbeddingsModel,
+			Timeout: 5 * time.Second,
+		}), adapters.NewVectorIndex(adapters.VectorIndexConfig{
+			MaxEntriesPerScope: cfg.SemanticCacheSize,
+			TTL:                time.Duration(cfg.SemanticCacheTTL) * time.Second,
+		}), core.SemanticCacheConfig{
+			// Same switches as the exact-match cache
+			Enabled:   cfg.ResponseCacheDefault,
+			Keys:      cfg.ResponseCacheKeys,
+			Threshold: cfg.SemanticThreshold,
+		})
+	}
+
+	var pricing map[string]domain.ModelPrice
+	if cfg.ModelPricing != "" {
+		if pricing, err = adapters.LoadModelPricing(cfg.ModelPricing); err != nil {
+			return nil, err
+		}
+	}
+
+	var shadowStore *adapters.ShadowFileStore
+	if cfg.ShadowModel != "" && cfg.ShadowPercent > 0 {
+		if shadowStore, err = adapters.NewShadowFileStore(cfg.ShadowLog); err != nil {
+			return nil, err
+		}
+		log.Printf("Shadow traffic: %.1f%% mirrored to %s, recorded in %s", cfg.ShadowPercent, cfg.ShadowModel, cfg.ShadowLog)
+		service.WithShadow(adapters.NewLLM(adapters.LLMConfig{
+			BaseURL: cfg.ShadowLLMURL,
+			APIKey:  cfg.ShadowLLMAPIKey,
+		}), shadowStore, core.ShadowConfig{
+			Model:       cfg.ShadowModel,
+			Percent:     cfg.ShadowPercent,
+			Timeout:     time.Duration(cfg.ShadowTimeout) * time.Second,
+			MaxInFlight: cfg.ShadowMaxInFlight,
+			Pricing:     pricing,
+		})
+	}
+
+	usage, err := adapters.NewUsageLedger(adapters.UsageLedgerConfig{Path: cfg.UsageLog})
+	if err != nil {
+		return nil, err
+	}
+	a := &app{service: service, usage: usage, shadowStore: shadowStore}
+	if closer, ok := guardrailAdapter.(io.Closer); ok {
+		a.guardrail = closer
+	}
+	if closer, ok := responseGuardrail.(io.Closer); ok {
+		a.responseGuardrail = closer
+	}
+	service.WithUsage(a.usage, pricing)
+	if cfg.Experiments != "" {
+		experimentsConfig, err := adapters.LoadExperimentsConfig(cfg.Experiments)
+		if err != nil {
+			return nil, err
+		}
+		a.experiments = adapters.NewExperimentStats(adapters.ExperimentStatsConfig{})
+		service.WithExperiments(core.ExperimentsConfig{
+			Experiments: newExperiments(experimentsConfig),
+			Stats:       a.experiments,
+			Pricing:     pricing,
+		})
+		log.Printf("Experiments: %d loaded from %s", len(experimentsConfig.Experiments), cfg.Experiments)
+	}
+
+	catalogConfig := core.ModelCatalogConfig{Pricing: pricing}
+	if cfg.Models != "" {
+		modelsConfig, err := adapters.LoadModelCatalogConfig(cfg.Models)
+		if err != nil {
+			return nil, err
+		}
+		catalogConfig = newModelCatalog(modelsConfig, pricing)
+		log.Printf("Models: %d providers and %d aliases loaded from %s", len(modelsConfig.Providers), len(modelsConfig.Aliases), cfg.Models)
+	}
+	service.WithModelCatalog(catalogConfig)
+
+	switch policy := core.MaxTokensPolicy(cfg.MaxTokensPolicy); policy {
+	case core.MaxTokensClamp, core.MaxTokensReject, core.MaxTokensInject:
+		service.WithTokenLimits(core.TokenLimitsConfig{
+			Models:           catalogConfig.Metadata,
+			MaxTokens:        policy,
+			DefaultMaxTokens: cfg.DefaultMaxTokens,
+		})
+	default:
+		return nil, fmt.Errorf("MAX_TOKENS_POLICY must be clamp, reject or inject, got %q", policy)
+	}
+
+	if cfg.RequestPolicies != "" {
+		policies, err := adapters.LoadRequestPolicies(cfg.RequestPolicies)
+		if err != nil {
+			return nil, err
+		}
+		service.WithRequestPolicies(policies)
+		log.Printf("Request policies: %d loaded from %s", len(policies), cfg.RequestPolicies)
+	}
+	if cfg.ExpressionPolicies != "" {
+		engine, err := adapters.LoadExpressionPolicies(cfg.ExpressionPolicies)
+		if err != nil {
+			return nil, err
+		}
+		service.WithExpressionPolicies(engine)
+		log.Printf("Expression policies: loaded from %s", cfg.ExpressionPolicies)
+	}
+
+	if cfg.AdminPort != "" {
+		if cfg.AdminToken == "" {
+			return nil, fmt.Errorf("ADMIN_TOKEN is required with ADMIN_PORT")
+		}
+		if a.keyStore, err = adapters.NewKeyFileStore(adapters.KeyStoreConfig{Path: cfg.KeysFile, ReadOnly: cfg.KeysReadOnly}); err != nil {
+			return nil, err
+		}
+		a.keys = core.NewKeyManager(a.keyStore)
+		service.WithVirtualKeys(a.keys)
+	}
+	return a, nil
+}
+
+// newModelCatalog turns the models file into the service's catalog, giving
+// each provider a client of its own.
+func newModelCatalog(config adapters.ModelCatalogConfig, pricing map[string]domain.ModelPrice) core.ModelCatalogConfig {
+	catalog := core.ModelCatalogConfig{
+		Metadata: config.Models,
+		Access:   config.Access,
+		Pricing:  pricing,
+		Refresh:  time.Duration(config.RefreshSeconds) * time.Second,
+	}
+	for _, p := range config.Providers {
+		catalog.Providers = append(catalog.Providers, core.ModelProvider{
+			Name: p.Name,
+			LLM:  adapters.NewLLM(adapters.LLMConfig{BaseURL: p.URL, APIKey: os.Getenv(p.APIKeyEnv)}),
+		})
+	}
+	for _, a := range config.Aliases {
+		catalog.Aliases = append(catalog.Aliases, core.ModelAlias{Name: a.Name, Model: a.Model, Provider: a.Provider})
+	}
+	return catalog
+}
+
+// newExperiments turns the experiments file into the service's experiments,
+// giving variants on another upstream a client of their own.
+func newExperiments(config adapters.ExperimentsConfig) []core.Experiment {
+	experiments := make([]core.Experiment, 0, len(config.Experiments))
+	for _, e := range config.Experiments {
+		experiment := core.Experiment{Name: e.Name, Model: e.Model, Sticky: e.Sticky}
+		for _, v := range e.Variants {
+			variant := core.Variant{Name: v.Name, Model: v.Model, Weight: v.Weight}
+			if v.URL != "" {
+				variant.LLM = adapters.NewLLM(adapters.LLMConfig{BaseURL: v.URL, APIKey: os.Getenv(v.APIKeyEnv)})
+			}
+			experiment.Variants = append(experiment.Variants, variant)
+		}
+		experiments = append(experiments, experiment)
+	}
+	return experiments
+}
+
+// newGuardrail builds either the pipeline described by GUARDRAIL_PIPELINE or
+// the single guardrail at GUARDRAIL_URL, whose scheme selects the transport.
+func newGuardrail(cfg Config, base adapters.GuardrailConfig, llm ports.LLMPort) (ports.GuardrailPort, error) {
+	if cfg.GuardrailPipeline == "" {
+		return adapters.NewGuardrail(base)
+	}
+	pipelineConfig, err := adapters.LoadPipelineConfig(cfg.GuardrailPipeline)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Guardrail pipeline: %d stages (%s, %s)", len(pipelineConfig.Stages), pipelineConfig.Mode, pipelineConfig.Aggregation)
+	return adapters.NewGuardrailPipeline(pipelineConfig, base, llm)
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -121,3 +541,36 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+// getEnvSwitches parses a comma separated list of name=on|off pairs.
+func getEnvSwitches(key string) map[string]bool {
+	switches := make(map[string]bool)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && name != "" {
+			switches[name] = value == "on"
+		}
+	}
+	return switches
+}
+
+// getEnvLimits parses a comma separated list of route=bytes pairs.
+func getEnvLimits(key string) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		route, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if n, err := strconv.ParseInt(value, 10, 64); ok && err == nil {
+			limits[route] = n
+		}
+	}
+	return limits
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/replay"
+)
+
+// runReplay implements `baldr replay [flags] requests.jsonl...`. It sends
+// recorded requests to a running proxy, or through an in-process proxy
+// built from the usual environment, and prints a summary.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := fs.String("target", "http://localhost:8080/chat/completions", `proxy URL, or "service" to call the service in process`)
+	concurrency := fs.Int("concurrency", 4, "requests in flight at once")
+	rate := fs.Float64("rate", 0, "requests started per second, 0 for no limit")
+	record := fs.String("record", "", "file to write each result and response to, as JSONL")
+	model := fs.String("model", "gemini-2.5-flash", "model for lines that only hold a prompt")
+	key := fs.String("key", "", "Authorization bearer token for requests that don't set one")
+	timeout := fs.Duration("timeout", 2*time.Minute, "timeout of each request")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: baldr replay [flags] requests.jsonl...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var requests []replay.Request
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+		loaded, err := replay.Load(f, *model)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %s: %v\n", path, err)
+			return 1
+		}
+		requests = append(requests, loaded...)
+	}
+	if *key != "" {
+		for i := range requests {
+			if requests[i].Headers["Authorization"] == "" {
+				headers := map[string]string{"Authorization": "Bearer " + *key}
+				for k, v := range requests[i].Headers {
+					headers[k] = v
+				}
+				requests[i].Headers = headers
+			}
+		}
+	}
+
+	var t replay.Target
+	if *target == "service" {
+		cfg := loadConfig()
+		// Replayed traffic must not spend real keys' budgets, nor land in
+		// the usage and shadow logs of real traffic
+		cfg.UsageLog = ""
+		cfg.KeysReadOnly = true
+		cfg.ShadowModel = ""
+		proxy, err := buildApp(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+		defer proxy.Close()
+		t = &replay.ServiceTarget{Handler: newDataPlane(cfg, proxy), Timeout: *timeout}
+	} else {
+		t = &replay.HTTPTarget{URL: *target, Client: &http.Client{Timeout: *timeout}}
+	}
+
+	config := replay.RunConfig{Concurrency: *concurrency, Rate: *rate}
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		config.Record = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	results, err := replay.Run(ctx, t, requests, config)
+	report := replay.Summarize(results, time.Since(start))
+	report.Print(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package adapters
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Match is a span of text flagged by a detector. Offsets are byte offsets
+// into the inspected text.
+type Match struct {
+	Start    int
+	End      int
+	Detector string
+	Category string
+}
+
+// Detector finds spans of interest in a piece of prompt text.
+type Detector interface {
+	Name() string
+	Detect(text string) []Match
+}
+
+// regexDetector flags every match of a set of expressions, optionally
+// filtered by a validator (e.g. Luhn for credit cards).
+type regexDetector struct {
+	name     string
+	category string
+	patterns []*regexp.Regexp
+	validate func(match string) bool
+}
+
+func (d *regexDetector) Name() string { return d.name }
+
+func (d *regexDetector) Detect(text string) []Match {
+	var matches []Match
+	for _, re := range d.patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if d.validate != nil && !d.validate(text[loc[0]:loc[1]]) {
+				continue
+			}
+			matches = append(matches, Match{Start: loc[0], End: loc[1], Detector: d.name, Category: d.category})
+		}
+	}
+	return matches
+}
+
+func newKeywordDetector(name string, keywords []string, caseSensitive bool) (Detector, error) {
+	if len(keywords) == 0 {
+		return nil, fmt.Errorf("detector %q: keyword list is empty", name)
+	}
+	quoted := make([]string, len(keywords))
+	for i, k := range keywords {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	expr := strings.Join(quoted, "|")
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	return &regexDetector{
+		name:     name,
+		category: "KEYWORD",
+		patterns: []*regexp.Regexp{regexp.MustCompile(expr)},
+	}, nil
+}
+
+func newRegexDetector(name string, patterns []string) (Detector, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("detector %q: pattern list is empty", name)
+	}
+	d := &regexDetector{name: name, category: "PATTERN"}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("detector %q: %w", name, err)
+		}
+		d.patterns = append(d.patterns, re)
+	}
+	return d, nil
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// International numbers (+44 20 7946 0958) and the usual national
+	// layouts ((555) 123-4567, 555-123-4567). Bare digit runs are left to the
+	// card and IBAN detectors, they are too ambiguous to call phone numbers.
+	phonePattern = regexp.MustCompile(`\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)|\d{1,4})(?:[\s.-]?\d{2,4}){2,4}\b|\(\d{3}\)\s?\d{3}[.-]\d{4}\b|\b\d{3}[.-]\d{3}[.-]\d{4}\b`)
+	ibanPattern  = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?:\s?[A-Z0-9]{4}){2,7}(?:\s?[A-Z0-9]{1,3})?\b`)
+	cardPattern  = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+)
+
+func newEmailDetector(name string) Detector {
+	return &regexDetector{name: name, category: "EMAIL", patterns: []*regexp.Regexp{emailPattern}}
+}
+
+func newPhoneDetector(name string) Detector {
+	return &regexDetector{
+		name:     name,
+		category: "PHONE",
+		patterns: []*regexp.Regexp{phonePattern},
+		validate: func(m string) bool {
+			n := len(digitsOnly(m))
+			return n >= 8 && n <= 15 // E.164 allows at most 15 digits
+		},
+	}
+}
+
+func newIBANDetector(name string) Detector {
+	return &regexDetector{name: name, category: "IBAN", patterns: []*regexp.Regexp{ibanPattern}, validate: validIBAN}
+}
+
+func newCreditCardDetector(name string) Detector {
+	return &regexDetector{name: name, category: "CREDIT_CARD", patterns: []*regexp.Regexp{cardPattern}, validate: validLuhn}
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// validLuhn rejects digit runs that merely look like card numbers.
+func validLuhn(s string) bool {
+	digits := digitsOnly(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validIBAN applies the ISO 13616 mod-97 check.
+func validIBAN(s string) bool {
+	iban := strings.ReplaceAll(s, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	// Move the country code and check digits to the end, then map letters
+	// to numbers (A=10 ... Z=35).
+	rearranged := iban[4:] + iban[:4]
+	var numeric strings.Builder
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&numeric, "%d", r-'A'+10)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
@@ -0,0 +1,69 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type EmbeddingsConfig struct {
+	BaseURL string // OpenAI-compatible embeddings endpoint
+	APIKey  string
+	Model   string
+	Timeout time.Duration
+}
+
+// Embeddings calls an OpenAI-compatible /embeddings endpoint.
+type Embeddings struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func NewEmbeddings(config EmbeddingsConfig) *Embeddings {
+	return &Embeddings{
+		client:  &http.Client{Timeout: config.Timeout},
+		baseURL: config.BaseURL,
+		apiKey:  config.APIKey,
+		model:   config.Model,
+	}
+}
+
+func (a *Embeddings) Embed(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(map[string]string{"model": a.model, "input": text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embeddings request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings provider returned status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings response: %w", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embeddings provider returned no vector")
+	}
+	return result.Data[0].Embedding, nil
+}
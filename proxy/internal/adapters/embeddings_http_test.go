@@ -0,0 +1,51 @@
+package adapters_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+)
+
+func TestEmbeddings_Embed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "text-embedding-004" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Input == "" {
+			w.Write([]byte(`{"data": []}`))
+			return
+		}
+		w.Write([]byte(`{"data": [{"embedding": [0.1, 0.2, 0.3]}]}`))
+	}))
+	defer srv.Close()
+
+	embeddings := adapters.NewEmbeddings(adapters.EmbeddingsConfig{
+		BaseURL: srv.URL,
+		APIKey:  "secret",
+		Model:   "text-embedding-004",
+		Timeout: time.Second,
+	})
+
+	vector, err := embeddings.Embed(context.Background(), "How do I get a refund?")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(vector) != 3 || vector[2] != 0.3 {
+		t.Errorf("Unexpected vector %v", vector)
+	}
+
+	if _, err := embeddings.Embed(context.Background(), ""); err == nil {
+		t.Error("Expected an error for an empty response")
+	}
+}
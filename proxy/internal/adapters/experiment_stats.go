@@ -0,0 +1,224 @@
+package adapters
+
+import (
+	"cmp"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// ExperimentsConfig is the JSON file describing the A/B experiments.
+type ExperimentsConfig struct {
+	Experiments []ExperimentConfig `json:"experiments"`
+}
+
+type ExperimentConfig struct {
+	Name     string          `json:"name"`
+	Model    string          `json:"model"`  // Logical model clients ask for
+	Sticky   string          `json:"sticky"` // "key" (default) or "user"
+	Variants []VariantConfig `json:"variants"`
+}
+
+type VariantConfig struct {
+	Name   string  `json:"name"`
+	Model  string  `json:"model"`
+	Weight float64 `json:"weight"`
+	// URL and APIKeyEnv point the variant at another OpenAI compatible
+	// upstream. The key is read from the named environment variable.
+	URL       string `json:"url,omitempty"`
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+}
+
+func LoadExperimentsConfig(path string) (ExperimentsConfig, error) {
+	var config ExperimentsConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read experiments config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse experiments config: %w", err)
+	}
+	models := make(map[string]bool)
+	for _, e := range config.Experiments {
+		if e.Name == "" || e.Model == "" {
+			return config, fmt.Errorf("experiment needs a name and a model")
+		}
+		if models[e.Model] {
+			return config, fmt.Errorf("model %q is in more than one experiment", e.Model)
+		}
+		models[e.Model] = true
+		if e.Sticky != "" && e.Sticky != "key" && e.Sticky != "user" {
+			return config, fmt.Errorf("experiment %q: sticky must be key or user, got %q", e.Name, e.Sticky)
+		}
+		if len(e.Variants) == 0 {
+			return config, fmt.Errorf("experiment %q has no variants", e.Name)
+		}
+		for _, v := range e.Variants {
+			if v.Name == "" || v.Model == "" || v.Weight <= 0 {
+				return config, fmt.Errorf("experiment %q: variants need a name, a model and a positive weight", e.Name)
+			}
+		}
+	}
+	return config, nil
+}
+
+// ExperimentStats aggregates experiment outcomes in memory. Latency
+// percentiles are computed over the most recent samples of each variant, and
+// feedback is accepted for the most recent requests only.
+type ExperimentStats struct {
+	maxSamples  int
+	maxRequests int
+
+	mu       sync.Mutex
+	variants map[variantKey]*variantStats
+	requests map[string]*list.Element // Request ID to its requestEntry
+	order    *list.List               // Oldest request at the front
+}
+
+type variantKey struct{ experiment, variant string }
+
+type variantStats struct {
+	model      string
+	requests   int
+	errors     int
+	latencySum time.Duration
+	samples    []time.Duration // Ring of the most recent latencies
+	next       int
+	cost       float64
+	feedback   int
+	scoreSum   float64
+}
+
+type requestEntry struct {
+	id    string
+	key   variantKey
+	score float64
+	rated bool
+}
+
+type ExperimentStatsConfig struct {
+	// MaxSamples of latency kept per variant for percentiles, 0 means 1000
+	MaxSamples int
+	// MaxRequests remembered for feedback, 0 means 100000
+	MaxRequests int
+}
+
+func NewExperimentStats(config ExperimentStatsConfig) *ExperimentStats {
+	if config.MaxSamples < 1 {
+		config.MaxSamples = 1000
+	}
+	if config.MaxRequests < 1 {
+		config.MaxRequests = 100000
+	}
+	return &ExperimentStats{
+		maxSamples:  config.MaxSamples,
+		maxRequests: config.MaxRequests,
+		variants:    make(map[variantKey]*variantStats),
+		requests:    make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+func (s *ExperimentStats) Record(outcome domain.ExperimentOutcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := variantKey{outcome.Experiment, outcome.Variant}
+	v, ok := s.variants[key]
+	if !ok {
+		v = &variantStats{}
+		s.variants[key] = v
+	}
+	v.model = outcome.Model
+	v.requests++
+	if outcome.Failed {
+		v.errors++
+	}
+	v.latencySum += outcome.Latency
+	if len(v.samples) < s.maxSamples {
+		v.samples = append(v.samples, outcome.Latency)
+	} else {
+		v.samples[v.next] = outcome.Latency
+		v.next = (v.next + 1) % s.maxSamples
+	}
+	v.cost += outcome.CostUSD
+
+	if outcome.RequestID == "" {
+		return
+	}
+	if _, seen := s.requests[outcome.RequestID]; seen {
+		return
+	}
+	s.requests[outcome.RequestID] = s.order.PushBack(&requestEntry{id: outcome.RequestID, key: key})
+	for s.order.Len() > s.maxRequests {
+		oldest := s.order.Remove(s.order.Front()).(*requestEntry)
+		delete(s.requests, oldest.id)
+	}
+}
+
+func (s *ExperimentStats) Feedback(requestID string, score float64) error {
+	if score < 0 || score > 1 || math.IsNaN(score) {
+		return fmt.Errorf("score must be between 0 and 1, got %v", score)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.requests[requestID]
+	if !ok {
+		return domain.ErrUnknownRequest
+	}
+	entry := el.Value.(*requestEntry)
+	v := s.variants[entry.key]
+	if entry.rated {
+		v.scoreSum -= entry.score
+	} else {
+		v.feedback++
+	}
+	entry.score, entry.rated = score, true
+	v.scoreSum += score
+	return nil
+}
+
+func (s *ExperimentStats) Results() []domain.VariantStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]domain.VariantStats, 0, len(s.variants))
+	for key, v := range s.variants {
+		stats := domain.VariantStats{
+			Experiment:     key.experiment,
+			Variant:        key.variant,
+			Model:          v.model,
+			Requests:       v.requests,
+			Errors:         v.errors,
+			ErrorRate:      float64(v.errors) / float64(v.requests),
+			LatencyMeanMs:  milliseconds(v.latencySum / time.Duration(v.requests)),
+			CostUSD:        v.cost,
+			CostPerRequest: v.cost / float64(v.requests),
+			Feedback:       v.feedback,
+		}
+		if v.feedback > 0 {
+			stats.MeanScore = v.scoreSum / float64(v.feedback)
+		}
+		sorted := slices.Clone(v.samples)
+		slices.Sort(sorted)
+		stats.LatencyP50Ms = milliseconds(domain.Percentile(sorted, 0.50))
+		stats.LatencyP95Ms = milliseconds(domain.Percentile(sorted, 0.95))
+		results = append(results, stats)
+	}
+	slices.SortFunc(results, func(a, b domain.VariantStats) int {
+		return cmp.Or(cmp.Compare(a.Experiment, b.Experiment), cmp.Compare(a.Variant, b.Variant))
+	})
+	return results
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
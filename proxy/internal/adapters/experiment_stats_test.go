@@ -0,0 +1,72 @@
+package adapters_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func TestExperimentStats(t *testing.T) {
+	stats := adapters.NewExperimentStats(adapters.ExperimentStatsConfig{MaxRequests: 3})
+	for i, latency := range []time.Duration{10, 20, 30, 40} {
+		stats.Record(domain.ExperimentOutcome{
+			RequestID:  string(rune('a' + i)),
+			Experiment: "ab",
+			Variant:    "control",
+			Model:      "m",
+			Latency:    latency * time.Millisecond,
+			CostUSD:    0.5,
+			Failed:     i == 3,
+		})
+	}
+	stats.Record(domain.ExperimentOutcome{RequestID: "e", Experiment: "ab", Variant: "mini", Model: "n", Latency: time.Millisecond})
+
+	if err := stats.Feedback("b", 1); !errors.Is(err, domain.ErrUnknownRequest) {
+		t.Errorf("Expected the oldest requests to be forgotten, got %v", err)
+	}
+	if err := stats.Feedback("c", 2); err == nil {
+		t.Error("Expected a score above 1 to be rejected")
+	}
+	stats.Feedback("c", 0)
+	stats.Feedback("d", 0)
+	stats.Feedback("d", 1) // Replaces the earlier score
+
+	results := stats.Results()
+	if len(results) != 2 || results[0].Variant != "control" || results[1].Variant != "mini" {
+		t.Fatalf("Expected both variants in order, got %+v", results)
+	}
+	control := results[0]
+	if control.Requests != 4 || control.Errors != 1 || control.ErrorRate != 0.25 {
+		t.Errorf("Unexpected counts: %+v", control)
+	}
+	if control.LatencyMeanMs != 25 || control.LatencyP50Ms != 20 || control.LatencyP95Ms != 40 {
+		t.Errorf("Unexpected latency: %+v", control)
+	}
+	if control.CostUSD != 2 || control.CostPerRequest != 0.5 {
+		t.Errorf("Unexpected cost: %+v", control)
+	}
+	if control.Feedback != 2 || control.MeanScore != 0.5 {
+		t.Errorf("Unexpected feedback: %+v", control)
+	}
+}
+
+func TestLoadExperimentsConfig(t *testing.T) {
+	config, err := adapters.LoadExperimentsConfig("../../config/experiments.example.json")
+	if err != nil {
+		t.Fatalf("Failed to load the example: %v", err)
+	}
+	if len(config.Experiments) != 1 || len(config.Experiments[0].Variants) != 2 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	path := filepath.Join(t.TempDir(), "experiments.json")
+	os.WriteFile(path, []byte(`{"experiments": [{"name": "ab", "model": "chat", "variants": [{"name": "a", "model": "m", "weight": 0}]}]}`), 0o600)
+	if _, err := adapters.LoadExperimentsConfig(path); err == nil {
+		t.Error("Expected a zero weight to be rejected")
+	}
+}
@@ -0,0 +1,95 @@
+package adapters
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// NewGuardrail picks the guardrail transport from the scheme of BaseURL:
+// http(s):// uses JSON over HTTP, unix:// uses JSON over HTTP on a Unix
+// domain socket and grpc:// uses the protobuf contract. native:// runs the
+// in-process Go engine, configured by the JSON file in the URL path or with
+// the default detectors when the path is empty (native:///etc/baldr/native.json).
+// injection:// scores prompts for injection attempts, configured the same way.
+// wasm:// runs the WebAssembly plugin in the URL path, with optional
+// memory_mb and timeout_ms limits (wasm:///etc/baldr/pii.wasm?memory_mb=32).
+func NewGuardrail(config GuardrailConfig) (ports.GuardrailPort, error) {
+	u, err := url.Parse(config.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid guardrail url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "unix":
+		return NewRemoteGuardrail(config), nil
+	case "grpc":
+		return NewGRPCGuardrail(config)
+	case "native":
+		nativeConfig := DefaultNativeGuardrailConfig()
+		if u.Path != "" {
+			if nativeConfig, err = LoadNativeGuardrailConfig(u.Path); err != nil {
+				return nil, err
+			}
+		}
+		return NewNativeGuardrail(nativeConfig)
+	case "injection":
+		injectionConfig := DefaultInjectionGuardrailConfig()
+		if u.Path != "" {
+			if injectionConfig, err = LoadInjectionGuardrailConfig(u.Path); err != nil {
+				return nil, err
+			}
+		}
+		return NewInjectionGuardrail(injectionConfig)
+	case "wasm":
+		wasmConfig := WASMGuardrailConfig{Path: u.Path, MaxConcurrency: config.MaxConcurrency}
+		query := u.Query()
+		if v := query.Get("memory_mb"); v != "" {
+			if wasmConfig.MemoryLimitMB, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid wasm guardrail memory_mb %q", v)
+			}
+		}
+		if v := query.Get("timeout_ms"); v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wasm guardrail timeout_ms %q", v)
+			}
+			wasmConfig.Timeout = time.Duration(ms) * time.Millisecond
+		}
+		return NewWASMGuardrail(wasmConfig)
+	default:
+		return nil, fmt.Errorf("unsupported guardrail scheme: %q", u.Scheme)
+	}
+}
+
+// closeGuardrail releases what g holds, when it holds anything: wasm
+// runtimes, gRPC connections, or stages that do.
+func closeGuardrail(g ports.GuardrailPort) error {
+	if closer, ok := g.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// checkContractVersion rejects verdicts written against a newer schema than
+// the proxy knows: we couldn't tell which fields we'd be ignoring, so the
+// request fails closed. No version at all means a version 1 sidecar.
+func checkContractVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return fmt.Errorf("invalid guardrail contract version %q", version)
+	}
+	latest, _ := strconv.Atoi(domain.GuardrailContractVersion)
+	if v > latest {
+		return fmt.Errorf("unsupported guardrail contract version %q (latest known: %s)", version, domain.GuardrailContractVersion)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package adapters
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+type GuardrailCacheConfig struct {
+	MaxEntries    int
+	TTL           time.Duration
+	PolicyVersion string
+}
+
+// CachedGuardrail sits in front of another GuardrailPort and reuses allowed
+// verdicts for payloads it has already seen under the same policy version.
+// Blocked verdicts are never cached so a policy fix takes effect immediately.
+//
+// Policy versions only move forward: once superseded, a version is retired,
+// and verdicts an old replica still reaches under it are neither cached nor
+// let it come back.
+type CachedGuardrail struct {
+	next       ports.GuardrailPort
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu            sync.Mutex
+	policyVersion string
+	retired       map[string]bool
+	lru           *list.List // Front is the most recently used entry
+	entries       map[string]*list.Element
+}
+
+// policyVersioner is a guardrail that can tell its current policy version
+// without a verdict, so the cache drops hot entries as soon as it changes.
+type policyVersioner interface {
+	PolicyVersion(ctx context.Context) string
+}
+
+type verdictEntry struct {
+	key       string
+	verdict   domain.GuardrailResponse
+	expiresAt time.Time
+}
+
+func NewCachedGuardrail(next ports.GuardrailPort, config GuardrailCacheConfig) *CachedGuardrail {
+	return &CachedGuardrail{
+		next:          next,
+		maxEntries:    config.MaxEntries,
+		ttl:           config.TTL,
+		now:           time.Now,
+		policyVersion: config.PolicyVersion,
+		retired:       make(map[string]bool),
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+}
+
+func (c *CachedGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	normalized := normalizeJSON(payload)
+	// Different policies, and per-key thresholds, can reach different
+	// verdicts on the same payload
+	md := domain.RequestMetadataFrom(ctx)
+	scope := md.PolicyID + "\x00" + md.KeyID
+
+	var current string
+	if versioner, ok := c.next.(policyVersioner); ok {
+		current = versioner.PolicyVersion(ctx)
+	}
+
+	c.mu.Lock()
+	if current != "" {
+		c.advance(current)
+	}
+	started := c.policyVersion
+	cached, ok := c.get(cacheKey(started, scope, normalized))
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	verdict, err := c.next.Validate(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The sidecar reports which policy produced the verdict. A new version
+	// means every cached verdict may be stale.
+	version := verdict.PolicyVersion
+	if version == "" {
+		version = started
+	}
+	c.advance(version)
+	if version == c.policyVersion && verdict.EffectiveAction() != domain.ActionBlock {
+		c.put(cacheKey(version, scope, normalized), cloneVerdict(verdict))
+	}
+
+	return verdict, nil
+}
+
+// advance moves the cache to a policy version it hasn't retired, dropping
+// every cached verdict. c.mu held.
+func (c *CachedGuardrail) advance(version string) {
+	if version == c.policyVersion || c.retired[version] {
+		return
+	}
+	if c.policyVersion != "" {
+		c.retired[c.policyVersion] = true
+	}
+	c.policyVersion = version
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// Close releases the guardrail behind the cache.
+func (c *CachedGuardrail) Close() error {
+	return closeGuardrail(c.next)
+}
+
+// Len returns the number of cached verdicts, including expired ones that
+// have not been evicted yet.
+func (c *CachedGuardrail) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *CachedGuardrail) get(key string) (*domain.GuardrailResponse, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*verdictEntry)
+	if c.ttl > 0 && c.now().After(entry.expiresAt) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+
+	// Hand out a copy so callers can't mutate the cached verdict
+	return cloneVerdict(&entry.verdict), true
+}
+
+func (c *CachedGuardrail) put(key string, verdict *domain.GuardrailResponse) {
+	if c.maxEntries <= 0 {
+		return
+	}
+	entry := &verdictEntry{key: key, verdict: *verdict, expiresAt: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*verdictEntry).key)
+	}
+}
+
+// cloneVerdict copies a verdict deeply, so the cache and its callers never
+// share its slices and maps.
+func cloneVerdict(v *domain.GuardrailResponse) *domain.GuardrailResponse {
+	clone := *v
+	clone.SanitizedInput = bytes.Clone(v.SanitizedInput)
+	clone.Tokens = maps.Clone(v.Tokens)
+	clone.Stages = slices.Clone(v.Stages)
+	clone.Violations = slices.Clone(v.Violations)
+	for i := range clone.Violations {
+		clone.Violations[i].Spans = slices.Clone(clone.Violations[i].Spans)
+	}
+	return &clone
+}
+
+// cacheKey hashes the normalized payload together with the policy and the
+// caller it was checked for.
+func cacheKey(policyVersion, scope string, normalized []byte) string {
+	h := sha256.New()
+	h.Write([]byte(policyVersion))
+	h.Write([]byte{0})
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeJSON re-encodes a JSON payload so that two bodies differing only
+// in whitespace or key order produce the same bytes.
+func normalizeJSON(payload []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return payload
+	}
+	// encoding/json sorts map keys, which gives us a canonical form
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return payload
+	}
+	return normalized
+}
@@ -0,0 +1,152 @@
+package adapters_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// countingGuardrail records how many times the wrapped guardrail is reached
+type countingGuardrail struct {
+	calls   int
+	verdict domain.GuardrailResponse
+}
+
+func (g *countingGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	g.calls++
+	verdict := g.verdict
+	return &verdict, nil
+}
+
+func TestCachedGuardrail_ReusesAllowedVerdicts(t *testing.T) {
+	next := &countingGuardrail{verdict: domain.GuardrailResponse{Allowed: true}}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 10, TTL: time.Minute})
+
+	cache.Validate(context.Background(), []byte(`{"model": "m", "messages": []}`))
+	// Same payload with different whitespace and key order
+	cache.Validate(context.Background(), []byte(`{"messages":[],"model":"m"}`))
+
+	if next.calls != 1 {
+		t.Errorf("Expected 1 call to the sidecar, got %d", next.calls)
+	}
+}
+
+func TestCachedGuardrail_SkipsBlockedVerdicts(t *testing.T) {
+	next := &countingGuardrail{verdict: domain.GuardrailResponse{Allowed: false, Reason: "nope"}}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 10, TTL: time.Minute})
+
+	cache.Validate(context.Background(), []byte(`{"prompt": "ATTACK"}`))
+	cache.Validate(context.Background(), []byte(`{"prompt": "ATTACK"}`))
+
+	if next.calls != 2 {
+		t.Errorf("Blocked verdicts must not be cached, got %d sidecar calls", next.calls)
+	}
+}
+
+func TestCachedGuardrail_EvictsLeastRecentlyUsed(t *testing.T) {
+	next := &countingGuardrail{verdict: domain.GuardrailResponse{Allowed: true}}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 2, TTL: time.Minute})
+
+	cache.Validate(context.Background(), []byte(`"a"`))
+	cache.Validate(context.Background(), []byte(`"b"`))
+	cache.Validate(context.Background(), []byte(`"a"`)) // "b" is now the oldest
+	cache.Validate(context.Background(), []byte(`"c"`))
+	cache.Validate(context.Background(), []byte(`"a"`))
+
+	if next.calls != 3 {
+		t.Errorf("Expected 3 sidecar calls, got %d", next.calls)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected cache to be bounded to 2 entries, got %d", cache.Len())
+	}
+}
+
+func TestCachedGuardrail_PolicyChangeInvalidates(t *testing.T) {
+	next := &countingGuardrail{verdict: domain.GuardrailResponse{Allowed: true, PolicyVersion: "1"}}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 10, TTL: time.Minute, PolicyVersion: "1"})
+
+	cache.Validate(context.Background(), []byte(`"a"`))
+	cache.Validate(context.Background(), []byte(`"b"`))
+
+	// The sidecar rolls out a new policy and reports it with the next verdict
+	next.verdict.PolicyVersion = "2"
+	cache.Validate(context.Background(), []byte(`"c"`))
+	if cache.Len() != 1 {
+		t.Errorf("Expected stale verdicts to be dropped, cache has %d entries", cache.Len())
+	}
+}
+
+func TestCachedGuardrail_IgnoresRetiredPolicies(t *testing.T) {
+	next := &countingGuardrail{verdict: domain.GuardrailResponse{Allowed: true, PolicyVersion: "2"}}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 10, TTL: time.Minute, PolicyVersion: "1"})
+
+	cache.Validate(context.Background(), []byte(`"a"`))
+
+	// A replica still on the old policy answers late during the rollout
+	next.verdict.PolicyVersion = "1"
+	cache.Validate(context.Background(), []byte(`"b"`))
+	if cache.Len() != 1 {
+		t.Errorf("Expected the stale verdict to stay out of the cache, got %d entries", cache.Len())
+	}
+
+	next.verdict.PolicyVersion = "2"
+	cache.Validate(context.Background(), []byte(`"a"`))
+	if next.calls != 2 {
+		t.Errorf("Expected the cache to keep the new policy's verdicts, got %d sidecar calls", next.calls)
+	}
+}
+
+// versionedGuardrail advertises its policy version ahead of any verdict
+type versionedGuardrail struct {
+	countingGuardrail
+	version string
+}
+
+func (g *versionedGuardrail) PolicyVersion(ctx context.Context) string {
+	return g.version
+}
+
+func TestCachedGuardrail_AdvertisedPolicyInvalidatesHotEntries(t *testing.T) {
+	next := &versionedGuardrail{countingGuardrail: countingGuardrail{verdict: domain.GuardrailResponse{Allowed: true, PolicyVersion: "1"}}, version: "1"}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 10, TTL: time.Minute, PolicyVersion: "1"})
+
+	cache.Validate(context.Background(), []byte(`"a"`))
+	cache.Validate(context.Background(), []byte(`"a"`))
+	if next.calls != 1 {
+		t.Fatalf("Expected the second lookup to hit, got %d sidecar calls", next.calls)
+	}
+
+	// The entry is hot, yet the new policy must judge it again
+	next.version, next.verdict.PolicyVersion = "2", "2"
+	cache.Validate(context.Background(), []byte(`"a"`))
+	if next.calls != 2 {
+		t.Errorf("Expected a new policy to invalidate hot entries, got %d sidecar calls", next.calls)
+	}
+}
+
+func TestCachedGuardrail_CopiesVerdicts(t *testing.T) {
+	next := &countingGuardrail{verdict: domain.GuardrailResponse{
+		Allowed:    true,
+		Action:     domain.ActionRedact,
+		Violations: []domain.Violation{{Category: "pii", Spans: []domain.Span{{Path: "/prompt", Start: 0, End: 4}}}},
+		Tokens:     map[string]string{"[EMAIL_1]": "a@b.c"},
+		Stages:     []domain.StageVerdict{{Name: "pii"}},
+	}}
+	cache := adapters.NewCachedGuardrail(next, adapters.GuardrailCacheConfig{MaxEntries: 10, TTL: time.Minute})
+
+	first, _ := cache.Validate(context.Background(), []byte(`"a"`))
+	first.Violations[0].Spans[0].End = 99
+	first.Tokens["[EMAIL_1]"] = "x@y.z"
+	first.Stages[0].Name = "changed"
+
+	second, _ := cache.Validate(context.Background(), []byte(`"a"`))
+	if next.calls != 1 {
+		t.Fatalf("Expected a cache hit, got %d sidecar calls", next.calls)
+	}
+	if second.Violations[0].Spans[0].End != 4 || second.Tokens["[EMAIL_1]"] != "a@b.c" || second.Stages[0].Name != "pii" {
+		t.Errorf("Expected the cached verdict to be unaffected by callers, got %+v", second)
+	}
+}
@@ -0,0 +1,359 @@
+package adapters
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// PipelineMode controls how the stages of a CompositeGuardrail run.
+type PipelineMode string
+
+const (
+	// PipelineSerial runs stages one after the other, each one seeing the
+	// payload as redacted by the stages before it.
+	PipelineSerial PipelineMode = "serial"
+	// PipelineParallel runs every stage on the original payload at once.
+	PipelineParallel PipelineMode = "parallel"
+)
+
+// AggregationPolicy turns the per-stage verdicts into the final one.
+type AggregationPolicy string
+
+const (
+	AggregateAnyBlock AggregationPolicy = "any_block" // A single block wins
+	AggregateMajority AggregationPolicy = "majority"  // More than half of the stages must block
+	AggregateWeighted AggregationPolicy = "weighted"  // Blocking weight share must reach Threshold
+)
+
+type GuardrailStage struct {
+	Name      string
+	Guardrail ports.GuardrailPort
+	Weight    float64 // Only used by AggregateWeighted, defaults to 1
+}
+
+type CompositeConfig struct {
+	Mode        PipelineMode
+	Aggregation AggregationPolicy
+	// Threshold is the share of the total weight that must block for
+	// AggregateWeighted to block. Defaults to 0.5.
+	Threshold float64
+}
+
+// CompositeGuardrail composes several guardrails into one. Any stage error
+// fails the whole pipeline, so the service still fails closed.
+type CompositeGuardrail struct {
+	stages []GuardrailStage
+	config CompositeConfig
+}
+
+func NewCompositeGuardrail(stages []GuardrailStage, config CompositeConfig) (*CompositeGuardrail, error) {
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("guardrail pipeline has no stages")
+	}
+	if config.Mode == "" {
+		config.Mode = PipelineSerial
+	}
+	if config.Aggregation == "" {
+		config.Aggregation = AggregateAnyBlock
+	}
+	if config.Threshold == 0 {
+		config.Threshold = 0.5
+	}
+	switch config.Mode {
+	case PipelineSerial, PipelineParallel:
+	default:
+		return nil, fmt.Errorf("unknown pipeline mode %q", config.Mode)
+	}
+	switch config.Aggregation {
+	case AggregateAnyBlock, AggregateMajority, AggregateWeighted:
+	default:
+		return nil, fmt.Errorf("unknown aggregation policy %q", config.Aggregation)
+	}
+
+	for i := range stages {
+		if stages[i].Weight == 0 {
+			stages[i].Weight = 1
+		}
+	}
+	return &CompositeGuardrail{stages: stages, config: config}, nil
+}
+
+// Close releases every stage, and reports all of them that failed.
+func (g *CompositeGuardrail) Close() error {
+	var errs []error
+	for _, stage := range g.stages {
+		if err := closeGuardrail(stage.Guardrail); err != nil {
+			errs = append(errs, fmt.Errorf("guardrail stage %q: %w", stage.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stageResult pairs a stage with its raw verdict.
+type stageResult struct {
+	stage   GuardrailStage
+	verdict *domain.GuardrailResponse
+	latency time.Duration
+}
+
+func (g *CompositeGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	var (
+		results []stageResult
+		final   []byte
+		err     error
+		// Collects the placeholders of every stage, stages sharing the
+		// request's vault can't clash but remote ones mint their own
+		tokens = domain.NewVault()
+	)
+	if g.config.Mode == PipelineParallel {
+		results, final, err = g.runParallel(ctx, payload, tokens)
+	} else {
+		results, final, err = g.runSerial(ctx, payload, tokens)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := g.aggregate(results)
+	if response.Allowed && string(final) != string(payload) {
+		response.SanitizedInput = final
+		if tokens.Len() > 0 {
+			response.Tokens = tokens.Tokens()
+		}
+	}
+	return response, nil
+}
+
+func (g *CompositeGuardrail) runSerial(ctx context.Context, payload []byte, tokens *domain.Vault) ([]stageResult, []byte, error) {
+	current := payload
+	results := make([]stageResult, 0, len(g.stages))
+	for _, stage := range g.stages {
+		result, err := runStage(ctx, stage, current)
+		if err != nil {
+			return nil, nil, err
+		}
+		results = append(results, result)
+
+		if result.verdict.EffectiveAction() == domain.ActionBlock && g.config.Aggregation == AggregateAnyBlock {
+			// The outcome is settled, skip the remaining stages
+			break
+		}
+		if result.verdict.HasSanitizedInput() {
+			current = result.verdict.SanitizedInput
+			if err := mergeStageTokens(tokens, stage, result.verdict); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return results, current, nil
+}
+
+func (g *CompositeGuardrail) runParallel(ctx context.Context, payload []byte, tokens *domain.Vault) ([]stageResult, []byte, error) {
+	results := make([]stageResult, len(g.stages))
+	errs := make([]error, len(g.stages))
+
+	var wg sync.WaitGroup
+	for i, stage := range g.stages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = runStage(ctx, stage, payload)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Every stage saw the original payload, so redactions can't simply be
+	// stacked. Take the first one and push it through the other redacting
+	// stages so no detected span leaks.
+	current := payload
+	redacted := 0
+	for i, result := range results {
+		if result.verdict.EffectiveAction() == domain.ActionBlock || !result.verdict.HasSanitizedInput() {
+			continue
+		}
+		if redacted == 0 {
+			current = result.verdict.SanitizedInput
+			if err := mergeStageTokens(tokens, result.stage, result.verdict); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			again, err := result.stage.Guardrail.Validate(ctx, current)
+			if err != nil {
+				return nil, nil, fmt.Errorf("guardrail stage %q: %w", result.stage.Name, err)
+			}
+			if again.EffectiveAction() == domain.ActionBlock {
+				// What the stage blocks on the redacted payload is its verdict
+				results[i].verdict = again
+				continue
+			}
+			if again.HasSanitizedInput() {
+				current = again.SanitizedInput
+				if err := mergeStageTokens(tokens, result.stage, again); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		redacted++
+	}
+	return results, current, nil
+}
+
+func mergeStageTokens(tokens *domain.Vault, stage GuardrailStage, verdict *domain.GuardrailResponse) error {
+	if err := tokens.Merge(verdict.Tokens); err != nil {
+		return fmt.Errorf("guardrail stage %q: %w", stage.Name, err)
+	}
+	return nil
+}
+
+func runStage(ctx context.Context, stage GuardrailStage, payload []byte) (stageResult, error) {
+	start := time.Now()
+	verdict, err := stage.Guardrail.Validate(ctx, payload)
+	if err != nil {
+		return stageResult{}, fmt.Errorf("guardrail stage %q: %w", stage.Name, err)
+	}
+	return stageResult{stage: stage, verdict: verdict, latency: time.Since(start)}, nil
+}
+
+func (g *CompositeGuardrail) aggregate(results []stageResult) *domain.GuardrailResponse {
+	response := &domain.GuardrailResponse{Version: domain.GuardrailContractVersion}
+
+	var (
+		reasons       []string
+		versions      []string
+		blocks        int
+		blockedWeight float64
+		totalWeight   float64
+	)
+	for _, r := range g.stages {
+		totalWeight += r.Weight
+	}
+	for _, r := range results {
+		response.Stages = append(response.Stages, domain.StageVerdict{
+			Name:     r.stage.Name,
+			Allowed:  r.verdict.EffectiveAction() != domain.ActionBlock,
+			Action:   r.verdict.EffectiveAction(),
+			Reason:   r.verdict.Reason,
+			Redacted: r.verdict.HasSanitizedInput(),
+			Latency:  r.latency,
+		})
+		response.Violations = append(response.Violations, r.verdict.Violations...)
+		if r.verdict.PolicyVersion != "" {
+			versions = append(versions, r.stage.Name+"@"+r.verdict.PolicyVersion)
+		}
+		if r.verdict.EffectiveAction() == domain.ActionBlock {
+			blocks++
+			blockedWeight += r.stage.Weight
+			reasons = append(reasons, fmt.Sprintf("%s: %s", r.stage.Name, r.verdict.Reason))
+		}
+	}
+
+	switch g.config.Aggregation {
+	case AggregateAnyBlock:
+		response.Allowed = blocks == 0
+	case AggregateMajority:
+		response.Allowed = blocks*2 <= len(g.stages)
+	case AggregateWeighted:
+		response.Allowed = blockedWeight/totalWeight < g.config.Threshold
+	}
+	if !response.Allowed {
+		response.Action = domain.ActionBlock
+		response.Reason = strings.Join(reasons, "; ")
+	} else {
+		response.Action = g.strongestAllowedAction(results, response)
+		if len(reasons) > 0 {
+			// Outvoted blocks are still worth knowing about
+			response.Reason = strings.Join(reasons, "; ")
+		}
+	}
+	// Changing any stage's policy changes the pipeline's policy
+	response.PolicyVersion = strings.Join(versions, ",")
+	return response
+}
+
+// strongestAllowedAction picks the action of a pipeline that let the request
+// through. A block that was outvoted downgrades to a warning.
+func (g *CompositeGuardrail) strongestAllowedAction(results []stageResult, response *domain.GuardrailResponse) domain.GuardrailAction {
+	action := domain.ActionAllow
+	for _, r := range results {
+		stageAction := r.verdict.EffectiveAction()
+		if stageAction == domain.ActionBlock {
+			stageAction = domain.ActionWarn
+		}
+		if stageAction == domain.ActionReroute && response.RerouteModel == "" {
+			response.RerouteModel = r.verdict.RerouteModel
+		}
+		if stageAction.StrongerThan(action) {
+			action = stageAction
+		}
+	}
+	return action
+}
+
+// PipelineConfig is the JSON description of a guardrail pipeline.
+type PipelineConfig struct {
+	Mode        PipelineMode      `json:"mode"`
+	Aggregation AggregationPolicy `json:"aggregation"`
+	Threshold   float64           `json:"threshold,omitempty"`
+	Stages      []struct {
+		Name   string  `json:"name"`
+		URL    string  `json:"url"` // Any scheme NewGuardrail accepts, or llm://<model>
+		Weight float64 `json:"weight,omitempty"`
+	} `json:"stages"`
+}
+
+func LoadPipelineConfig(path string) (PipelineConfig, error) {
+	var config PipelineConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read guardrail pipeline config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse guardrail pipeline config: %w", err)
+	}
+	return config, nil
+}
+
+// NewGuardrailPipeline builds every stage of a pipeline. base supplies the
+// timeout and concurrency limit shared by the remote stages, and the
+// timeout of the llm:// stages, which llm backs.
+func NewGuardrailPipeline(config PipelineConfig, base GuardrailConfig, llm ports.LLMPort) (*CompositeGuardrail, error) {
+	stages := make([]GuardrailStage, 0, len(config.Stages))
+	for _, sc := range config.Stages {
+		var (
+			guardrail ports.GuardrailPort
+			err       error
+		)
+		if model, ok := strings.CutPrefix(sc.URL, "llm://"); ok {
+			guardrail = NewLLMGuardrail(llm, LLMGuardrailConfig{Model: model, Timeout: cmp.Or(base.JudgeTimeout, base.Timeout)})
+		} else {
+			stageConfig := base
+			stageConfig.BaseURL = sc.URL
+			guardrail, err = NewGuardrail(stageConfig)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("guardrail stage %q: %w", sc.Name, err)
+		}
+		stages = append(stages, GuardrailStage{Name: sc.Name, Guardrail: guardrail, Weight: sc.Weight})
+	}
+
+	return NewCompositeGuardrail(stages, CompositeConfig{
+		Mode:        config.Mode,
+		Aggregation: config.Aggregation,
+		Threshold:   config.Threshold,
+	})
+}
@@ -0,0 +1,196 @@
+package adapters_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// stubGuardrail blocks or rewrites payloads without any I/O
+type stubGuardrail struct {
+	block   bool
+	blockOn string    // Blocks payloads holding it
+	replace [2]string // old, new
+	err     error
+	seen    []byte
+}
+
+func (g *stubGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	g.seen = payload
+	if g.err != nil {
+		return nil, g.err
+	}
+	if g.block || (g.blockOn != "" && bytes.Contains(payload, []byte(g.blockOn))) {
+		return &domain.GuardrailResponse{Allowed: false, Reason: "stub block"}, nil
+	}
+	result := &domain.GuardrailResponse{Allowed: true}
+	if g.replace[0] != "" && bytes.Contains(payload, []byte(g.replace[0])) {
+		result.SanitizedInput = bytes.ReplaceAll(payload, []byte(g.replace[0]), []byte(g.replace[1]))
+	}
+	return result, nil
+}
+
+func TestCompositeGuardrail_SerialRedactionsFeedForward(t *testing.T) {
+	first := &stubGuardrail{replace: [2]string{"password", "[REDACTED]"}}
+	second := &stubGuardrail{replace: [2]string{"alice", "[NAME]"}}
+	pipeline, err := adapters.NewCompositeGuardrail([]adapters.GuardrailStage{
+		{Name: "native", Guardrail: first},
+		{Name: "sidecar", Guardrail: second},
+	}, adapters.CompositeConfig{Mode: adapters.PipelineSerial})
+	if err != nil {
+		t.Fatalf("Failed to build pipeline: %v", err)
+	}
+
+	result, err := pipeline.Validate(context.Background(), []byte("alice password"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if string(second.seen) != "alice [REDACTED]" {
+		t.Errorf("Second stage should see the first stage's redaction, got %q", second.seen)
+	}
+	if string(result.SanitizedInput) != "[NAME] [REDACTED]" {
+		t.Errorf("Expected both redactions, got %q", result.SanitizedInput)
+	}
+	if len(result.Stages) != 2 || !result.Stages[0].Redacted || result.Stages[0].Name != "native" {
+		t.Errorf("Expected a per-stage breakdown, got %+v", result.Stages)
+	}
+}
+
+func TestCompositeGuardrail_ParallelKeepsEveryRedaction(t *testing.T) {
+	pipeline, _ := adapters.NewCompositeGuardrail([]adapters.GuardrailStage{
+		{Name: "a", Guardrail: &stubGuardrail{replace: [2]string{"password", "[REDACTED]"}}},
+		{Name: "b", Guardrail: &stubGuardrail{replace: [2]string{"alice", "[NAME]"}}},
+	}, adapters.CompositeConfig{Mode: adapters.PipelineParallel})
+
+	result, err := pipeline.Validate(context.Background(), []byte("alice password"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(result.SanitizedInput) != "[NAME] [REDACTED]" {
+		t.Errorf("Expected both redactions, got %q", result.SanitizedInput)
+	}
+}
+
+func TestCompositeGuardrail_ParallelHonoursBlocksOnRedactions(t *testing.T) {
+	// The second stage only objects to what the first one redacted
+	pipeline, _ := adapters.NewCompositeGuardrail([]adapters.GuardrailStage{
+		{Name: "a", Guardrail: &stubGuardrail{replace: [2]string{"password", "[REDACTED]"}}},
+		{Name: "b", Guardrail: &stubGuardrail{replace: [2]string{"alice", "[NAME]"}, blockOn: "[REDACTED]"}},
+	}, adapters.CompositeConfig{Mode: adapters.PipelineParallel})
+
+	result, err := pipeline.Validate(context.Background(), []byte("alice password"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.EffectiveAction() != domain.ActionBlock || result.SanitizedInput != nil {
+		t.Errorf("Expected the re-run stage's block to win, got %s with %q", result.EffectiveAction(), result.SanitizedInput)
+	}
+}
+
+func TestCompositeGuardrail_Aggregation(t *testing.T) {
+	allow := func() *stubGuardrail { return &stubGuardrail{} }
+	block := func() *stubGuardrail { return &stubGuardrail{block: true} }
+
+	tests := []struct {
+		name        string
+		config      adapters.CompositeConfig
+		stages      []adapters.GuardrailStage
+		wantAllowed bool
+	}{
+		{
+			"Any block",
+			adapters.CompositeConfig{Aggregation: adapters.AggregateAnyBlock},
+			[]adapters.GuardrailStage{{Name: "a", Guardrail: allow()}, {Name: "b", Guardrail: block()}, {Name: "c", Guardrail: allow()}},
+			false,
+		},
+		{
+			"Majority allows a lone block",
+			adapters.CompositeConfig{Aggregation: adapters.AggregateMajority},
+			[]adapters.GuardrailStage{{Name: "a", Guardrail: allow()}, {Name: "b", Guardrail: block()}, {Name: "c", Guardrail: allow()}},
+			true,
+		},
+		{
+			"Majority blocks",
+			adapters.CompositeConfig{Aggregation: adapters.AggregateMajority, Mode: adapters.PipelineParallel},
+			[]adapters.GuardrailStage{{Name: "a", Guardrail: block()}, {Name: "b", Guardrail: block()}, {Name: "c", Guardrail: allow()}},
+			false,
+		},
+		{
+			"Weighted below threshold",
+			adapters.CompositeConfig{Aggregation: adapters.AggregateWeighted, Threshold: 0.5},
+			[]adapters.GuardrailStage{{Name: "a", Guardrail: block(), Weight: 1}, {Name: "b", Guardrail: allow(), Weight: 3}},
+			true,
+		},
+		{
+			"Weighted above threshold",
+			adapters.CompositeConfig{Aggregation: adapters.AggregateWeighted, Threshold: 0.5},
+			[]adapters.GuardrailStage{{Name: "a", Guardrail: block(), Weight: 3}, {Name: "b", Guardrail: allow(), Weight: 1}},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := adapters.NewCompositeGuardrail(tt.stages, tt.config)
+			if err != nil {
+				t.Fatalf("Failed to build pipeline: %v", err)
+			}
+			result, err := pipeline.Validate(context.Background(), []byte("hello"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Expected allowed=%v, got %v (%s)", tt.wantAllowed, result.Allowed, result.Reason)
+			}
+		})
+	}
+}
+
+func TestCompositeGuardrail_FailClosed(t *testing.T) {
+	pipeline, _ := adapters.NewCompositeGuardrail([]adapters.GuardrailStage{
+		{Name: "native", Guardrail: &stubGuardrail{}},
+		{Name: "sidecar", Guardrail: &stubGuardrail{err: errors.New("connection refused")}},
+	}, adapters.CompositeConfig{Aggregation: adapters.AggregateMajority})
+
+	if _, err := pipeline.Validate(context.Background(), []byte("hello")); err == nil {
+		t.Error("Expected a stage error to fail the pipeline")
+	}
+}
+
+// closingGuardrail records whether it was released
+type closingGuardrail struct {
+	stubGuardrail
+	closed bool
+	err    error
+}
+
+func (g *closingGuardrail) Close() error {
+	g.closed = true
+	return g.err
+}
+
+func TestCompositeGuardrail_Close(t *testing.T) {
+	// Through the verdict cache too, as the server wraps the pipeline
+	plugin := &closingGuardrail{}
+	grpc := &closingGuardrail{err: errors.New("connection already closed")}
+	pipeline, _ := adapters.NewCompositeGuardrail([]adapters.GuardrailStage{
+		{Name: "plugin", Guardrail: plugin},
+		{Name: "native", Guardrail: &stubGuardrail{}},
+		{Name: "grpc", Guardrail: grpc},
+	}, adapters.CompositeConfig{})
+	cache := adapters.NewCachedGuardrail(pipeline, adapters.GuardrailCacheConfig{MaxEntries: 10})
+
+	err := cache.Close()
+	if !plugin.closed || !grpc.closed {
+		t.Errorf("Expected every stage released, got plugin %v, grpc %v", plugin.closed, grpc.closed)
+	}
+	if err == nil || !strings.Contains(err.Error(), `guardrail stage "grpc"`) {
+		t.Errorf("Expected the failing stage reported, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	guardrailv1 "github.com/simone-trubian/baldr/proxy/api/guardrail/v1"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// GRPCGuardrail talks to the sidecar over a single persistent HTTP/2
+// connection, avoiding the per-request JSON/HTTP overhead of RemoteGuardrail.
+type GRPCGuardrail struct {
+	conn      *grpc.ClientConn
+	client    guardrailv1.GuardrailServiceClient
+	timeout   time.Duration
+	semaphore chan struct{} // Sets rate limit
+}
+
+// NewGRPCGuardrail expects a BaseURL of the form grpc://host:port.
+// The connection is established lazily on the first call.
+func NewGRPCGuardrail(config GuardrailConfig) (*GRPCGuardrail, error) {
+	target, err := url.Parse(config.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid guardrail url: %w", err)
+	}
+
+	// The sidecar lives on the internal network, so no TLS
+	conn, err := grpc.NewClient(target.Host,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                30 * time.Second, // Ping idle connections to keep them warm
+			Timeout:             5 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create guardrail grpc client: %w", err)
+	}
+
+	return &GRPCGuardrail{
+		conn:      conn,
+		client:    guardrailv1.NewGuardrailServiceClient(conn),
+		timeout:   config.Timeout,
+		semaphore: make(chan struct{}, config.MaxConcurrency),
+	}, nil
+}
+
+func (a *GRPCGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	// 1. Acquire token
+	select {
+	case a.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Request cancelled while awaiting for Guardrail service to become available")
+	}
+	// 2. Release the token on exit
+	defer func() { <-a.semaphore }()
+
+	// 3. Every call carries its own deadline
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	// 4. Execute
+	md := domain.RequestMetadataFrom(ctx)
+	resp, err := a.client.Validate(ctx, &guardrailv1.ValidateRequest{
+		Payload: payload,
+		Version: domain.GuardrailContractVersion,
+		Metadata: &guardrailv1.RequestMetadata{
+			RequestId: md.RequestID,
+			KeyId:     md.KeyID,
+			Team:      md.Team,
+			Model:     md.Model,
+			Direction: string(md.Direction),
+			PolicyId:  md.PolicyID,
+		},
+	})
+	if err != nil {
+		// Deadline exceeded and unavailable sidecars both end up here
+		return nil, fmt.Errorf("guardrail grpc error: %w", err)
+	}
+
+	if err := checkContractVersion(resp.GetVersion()); err != nil {
+		return nil, err
+	}
+
+	result := &domain.GuardrailResponse{
+		Version:        resp.GetVersion(),
+		Allowed:        resp.GetAllowed(),
+		Reason:         resp.GetReason(),
+		Action:         domain.GuardrailAction(resp.GetAction()),
+		RerouteModel:   resp.GetRerouteModel(),
+		SanitizedInput: resp.GetSanitizedInput(),
+		Tokens:         resp.GetTokens(),
+		PolicyVersion:  resp.GetPolicyVersion(),
+	}
+	for _, v := range resp.GetViolations() {
+		violation := domain.Violation{
+			Category: v.GetCategory(),
+			Score:    v.GetScore(),
+			Severity: domain.Severity(v.GetSeverity()),
+			Detector: v.GetDetector(),
+		}
+		for _, span := range v.GetSpans() {
+			violation.Spans = append(violation.Spans, domain.Span{Path: span.GetPath(), Start: int(span.GetStart()), End: int(span.GetEnd())})
+		}
+		result.Violations = append(result.Violations, violation)
+	}
+	return result, nil
+}
+
+// Close tears down the persistent connection.
+func (a *GRPCGuardrail) Close() error {
+	return a.conn.Close()
+}
@@ -0,0 +1,166 @@
+package adapters_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	guardrailv1 "github.com/simone-trubian/baldr/proxy/api/guardrail/v1"
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// fakeGuardrailServer mirrors the rules of the Python sidecar
+type fakeGuardrailServer struct {
+	guardrailv1.UnimplementedGuardrailServiceServer
+	delay time.Duration
+}
+
+func (s *fakeGuardrailServer) Validate(ctx context.Context, req *guardrailv1.ValidateRequest) (*guardrailv1.ValidateResponse, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	prompt := string(req.GetPayload())
+	if strings.Contains(prompt, "ATTACK") {
+		return &guardrailv1.ValidateResponse{Allowed: false, Reason: "Malicious keyword detected"}, nil
+	}
+	if strings.Contains(prompt, "gpt-4o") {
+		return &guardrailv1.ValidateResponse{
+			Version:      "2",
+			Allowed:      true,
+			Action:       "reroute",
+			RerouteModel: "gemini-2.5-flash",
+			Violations: []*guardrailv1.Violation{{
+				Category: "COST",
+				Score:    0.9,
+				Severity: "low",
+				Spans:    []*guardrailv1.Span{{Path: "model", Start: 0, End: 6}},
+			}},
+		}, nil
+	}
+	if strings.Contains(prompt, "future") {
+		return &guardrailv1.ValidateResponse{Version: "99", Allowed: true}, nil
+	}
+	if strings.Contains(prompt, "password") {
+		sanitized := strings.ReplaceAll(prompt, "password", "[REDACTED]")
+		return &guardrailv1.ValidateResponse{Allowed: true, SanitizedInput: []byte(sanitized)}, nil
+	}
+	return &guardrailv1.ValidateResponse{Allowed: true, PolicyVersion: "1"}, nil
+}
+
+// startGRPCGuardrail runs an in-process server and returns its grpc:// URL
+func startGRPCGuardrail(t *testing.T, impl guardrailv1.GuardrailServiceServer) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	guardrailv1.RegisterGuardrailServiceServer(srv, impl)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return "grpc://" + lis.Addr().String()
+}
+
+func TestGRPCGuardrail_Verdicts(t *testing.T) {
+	baseURL := startGRPCGuardrail(t, &fakeGuardrailServer{})
+
+	// The scheme alone must select the gRPC transport
+	guardrail, err := adapters.NewGuardrail(adapters.GuardrailConfig{
+		BaseURL:        baseURL,
+		Timeout:        time.Second,
+		MaxConcurrency: 4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guardrail: %v", err)
+	}
+	if _, ok := guardrail.(*adapters.GRPCGuardrail); !ok {
+		t.Fatalf("Expected a GRPCGuardrail for %s, got %T", baseURL, guardrail)
+	}
+	defer guardrail.(*adapters.GRPCGuardrail).Close()
+
+	tests := []struct {
+		name          string
+		payload       string
+		wantAllowed   bool
+		wantSanitized string
+	}{
+		{"Clean", `{"prompt": "hello"}`, true, ""},
+		{"Blocked", `{"prompt": "ATTACK"}`, false, ""},
+		{"Redacted", `{"prompt": "my password"}`, true, `{"prompt": "my [REDACTED]"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := guardrail.Validate(context.Background(), []byte(tt.payload))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Expected allowed=%v, got %v", tt.wantAllowed, result.Allowed)
+			}
+			if string(result.SanitizedInput) != tt.wantSanitized {
+				t.Errorf("Expected sanitized input %q, got %q", tt.wantSanitized, result.SanitizedInput)
+			}
+		})
+	}
+}
+
+func TestGRPCGuardrail_RichVerdict(t *testing.T) {
+	guardrail, err := adapters.NewGRPCGuardrail(adapters.GuardrailConfig{
+		BaseURL:        startGRPCGuardrail(t, &fakeGuardrailServer{}),
+		Timeout:        time.Second,
+		MaxConcurrency: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guardrail: %v", err)
+	}
+	defer guardrail.Close()
+
+	result, err := guardrail.Validate(context.Background(), []byte(`{"model": "gpt-4o"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.EffectiveAction() != domain.ActionReroute || result.RerouteModel != "gemini-2.5-flash" {
+		t.Errorf("Expected a reroute to gemini-2.5-flash, got %q to %q", result.Action, result.RerouteModel)
+	}
+	if len(result.Violations) != 1 || result.Violations[0].Spans[0].End != 6 {
+		t.Errorf("Violations were not mapped: %+v", result.Violations)
+	}
+
+	// A sidecar speaking a newer contract must fail closed
+	if _, err := guardrail.Validate(context.Background(), []byte("future")); err == nil {
+		t.Error("Expected an error for an unknown contract version")
+	}
+}
+
+func TestGRPCGuardrail_FailClosed_OnDeadline(t *testing.T) {
+	baseURL := startGRPCGuardrail(t, &fakeGuardrailServer{delay: 2 * time.Second})
+
+	guardrail, err := adapters.NewGRPCGuardrail(adapters.GuardrailConfig{
+		BaseURL:        baseURL,
+		Timeout:        100 * time.Millisecond,
+		MaxConcurrency: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guardrail: %v", err)
+	}
+	defer guardrail.Close()
+
+	start := time.Now()
+	_, err = guardrail.Validate(context.Background(), []byte(`{"prompt": "hello"}`))
+
+	if err == nil {
+		t.Fatal("Expected an error due to the deadline")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Adapter did not enforce its deadline, took %s", time.Since(start))
+	}
+}
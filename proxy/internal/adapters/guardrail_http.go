@@ -5,7 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
@@ -15,30 +21,82 @@ type GuardrailConfig struct {
 	BaseURL        string
 	Timeout        time.Duration
 	MaxConcurrency int
+	// JudgeTimeout bounds the llm:// stages of a pipeline, which an LLM
+	// rarely answers within Timeout. 0 means Timeout.
+	JudgeTimeout time.Duration
 }
 
 type RemoteGuardrail struct {
-	client    *http.Client
-	baseURL   string
-	semaphore chan struct{} // Sets rate limit
+	client          *http.Client
+	baseURL         string
+	capabilitiesURL string
+	semaphore       chan struct{} // Sets rate limit
+
+	// Negotiated sidecar capabilities, refreshed every capabilitiesTTL so a
+	// rolling upgrade is picked up without a proxy restart. capsMu is never
+	// held across the fetch, which capsFetch tracks while in flight.
+	capsMu        sync.Mutex
+	caps          guardrailCapabilities
+	capsKnown     bool
+	capsFetchedAt time.Time
+	capsFetch     chan struct{} // Closed once the fetch in flight is done
 }
 
+// capabilitiesTTL bounds how long a negotiated contract version is trusted.
+const capabilitiesTTL = time.Minute
+
+// capabilitiesTimeout bounds a capabilities fetch, whatever the client's
+// timeout.
+const capabilitiesTimeout = 2 * time.Second
+
+// unixValidatePath is the sidecar route used when dialing a Unix socket,
+// since the URL path is taken up by the socket file.
+const unixValidatePath = "/validate"
+
 func NewRemoteGuardrail(config GuardrailConfig) *RemoteGuardrail {
+	// Every call in flight may keep its connection, over TCP or a socket
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.MaxConcurrency
+	client := &http.Client{Timeout: config.Timeout, Transport: transport}
+	baseURL := config.BaseURL
+
+	// unix:///run/baldr/guardrail.sock talks HTTP over a Unix domain socket
+	// to a sidecar on the same host, skipping the TCP stack.
+	if socketPath, ok := strings.CutPrefix(config.BaseURL, "unix://"); ok {
+		dialer := &net.Dialer{}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		// The host is ignored by the dialer but required by net/http
+		baseURL = "http://guardrail" + unixValidatePath
+	}
+
 	return &RemoteGuardrail{
-		client:    &http.Client{Timeout: config.Timeout},
-		baseURL:   config.BaseURL,
-		semaphore: make(chan struct{}, config.MaxConcurrency),
+		client:          client,
+		baseURL:         baseURL,
+		capabilitiesURL: siblingURL(baseURL, "capabilities"),
+		semaphore:       make(chan struct{}, config.MaxConcurrency),
 	}
 }
 
+// guardrailRequest is the version 3 envelope around the client body.
 type guardrailRequest struct {
-	Prompt string `json:"prompt"`
+	Version  string                  `json:"version"`
+	Payload  json.RawMessage         `json:"payload"`
+	Metadata *domain.RequestMetadata `json:"metadata"`
+}
+
+// guardrailCapabilities is what the sidecar advertises on GET /capabilities.
+// Sidecars without the endpoint only speak version 1.
+type guardrailCapabilities struct {
+	Versions []string `json:"versions"`
+	// PolicyVersion the sidecar judges with, when it says
+	PolicyVersion string `json:"policy_version,omitempty"`
 }
 
-type guardrailResponse struct {
-	Allowed        bool            `json:"allowed"`
-	Reason         string          `json:"reason"`
-	SanitizedInput json.RawMessage `json:"sanitized_input,omitempty"`
+func (c guardrailCapabilities) supports(version string) bool {
+	return slices.Contains(c.Versions, version)
 }
 
 func (a *RemoteGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
@@ -53,17 +111,35 @@ func (a *RemoteGuardrail) Validate(ctx context.Context, payload []byte) (*domain
 	defer func() { <-a.semaphore }()
 
 	// 3. Prepare Request to Python Sidecar
-	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL, bytes.NewReader(payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create guardrail request: %w", err)
+	// Wrap the body in the envelope only if the sidecar said it can read it
+	body := payload
+	envelope := json.Valid(payload) && a.capabilities(ctx).supports(domain.GuardrailContractVersion)
+	if envelope {
+		var err error
+		body, err = json.Marshal(guardrailRequest{
+			Version:  domain.GuardrailContractVersion,
+			Payload:  payload,
+			Metadata: domain.RequestMetadataFrom(ctx),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode guardrail request: %w", err)
+		}
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	// 4. Execute
-	resp, err := a.client.Do(req)
+	resp, err := a.post(ctx, body)
 	if err != nil {
-		// This handles timeouts (context deadline) and connection refused
-		return nil, fmt.Errorf("guardrail connection error: %w", err)
+		return nil, err
+	}
+	// During a rollout an old replica may answer behind the same address.
+	// It rejects the envelope as a schema error, and no longer lists version
+	// 3 once asked again: only then fall back to version 1. Otherwise the
+	// sidecar rejected this request, not the envelope.
+	if envelope && isSchemaRejection(resp.StatusCode) && !a.recheckCapabilities(ctx).supports(domain.GuardrailContractVersion) {
+		resp.Body.Close()
+		if resp, err = a.post(ctx, payload); err != nil {
+			return nil, err
+		}
 	}
 	defer resp.Body.Close()
 
@@ -78,6 +154,119 @@ func (a *RemoteGuardrail) Validate(ctx context.Context, payload []byte) (*domain
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode guardrail response: %w", err)
 	}
+	if err := checkContractVersion(result.Version); err != nil {
+		return nil, err
+	}
 
 	return &result, nil
 }
+
+// PolicyVersion is the policy version the sidecar advertises, empty when it
+// doesn't. It is as fresh as the capabilities.
+func (a *RemoteGuardrail) PolicyVersion(ctx context.Context) string {
+	return a.capabilities(ctx).PolicyVersion
+}
+
+func (a *RemoteGuardrail) post(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create guardrail request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		// This handles timeouts (context deadline) and connection refused
+		return nil, fmt.Errorf("guardrail connection error: %w", err)
+	}
+	return resp, nil
+}
+
+// capabilities returns the cached sidecar capabilities, asking the sidecar
+// again once they are stale. One fetch runs at a time: callers keep using
+// the stale capabilities meanwhile, and only wait, within their deadline,
+// for the first. Any failure is read as a version 1 sidecar.
+func (a *RemoteGuardrail) capabilities(ctx context.Context) guardrailCapabilities {
+	return a.loadCapabilities(ctx, false)
+}
+
+// recheckCapabilities asks the sidecar for its capabilities again, however
+// fresh they are, and waits for the answer within ctx.
+func (a *RemoteGuardrail) recheckCapabilities(ctx context.Context) guardrailCapabilities {
+	return a.loadCapabilities(ctx, true)
+}
+
+func (a *RemoteGuardrail) loadCapabilities(ctx context.Context, recheck bool) guardrailCapabilities {
+	a.capsMu.Lock()
+	caps, known := a.caps, a.capsKnown
+	if !recheck && known && time.Since(a.capsFetchedAt) < capabilitiesTTL {
+		a.capsMu.Unlock()
+		return caps
+	}
+	fetch := a.capsFetch
+	if fetch == nil {
+		fetch = make(chan struct{})
+		a.capsFetch = fetch
+		go a.fetchCapabilities(fetch)
+	}
+	a.capsMu.Unlock()
+
+	if known && !recheck {
+		return caps
+	}
+	select {
+	case <-fetch:
+		a.capsMu.Lock()
+		defer a.capsMu.Unlock()
+		return a.caps
+	case <-ctx.Done():
+		return guardrailCapabilities{Versions: []string{"1"}}
+	}
+}
+
+// fetchCapabilities asks the sidecar for its capabilities, independently of
+// the request that noticed they were stale, and closes done.
+func (a *RemoteGuardrail) fetchCapabilities(done chan struct{}) {
+	caps := guardrailCapabilities{Versions: []string{"1"}}
+	defer func() {
+		a.capsMu.Lock()
+		a.caps, a.capsKnown, a.capsFetchedAt = caps, true, time.Now()
+		a.capsFetch = nil
+		a.capsMu.Unlock()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), capabilitiesTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.capabilitiesURL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var advertised guardrailCapabilities
+	if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&advertised) == nil {
+		caps = advertised
+	}
+}
+
+func isSchemaRejection(status int) bool {
+	return status == http.StatusBadRequest ||
+		status == http.StatusUnsupportedMediaType ||
+		status == http.StatusUnprocessableEntity
+}
+
+// siblingURL replaces the last path segment of rawURL, turning
+// http://guardrail:8000/validate into http://guardrail:8000/capabilities.
+func siblingURL(rawURL, name string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Path = path.Join(path.Dir(u.Path), name)
+	return u.String()
+}
@@ -0,0 +1,256 @@
+package adapters_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+const benchPayload = `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Explain who is Baldr in a sentence."}]}`
+
+func allowAllSidecar() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /validate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"allowed": true, "reason": "", "sanitized_input": null}`))
+	})
+	return mux
+}
+
+// startTCPSidecar returns the http:// URL of a sidecar on the loopback interface
+func startTCPSidecar(tb testing.TB) string {
+	srv := httptest.NewServer(allowAllSidecar())
+	tb.Cleanup(srv.Close)
+	return srv.URL + "/validate"
+}
+
+// startUnixSidecar returns the unix:// URL of a sidecar on a Unix domain socket
+func startUnixSidecar(tb testing.TB) string {
+	socketPath := filepath.Join(tb.TempDir(), "guardrail.sock")
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		tb.Fatalf("Failed to listen on %s: %v", socketPath, err)
+	}
+	srv := httptest.NewUnstartedServer(allowAllSidecar())
+	srv.Listener = lis
+	srv.Start()
+	tb.Cleanup(srv.Close)
+	return "unix://" + socketPath
+}
+
+func TestRemoteGuardrail_UnixSocket(t *testing.T) {
+	guardrail, err := adapters.NewGuardrail(adapters.GuardrailConfig{
+		BaseURL:        startUnixSidecar(t),
+		Timeout:        time.Second,
+		MaxConcurrency: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guardrail: %v", err)
+	}
+
+	result, err := guardrail.Validate(context.Background(), []byte(benchPayload))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Allowed {
+		t.Error("Expected the request to be allowed")
+	}
+}
+
+// versionedSidecar records the last /validate body. Its capabilities
+// endpoint advertises versions; without any it behaves like a version 1
+// sidecar that has no such endpoint. rejectEnvelope makes it answer the
+// envelope with a schema error; with replicaVersions, it is an old replica
+// answering behind the same address, which advertises those from then on.
+type versionedSidecar struct {
+	versions        []string
+	rejectEnvelope  bool
+	replicaVersions []string
+	lastBody        map[string]any
+}
+
+func (s *versionedSidecar) start(t *testing.T) string {
+	mux := http.NewServeMux()
+	if len(s.versions) > 0 {
+		mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{"versions": s.versions})
+		})
+	}
+	mux.HandleFunc("POST /validate", func(w http.ResponseWriter, r *http.Request) {
+		s.lastBody = nil
+		json.NewDecoder(r.Body).Decode(&s.lastBody)
+		if _, ok := s.lastBody["payload"]; ok && s.rejectEnvelope {
+			if s.replicaVersions != nil {
+				s.versions = s.replicaVersions
+			}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte(`{"allowed": true}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/validate"
+}
+
+func TestRemoteGuardrail_Envelope(t *testing.T) {
+	md := &domain.RequestMetadata{
+		RequestID: "req-1",
+		KeyID:     "key-abc",
+		Team:      "search",
+		Model:     "gemini-2.5-flash",
+		Direction: domain.DirectionInput,
+		PolicyID:  "strict",
+	}
+	ctx := domain.WithRequestMetadata(context.Background(), md)
+
+	tests := []struct {
+		name         string
+		sidecar      *versionedSidecar
+		wantEnvelope bool
+		wantErr      string
+	}{
+		{"Version 1 sidecar gets the raw body", &versionedSidecar{}, false, ""},
+		{"Version 3 sidecar gets the envelope", &versionedSidecar{versions: []string{"1", "2", "3"}}, true, ""},
+		{"Old replica during a rollout", &versionedSidecar{versions: []string{"3"}, rejectEnvelope: true, replicaVersions: []string{"1"}}, false, ""},
+		// A sidecar that still reads the envelope rejected this one request
+		{"Envelope rejected by a version 3 sidecar", &versionedSidecar{versions: []string{"3"}, rejectEnvelope: true}, true, "status: 422"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guardrail := adapters.NewRemoteGuardrail(adapters.GuardrailConfig{
+				BaseURL:        tt.sidecar.start(t),
+				Timeout:        time.Second,
+				MaxConcurrency: 1,
+			})
+
+			result, err := guardrail.Validate(ctx, []byte(benchPayload))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected an error containing %q, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if !result.Allowed {
+				t.Error("Expected the request to be allowed")
+			}
+
+			body := tt.sidecar.lastBody
+			if !tt.wantEnvelope {
+				if body["model"] != "gemini-2.5-flash" {
+					t.Errorf("Expected the raw client body, got %v", body)
+				}
+				return
+			}
+			if body["version"] != domain.GuardrailContractVersion {
+				t.Errorf("Expected envelope version %s, got %v", domain.GuardrailContractVersion, body["version"])
+			}
+			metadata, _ := body["metadata"].(map[string]any)
+			if metadata["key_id"] != "key-abc" || metadata["policy_id"] != "strict" || metadata["direction"] != "input" {
+				t.Errorf("Metadata did not reach the sidecar: %v", metadata)
+			}
+			payload, _ := body["payload"].(map[string]any)
+			if payload["model"] != "gemini-2.5-flash" {
+				t.Errorf("Expected the client body as payload, got %v", payload)
+			}
+		})
+	}
+}
+
+func TestRemoteGuardrail_SlowCapabilities(t *testing.T) {
+	// Scenario: The sidecar takes longer to list its capabilities than
+	// callers are willing to wait.
+	// Expected: Callers give up on their own deadline, a single fetch runs
+	// meanwhile, and its answer is kept for the next calls.
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	sidecar := &versionedSidecar{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write([]byte(`{"versions": ["3"]}`))
+	})
+	mux.HandleFunc("POST /validate", func(w http.ResponseWriter, r *http.Request) {
+		sidecar.lastBody = nil
+		json.NewDecoder(r.Body).Decode(&sidecar.lastBody)
+		w.Write([]byte(`{"allowed": true}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	guardrail := adapters.NewRemoteGuardrail(adapters.GuardrailConfig{
+		BaseURL:        srv.URL + "/validate",
+		Timeout:        time.Second,
+		MaxConcurrency: 4,
+	})
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			guardrail.Validate(ctx, []byte(benchPayload))
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected callers to give up on their deadline, waited %v", elapsed)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected a single capabilities fetch, got %d", n)
+	}
+
+	release <- struct{}{}
+	if _, err := guardrail.Validate(context.Background(), []byte(benchPayload)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sidecar.lastBody["version"] != domain.GuardrailContractVersion {
+		t.Errorf("Expected the fetched capabilities to be used, got %v", sidecar.lastBody)
+	}
+}
+
+func benchmarkRemoteGuardrail(b *testing.B, baseURL string) {
+	guardrail := adapters.NewRemoteGuardrail(adapters.GuardrailConfig{
+		BaseURL:        baseURL,
+		Timeout:        time.Second,
+		MaxConcurrency: 64,
+	})
+	ctx := context.Background()
+	payload := []byte(benchPayload)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := guardrail.Validate(ctx, payload); err != nil {
+				// Fatal can't be called from RunParallel's goroutines
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkRemoteGuardrail_TCP(b *testing.B) {
+	benchmarkRemoteGuardrail(b, startTCPSidecar(b))
+}
+
+func BenchmarkRemoteGuardrail_UnixSocket(b *testing.B) {
+	benchmarkRemoteGuardrail(b, startUnixSidecar(b))
+}
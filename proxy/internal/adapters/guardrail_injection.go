@@ -0,0 +1,235 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// Injection categories reported in violations.
+const (
+	CategoryInstructionOverride = "INSTRUCTION_OVERRIDE"
+	CategoryRolePlay            = "ROLE_PLAY"
+	CategoryDelimiterSmuggling  = "DELIMITER_SMUGGLING"
+	CategoryPromptExtraction    = "PROMPT_EXTRACTION"
+)
+
+// InjectionRule is a pattern that hints at an injection attempt. Weight is
+// the score a single match earns its category, between 0 and 1.
+type InjectionRule struct {
+	Category string  `json:"category"`
+	Pattern  string  `json:"pattern"` // Case-insensitive regular expression
+	Weight   float64 `json:"weight"`
+}
+
+type InjectionThresholds struct {
+	Block float64 `json:"block"` // Scores at or above block the request
+	Warn  float64 `json:"warn"`  // Scores at or above only flag it
+}
+
+type InjectionGuardrailConfig struct {
+	PolicyVersion string `json:"policy_version,omitempty"`
+	// Defaults to 0.7 to block and 0.4 to warn
+	Thresholds InjectionThresholds `json:"thresholds"`
+	// Keys overrides the thresholds for some callers, by key ID. Thresholds
+	// left out are the global ones.
+	Keys map[string]InjectionThresholds `json:"keys,omitempty"`
+	// Rules are added to the built-in ones
+	Rules []InjectionRule `json:"rules,omitempty"`
+}
+
+// defaultInjectionRules cover the common families of attacks. None of them
+// is conclusive alone, which is why they are scored rather than matched.
+var defaultInjectionRules = []InjectionRule{
+	{CategoryInstructionOverride, `\b(ignore|disregard|forget|override|bypass)\b.{0,30}\b(previous|prior|above|earlier|preceding|system|your)\b.{0,20}\b(instructions?|prompts?|rules|directions|guidelines)\b`, 0.7},
+	{CategoryInstructionOverride, `\bnew (instructions?|rules)\s*:`, 0.4},
+	{CategoryInstructionOverride, `\bfrom now on,? you (will|must|are|shall)\b`, 0.3},
+	{CategoryRolePlay, `\b(do anything now|DAN mode)\b`, 0.7},
+	{CategoryRolePlay, `\b(developer|jailbreak|god|unrestricted) mode\b`, 0.5},
+	{CategoryRolePlay, `\b(pretend|act|role-?play|imagine)\b.{0,30}\b(you are|to be|as)\b.{0,40}\b(no|without|free of|unrestricted|unfiltered|uncensored)\b`, 0.5},
+	{CategoryRolePlay, `\bno (ethical|moral|content) (guidelines|restrictions|constraints|filters?|polic(y|ies))\b`, 0.4},
+	{CategoryDelimiterSmuggling, `<\|(im_start|im_end|system|endoftext|eot_id|start_header_id)\|>`, 0.7},
+	{CategoryDelimiterSmuggling, `\[/?(INST|SYS)\]|<</?SYS>>`, 0.6},
+	{CategoryDelimiterSmuggling, `(?m)^\s*(#{1,3}\s*)?(system|assistant)\s*:`, 0.4},
+	{CategoryDelimiterSmuggling, `</?(system|instructions?|sys)>`, 0.4},
+	{CategoryPromptExtraction, `\b(reveal|show|print|repeat|output|tell me|display|leak|dump)\b.{0,30}\b(your|the)\b.{0,20}\b(system prompt|initial instructions|hidden instructions|original instructions|prompt above)\b`, 0.6},
+	{CategoryPromptExtraction, `\bwhat (is|are|were) your (system prompt|instructions|initial instructions|rules)\b`, 0.5},
+	{CategoryPromptExtraction, `\brepeat (everything|the text|the words) above\b`, 0.5},
+}
+
+// roleWeight discounts matches in system messages: the integrator writes
+// those, so delimiters and "you are" phrasing are weaker evidence there.
+var roleWeight = map[string]float64{
+	"system": 0.5,
+}
+
+type compiledInjectionRule struct {
+	category string
+	pattern  *regexp.Regexp
+	weight   float64
+}
+
+// InjectionGuardrail scores every message of a request, whatever its role,
+// for prompt injection and jailbreak patterns.
+type InjectionGuardrail struct {
+	rules         []compiledInjectionRule
+	thresholds    InjectionThresholds
+	keys          map[string]InjectionThresholds
+	policyVersion string
+}
+
+func DefaultInjectionGuardrailConfig() InjectionGuardrailConfig {
+	return InjectionGuardrailConfig{
+		PolicyVersion: "injection-default",
+		Thresholds:    InjectionThresholds{Block: 0.7, Warn: 0.4},
+	}
+}
+
+// LoadInjectionGuardrailConfig reads a JSON configuration. Thresholds left
+// out keep their defaults.
+func LoadInjectionGuardrailConfig(path string) (InjectionGuardrailConfig, error) {
+	config := DefaultInjectionGuardrailConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read injection guardrail config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse injection guardrail config: %w", err)
+	}
+	return config, nil
+}
+
+func NewInjectionGuardrail(config InjectionGuardrailConfig) (*InjectionGuardrail, error) {
+	g := &InjectionGuardrail{
+		thresholds:    config.Thresholds,
+		keys:          make(map[string]InjectionThresholds, len(config.Keys)),
+		policyVersion: config.PolicyVersion,
+	}
+	if g.thresholds.Block == 0 {
+		g.thresholds.Block = 0.7
+	}
+	if g.thresholds.Warn == 0 {
+		g.thresholds.Warn = 0.4
+	}
+	if err := checkInjectionThresholds("default", g.thresholds); err != nil {
+		return nil, err
+	}
+	for key, t := range config.Keys {
+		// A key that only sets block mustn't warn about every prompt
+		if t.Block == 0 {
+			t.Block = g.thresholds.Block
+		}
+		if t.Warn == 0 {
+			t.Warn = g.thresholds.Warn
+		}
+		if err := checkInjectionThresholds("key "+key, t); err != nil {
+			return nil, err
+		}
+		g.keys[key] = t
+	}
+
+	for _, rule := range append(defaultInjectionRules, config.Rules...) {
+		if rule.Weight <= 0 || rule.Weight > 1 {
+			return nil, fmt.Errorf("injection rule %q: weight must be in (0, 1]", rule.Pattern)
+		}
+		pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("injection rule %q: %w", rule.Pattern, err)
+		}
+		g.rules = append(g.rules, compiledInjectionRule{category: rule.Category, pattern: pattern, weight: rule.Weight})
+	}
+	return g, nil
+}
+
+func checkInjectionThresholds(name string, t InjectionThresholds) error {
+	if t.Block <= 0 || t.Block > 1 || t.Warn < 0 || t.Warn > t.Block {
+		return fmt.Errorf("injection thresholds (%s): need 0 <= warn <= block <= 1, got warn %.2f block %.2f", name, t.Warn, t.Block)
+	}
+	return nil
+}
+
+func (g *InjectionGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	doc := parseRequestDocument(payload)
+
+	// A category scores as its strongest match, repeating a phrase doesn't
+	// make it more suspicious
+	scores := make(map[string]float64)
+	violations := make(map[string]*domain.Violation)
+	for _, field := range doc.Fields {
+		weight, ok := roleWeight[field.Role]
+		if !ok {
+			weight = 1
+		}
+		for _, rule := range g.rules {
+			for _, loc := range rule.pattern.FindAllStringIndex(field.Value, -1) {
+				v, ok := violations[rule.category]
+				if !ok {
+					v = &domain.Violation{Category: rule.category, Detector: "injection"}
+					violations[rule.category] = v
+				}
+				v.Spans = append(v.Spans, domain.Span{Path: field.Path, Start: loc[0], End: loc[1]})
+				scores[rule.category] = max(scores[rule.category], rule.weight*weight)
+			}
+		}
+	}
+
+	// Independent categories add up: 1 - (1-a)(1-b)...
+	categories := make([]string, 0, len(violations))
+	clean := 1.0
+	for category, v := range violations {
+		v.Score = scores[category]
+		v.Severity = injectionSeverity(v.Score)
+		clean *= 1 - v.Score
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	score := math.Round((1-clean)*100) / 100
+
+	result := &domain.GuardrailResponse{
+		Version:       domain.GuardrailContractVersion,
+		Allowed:       true,
+		Action:        domain.ActionAllow,
+		PolicyVersion: g.policyVersion,
+	}
+	for _, category := range categories {
+		result.Violations = append(result.Violations, *violations[category])
+	}
+
+	thresholds := g.thresholdsFor(domain.RequestMetadataFrom(ctx).KeyID)
+	switch {
+	case score >= thresholds.Block:
+		result.Allowed = false
+		result.Action = domain.ActionBlock
+	case score >= thresholds.Warn:
+		result.Action = domain.ActionWarn
+	default:
+		return result, nil
+	}
+	result.Reason = fmt.Sprintf("prompt injection score %.2f (%s)", score, strings.Join(categories, ", "))
+	return result, nil
+}
+
+func (g *InjectionGuardrail) thresholdsFor(keyID string) InjectionThresholds {
+	if t, ok := g.keys[keyID]; ok && keyID != "" {
+		return t
+	}
+	return g.thresholds
+}
+
+func injectionSeverity(score float64) domain.Severity {
+	switch {
+	case score >= 0.7:
+		return domain.SeverityHigh
+	case score >= 0.4:
+		return domain.SeverityMedium
+	default:
+		return domain.SeverityLow
+	}
+}
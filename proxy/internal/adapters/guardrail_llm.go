@@ -0,0 +1,126 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// judgePrompt asks the model for a one-line verdict we can parse.
+const judgePrompt = `You are a content safety classifier for an AI gateway.
+Decide whether the conversation below is safe to forward to a language model.
+Reply with exactly one line: either "SAFE" or "UNSAFE: <short reason>".`
+
+type LLMGuardrailConfig struct {
+	Model   string
+	Timeout time.Duration
+}
+
+// LLMGuardrail uses a (cheap) model as a judge. It is the slowest stage and
+// is meant to sit at the end of a pipeline, after the native checks.
+type LLMGuardrail struct {
+	llm     ports.LLMPort
+	model   string
+	timeout time.Duration
+}
+
+func NewLLMGuardrail(llm ports.LLMPort, config LLMGuardrailConfig) *LLMGuardrail {
+	return &LLMGuardrail{llm: llm, model: config.Model, timeout: config.Timeout}
+}
+
+type judgeRequest struct {
+	Model       string         `json:"model"`
+	Messages    []judgeMessage `json:"messages"`
+	Temperature float64        `json:"temperature"`
+	Stream      bool           `json:"stream"`
+}
+
+type judgeMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type judgeResponse struct {
+	Choices []struct {
+		Message judgeMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (g *LLMGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+
+	// 1. Flatten the conversation into a transcript for the judge
+	var transcript strings.Builder
+	for _, field := range parseRequestDocument(payload).Fields {
+		if field.Role != "" {
+			fmt.Fprintf(&transcript, "[%s] ", field.Role)
+		}
+		transcript.WriteString(field.Value)
+		transcript.WriteString("\n")
+	}
+
+	body, err := json.Marshal(judgeRequest{
+		Model: g.model,
+		Messages: []judgeMessage{
+			{Role: "system", Content: judgePrompt},
+			{Role: "user", Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode judge request: %w", err)
+	}
+
+	// 2. Ask the judge (non-streaming), over chat completions whatever
+	// endpoint the checked request is for
+	judged := *domain.RequestMetadataFrom(ctx)
+	judged.Endpoint = domain.EndpointChat
+	stream, err := g.llm.Generate(domain.WithRequestMetadata(ctx, &judged), body, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, fmt.Errorf("llm guardrail error: %w", err)
+	}
+	defer stream.Close()
+
+	var resp judgeResponse
+	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode judge response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("judge returned no choices")
+	}
+
+	// 3. Anything that isn't a clear SAFE is treated as a block
+	answer := strings.TrimSpace(resp.Choices[0].Message.Content)
+	result := &domain.GuardrailResponse{
+		Version:       domain.GuardrailContractVersion,
+		Allowed:       true,
+		Action:        domain.ActionAllow,
+		PolicyVersion: g.model,
+	}
+	// The first word must be SAFE itself, not "SAFEGUARD", "SAFE?" or "Safe-ish"
+	if words := strings.Fields(answer); len(words) > 0 && strings.EqualFold(words[0], "SAFE") {
+		return result, nil
+	}
+	reason := strings.TrimSpace(strings.TrimPrefix(answer, "UNSAFE:"))
+	if reason == "" {
+		reason = "flagged by llm judge"
+	}
+	result.Allowed = false
+	result.Action = domain.ActionBlock
+	result.Reason = reason
+	result.Violations = []domain.Violation{{
+		Category: "LLM_JUDGE",
+		Score:    1,
+		Severity: domain.SeverityHigh,
+		Detector: "llm:" + g.model,
+	}}
+	return result, nil
+}
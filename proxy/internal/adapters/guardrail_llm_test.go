@@ -0,0 +1,119 @@
+package adapters_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// judgeLLM answers every judge request with the same reply.
+type judgeLLM struct {
+	answer string
+	delay  time.Duration
+	seen   struct {
+		Model    string
+		Messages []struct{ Role, Content string }
+	}
+	endpoint domain.Endpoint
+}
+
+func (l *judgeLLM) Generate(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+	json.Unmarshal(payload, &l.seen)
+	l.endpoint = domain.RequestMetadataFrom(ctx).Endpoint
+	select {
+	case <-time.After(l.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return io.NopCloser(strings.NewReader(l.answer)), nil
+}
+
+func judgeAnswer(content string) string {
+	body, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"message": map[string]string{"role": "assistant", "content": content}}}})
+	return string(body)
+}
+
+func TestLLMGuardrail(t *testing.T) {
+	tests := []struct {
+		name       string
+		answer     string
+		wantAction domain.GuardrailAction
+		wantReason string
+	}{
+		{"Safe", judgeAnswer("SAFE"), domain.ActionAllow, ""},
+		{"Safe in lower case", judgeAnswer(" safe\n"), domain.ActionAllow, ""},
+		{"Unsafe", judgeAnswer("UNSAFE: asks for malware"), domain.ActionBlock, "asks for malware"},
+		// Anything but a clear SAFE blocks
+		{"Unparseable answer", judgeAnswer("I'm not sure what you mean."), domain.ActionBlock, "I'm not sure what you mean."},
+		{"Unsafe without a reason", judgeAnswer("UNSAFE:"), domain.ActionBlock, "flagged by llm judge"},
+		{"Safe with an explanation", judgeAnswer("SAFE\nA question about mythology."), domain.ActionAllow, ""},
+		{"Word starting with SAFE", judgeAnswer("SAFEGUARD needed"), domain.ActionBlock, "SAFEGUARD needed"},
+		{"Hedged answer", judgeAnswer("SAFE? no — UNSAFE: asks for malware"), domain.ActionBlock, "SAFE? no — UNSAFE: asks for malware"},
+		{"Safe-ish", judgeAnswer("Safe-ish"), domain.ActionBlock, "Safe-ish"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &judgeLLM{answer: tt.answer}
+			guardrail := adapters.NewLLMGuardrail(llm, adapters.LLMGuardrailConfig{Model: "judge-mini", Timeout: time.Second})
+			ctx := domain.WithRequestMetadata(context.Background(), &domain.RequestMetadata{Endpoint: domain.EndpointCompletions})
+			verdict, err := guardrail.Validate(ctx, chatBody("Explain who is Baldr in a sentence."))
+			if err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if got := verdict.EffectiveAction(); got != tt.wantAction {
+				t.Errorf("Expected action %s, got %s", tt.wantAction, got)
+			}
+			if verdict.Reason != tt.wantReason {
+				t.Errorf("Expected reason %q, got %q", tt.wantReason, verdict.Reason)
+			}
+			if llm.seen.Model != "judge-mini" || llm.endpoint != domain.EndpointChat {
+				t.Errorf("Expected a chat request to the judge model, got %q on %s", llm.seen.Model, llm.endpoint)
+			}
+			if len(llm.seen.Messages) != 2 || !strings.Contains(llm.seen.Messages[1].Content, "[user] Explain who is Baldr") {
+				t.Errorf("Expected the judge to get the transcript, got %+v", llm.seen.Messages)
+			}
+		})
+	}
+}
+
+func TestLLMGuardrail_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		llm     *judgeLLM
+		wantErr string
+	}{
+		{"Times out", &judgeLLM{answer: judgeAnswer("SAFE"), delay: time.Second}, "deadline exceeded"},
+		{"Not JSON", &judgeLLM{answer: "SAFE"}, "failed to decode judge response"},
+		{"No choices", &judgeLLM{answer: `{"choices":[]}`}, "judge returned no choices"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guardrail := adapters.NewLLMGuardrail(tt.llm, adapters.LLMGuardrailConfig{Model: "judge-mini", Timeout: 50 * time.Millisecond})
+			_, err := guardrail.Validate(context.Background(), chatBody("Explain who is Baldr in a sentence."))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLLMGuardrail_PipelineTimeout(t *testing.T) {
+	// The judge gets its own timeout, not the one of the fast stages
+	var config adapters.PipelineConfig
+	json.Unmarshal([]byte(`{"stages":[{"name":"judge","url":"llm://judge-mini"}]}`), &config)
+	llm := &judgeLLM{answer: judgeAnswer("SAFE"), delay: 50 * time.Millisecond}
+	pipeline, err := adapters.NewGuardrailPipeline(config, adapters.GuardrailConfig{Timeout: 10 * time.Millisecond, JudgeTimeout: time.Second}, llm)
+	if err != nil {
+		t.Fatalf("Failed to build pipeline: %v", err)
+	}
+	verdict, err := pipeline.Validate(context.Background(), chatBody("Explain who is Baldr in a sentence."))
+	if err != nil || verdict.EffectiveAction() != domain.ActionAllow {
+		t.Errorf("Expected the judge to answer within its timeout, got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// redactionMarker matches what the Python sidecar substitutes.
+const redactionMarker = "[REDACTED]"
+
+type DetectorConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // keyword, regex, email, phone, iban, credit_card, secret
+	// Action is one of allow (report only), warn, redact or block
+	Action   domain.GuardrailAction `json:"action"`
+	Severity domain.Severity        `json:"severity,omitempty"` // Defaults from the action
+	// Keyword detectors
+	Keywords      []string `json:"keywords,omitempty"`
+	CaseSensitive bool     `json:"case_sensitive,omitempty"`
+	// Regex detectors
+	Patterns []string `json:"patterns,omitempty"`
+	// Secret detectors: bits per character above which an unrecognised
+	// key-like string counts as a secret, defaults to 4
+	MinEntropy float64 `json:"min_entropy,omitempty"`
+	// Tokenize makes a redact detector substitute reversible placeholders
+	// (e.g. <EMAIL_1>) instead of [REDACTED]. The originals are restored in
+	// the response.
+	Tokenize bool `json:"tokenize,omitempty"`
+}
+
+type NativeGuardrailConfig struct {
+	PolicyVersion string           `json:"policy_version,omitempty"`
+	Detectors     []DetectorConfig `json:"detectors"`
+}
+
+// DefaultNativeGuardrailConfig mirrors the rules of the Python sidecar and
+// tokenizes the common PII formats.
+func DefaultNativeGuardrailConfig() NativeGuardrailConfig {
+	return NativeGuardrailConfig{
+		PolicyVersion: "native-default",
+		Detectors: []DetectorConfig{
+			{Name: "malicious-keywords", Type: "keyword", Action: domain.ActionBlock, Keywords: []string{"ATTACK"}, CaseSensitive: true},
+			{Name: "secrets", Type: "keyword", Action: domain.ActionRedact, Keywords: []string{"password"}, CaseSensitive: true},
+			{Name: "dlp", Type: "secret", Action: domain.ActionRedact},
+			{Name: "email", Type: "email", Action: domain.ActionRedact, Tokenize: true},
+			{Name: "credit-card", Type: "credit_card", Action: domain.ActionRedact, Tokenize: true},
+			{Name: "iban", Type: "iban", Action: domain.ActionRedact, Tokenize: true},
+			{Name: "phone", Type: "phone", Action: domain.ActionRedact, Tokenize: true},
+		},
+	}
+}
+
+// LoadNativeGuardrailConfig reads a JSON detector configuration.
+func LoadNativeGuardrailConfig(path string) (NativeGuardrailConfig, error) {
+	var config NativeGuardrailConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read native guardrail config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse native guardrail config: %w", err)
+	}
+	return config, nil
+}
+
+type configuredDetector struct {
+	Detector
+	action   domain.GuardrailAction
+	severity domain.Severity
+	tokenize bool
+	// audit logs what the detector found, by category and location only
+	audit bool
+}
+
+var defaultSeverity = map[domain.GuardrailAction]domain.Severity{
+	domain.ActionAllow:  domain.SeverityLow,
+	domain.ActionWarn:   domain.SeverityLow,
+	domain.ActionRedact: domain.SeverityMedium,
+	domain.ActionBlock:  domain.SeverityHigh,
+}
+
+// NativeGuardrail runs cheap checks in-process, without a sidecar round-trip.
+type NativeGuardrail struct {
+	detectors     []configuredDetector
+	policyVersion string
+}
+
+func NewNativeGuardrail(config NativeGuardrailConfig) (*NativeGuardrail, error) {
+	g := &NativeGuardrail{policyVersion: config.PolicyVersion}
+	for _, dc := range config.Detectors {
+		d, err := buildDetector(dc)
+		if err != nil {
+			return nil, err
+		}
+		severity, ok := defaultSeverity[dc.Action]
+		if !ok {
+			// Rerouting needs a target model, which detectors don't have
+			return nil, fmt.Errorf("detector %q: unsupported action %q", dc.Name, dc.Action)
+		}
+		if dc.Severity != "" {
+			severity = dc.Severity
+		}
+		if dc.Tokenize && dc.Action != domain.ActionRedact {
+			return nil, fmt.Errorf("detector %q: only redact detectors can tokenize", dc.Name)
+		}
+		g.detectors = append(g.detectors, configuredDetector{
+			Detector: d,
+			action:   dc.Action,
+			severity: severity,
+			tokenize: dc.Tokenize,
+			audit:    dc.Type == "secret",
+		})
+	}
+	return g, nil
+}
+
+func buildDetector(dc DetectorConfig) (Detector, error) {
+	name := dc.Name
+	if name == "" {
+		name = dc.Type
+	}
+	switch dc.Type {
+	case "keyword":
+		return newKeywordDetector(name, dc.Keywords, dc.CaseSensitive)
+	case "regex":
+		return newRegexDetector(name, dc.Patterns)
+	case "email":
+		return newEmailDetector(name), nil
+	case "phone":
+		return newPhoneDetector(name), nil
+	case "iban":
+		return newIBANDetector(name), nil
+	case "credit_card":
+		return newCreditCardDetector(name), nil
+	case "secret":
+		return newSecretDetector(name, dc.MinEntropy), nil
+	default:
+		return nil, fmt.Errorf("detector %q: unknown type %q", name, dc.Type)
+	}
+}
+
+func (g *NativeGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	doc := parseRequestDocument(payload)
+	vault := domain.VaultFrom(ctx)
+	result := &domain.GuardrailResponse{
+		Version:       domain.GuardrailContractVersion,
+		Allowed:       true,
+		Action:        domain.ActionAllow,
+		PolicyVersion: g.policyVersion,
+	}
+
+	// Findings are grouped per detector and category, with one span per match
+	violations := make(map[string]*domain.Violation)
+	categories := make(map[string][]string) // Per detector, in order of discovery
+	tokenizing := make(map[string]bool)
+	var reasons []string
+	redacted := false
+	for _, field := range doc.Fields {
+		var spans []Match
+		for _, d := range g.detectors {
+			for _, m := range d.Detect(field.Value) {
+				key := d.Name() + "/" + m.Category
+				v, ok := violations[key]
+				if !ok {
+					v = &domain.Violation{Category: m.Category, Score: 1, Severity: d.severity, Detector: d.Name()}
+					violations[key] = v
+					categories[d.Name()] = append(categories[d.Name()], m.Category)
+					if d.action != domain.ActionAllow {
+						reasons = append(reasons, fmt.Sprintf("%s detected by %s", m.Category, m.Detector))
+					}
+				}
+				v.Spans = append(v.Spans, domain.Span{Path: field.Path, Start: m.Start, End: m.End})
+
+				if d.action.StrongerThan(result.Action) {
+					result.Action = d.action
+				}
+				if d.action == domain.ActionRedact {
+					spans = append(spans, m)
+					tokenizing[d.Name()] = d.tokenize
+				}
+			}
+		}
+		if len(spans) > 0 {
+			field.Set(redactSpans(field.Value, spans, func(m Match, original string) string {
+				if tokenizing[m.Detector] {
+					return vault.Tokenize(m.Category, original)
+				}
+				return redactionMarker
+			}))
+			redacted = true
+		}
+	}
+
+	// Report violations in configuration order
+	for _, d := range g.detectors {
+		for _, category := range categories[d.Name()] {
+			key := d.Name() + "/" + category
+			v, ok := violations[key]
+			if !ok {
+				continue
+			}
+			result.Violations = append(result.Violations, *v)
+			delete(violations, key)
+			if d.audit {
+				// Never the value: the log would become the leak
+				log.Printf("DLP: %d %s finding(s) at %s (request %s, action %s)",
+					len(v.Spans), category, spanPaths(v.Spans), domain.RequestMetadataFrom(ctx).RequestID, d.action)
+			}
+		}
+	}
+
+	switch result.Action {
+	case domain.ActionBlock:
+		result.Allowed = false
+		result.Reason = strings.Join(reasons, "; ")
+		return result, nil
+	case domain.ActionWarn:
+		result.Reason = strings.Join(reasons, "; ")
+	}
+
+	if redacted {
+		sanitized, err := doc.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode sanitized payload: %w", err)
+		}
+		result.SanitizedInput = sanitized
+		if vault.Len() > 0 {
+			result.Tokens = vault.Tokens()
+		}
+	}
+	return result, nil
+}
+
+func spanPaths(spans []domain.Span) string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, span := range spans {
+		if !seen[span.Path] {
+			seen[span.Path] = true
+			paths = append(paths, span.Path)
+		}
+	}
+	return strings.Join(paths, ",")
+}
+
+// redactSpans replaces every span with what replace returns for it.
+// Overlapping spans (e.g. a card number that also looks like a phone number)
+// are merged first and replaced as the earliest one.
+func redactSpans(text string, spans []Match, replace func(m Match, original string) string) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	var out []byte
+	last := 0
+	for i := 0; i < len(spans); {
+		first := spans[i]
+		start, end := first.Start, first.End
+		for i++; i < len(spans) && spans[i].Start < end; i++ {
+			end = max(end, spans[i].End)
+		}
+		start = max(start, last)
+		out = append(out, text[last:start]...)
+		out = append(out, replace(first, text[start:end])...)
+		last = end
+	}
+	return string(append(out, text[last:]...))
+}
@@ -0,0 +1,288 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// Buffers a WASM plugin reads and writes through the baldr host module.
+const (
+	wasmPayload  uint32 = 0 // The request, or the response in on_response
+	wasmMetadata uint32 = 1 // RequestMetadata as JSON, read-only
+	wasmVerdict  uint32 = 2 // GuardrailResponse as JSON
+)
+
+// wasmLogLimit caps the bytes of a plugin's log line.
+const wasmLogLimit = 1024
+
+// WASMGuardrailConfig configures a guardrail plugin compiled to WebAssembly.
+type WASMGuardrailConfig struct {
+	Path string
+	// MemoryLimitMB bounds the memory of an instance, 0 means 16
+	MemoryLimitMB int
+	// Timeout bounds a call, instantiation included, 0 means 100ms
+	Timeout time.Duration
+	// MaxConcurrency bounds the instances running at once, and so the CPU
+	// plugins take from the proxy. 0 means one per CPU.
+	MaxConcurrency int
+}
+
+// WASMGuardrail runs a guardrail plugin in-process, in a sandbox: the
+// plugin sees nothing of the host but the baldr module, and WASI without a
+// file system, environment or real clock. Every call gets a fresh instance,
+// so calls share no state.
+//
+// Plugins export their memory and on_request, on_response or both, taking
+// nothing and returning 0 on success. A missing export lets its direction
+// through. They import from the baldr module:
+//
+//	read(buffer, ptr, len i32) i32  copies up to len bytes of the buffer to
+//	                                ptr and returns its size, -1 on error
+//	write(buffer, ptr, len i32) i32 replaces the buffer with len bytes at
+//	                                ptr, returns 0, -1 on error
+//	log(ptr, len i32)               logs a line
+//
+// Buffers are 0 for the payload, 1 for the request's metadata and 2 for the
+// verdict, which starts as an allow. A rewritten payload is a redaction.
+type WASMGuardrail struct {
+	name    string
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	timeout time.Duration
+	slots   chan struct{}
+}
+
+// wasmCall is the state of a call, which host functions find in the context.
+type wasmCall struct {
+	buffers [3][]byte
+	written [3]bool
+}
+
+type wasmCallKey struct{}
+
+func NewWASMGuardrail(config WASMGuardrailConfig) (*WASMGuardrail, error) {
+	if config.MemoryLimitMB <= 0 {
+		config.MemoryLimitMB = 16
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 100 * time.Millisecond
+	}
+	if config.MaxConcurrency <= 0 {
+		config.MaxConcurrency = runtime.NumCPU()
+	}
+	binary, err := os.ReadFile(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm guardrail: %w", err)
+	}
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(config.MemoryLimitMB)*16). // 64KiB pages
+		WithCloseOnContextDone(true))
+	g := &WASMGuardrail{
+		name:    filepath.Base(config.Path),
+		runtime: r,
+		timeout: config.Timeout,
+		slots:   make(chan struct{}, config.MaxConcurrency),
+	}
+	if err := g.load(ctx, binary); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("invalid wasm guardrail %s: %w", g.name, err)
+	}
+	return g, nil
+}
+
+// load compiles the plugin and instantiates it once, so a plugin that can't
+// run fails at startup rather than on every request.
+func (g *WASMGuardrail) load(ctx context.Context, binary []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, g.runtime); err != nil {
+		return err
+	}
+	_, err := g.runtime.NewHostModuleBuilder("baldr").
+		NewFunctionBuilder().WithFunc(wasmRead).Export("read").
+		NewFunctionBuilder().WithFunc(wasmWrite).Export("write").
+		NewFunctionBuilder().WithFunc(g.log).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	if g.module, err = g.runtime.CompileModule(ctx, binary); err != nil {
+		return err
+	}
+
+	if _, ok := g.module.ExportedMemories()["memory"]; !ok {
+		return fmt.Errorf("no exported memory")
+	}
+	handlers := 0
+	for _, name := range []string{"on_request", "on_response"} {
+		fn, ok := g.module.ExportedFunctions()[name]
+		if !ok {
+			continue
+		}
+		if len(fn.ParamTypes()) != 0 || !slices.Equal(fn.ResultTypes(), []api.ValueType{api.ValueTypeI32}) {
+			return fmt.Errorf("%s must take nothing and return an i32", name)
+		}
+		handlers++
+	}
+	if handlers == 0 {
+		return fmt.Errorf("exports neither on_request nor on_response")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	mod, err := g.instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	return mod.Close(ctx)
+}
+
+func (g *WASMGuardrail) instantiate(ctx context.Context) (api.Module, error) {
+	// Unnamed, so instances don't clash. Reactors set themselves up in
+	// _initialize, commands' _start would exit.
+	return g.runtime.InstantiateModule(ctx, g.module, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+}
+
+// Validate calls on_request, or on_response for the output direction, on a
+// fresh instance. Traps, timeouts, non-zero returns and unreadable verdicts
+// are errors.
+func (g *WASMGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	md := domain.RequestMetadataFrom(ctx)
+	handler := "on_request"
+	if md.Direction == domain.DirectionOutput {
+		handler = "on_response"
+	}
+	if _, ok := g.module.ExportedFunctions()[handler]; !ok {
+		return &domain.GuardrailResponse{Version: domain.GuardrailContractVersion, Allowed: true, Action: domain.ActionAllow}, nil
+	}
+
+	select {
+	case g.slots <- struct{}{}:
+		defer func() { <-g.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	metadata, err := json.Marshal(md)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request metadata: %w", err)
+	}
+	call := &wasmCall{}
+	call.buffers[wasmPayload] = payload
+	call.buffers[wasmMetadata] = metadata
+	call.buffers[wasmVerdict] = []byte(`{"version":"` + domain.GuardrailContractVersion + `","allowed":true,"action":"allow"}`)
+	ctx = context.WithValue(ctx, wasmCallKey{}, call)
+
+	if err := g.call(ctx, handler); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("wasm guardrail %s: %s timed out after %s", g.name, handler, g.timeout)
+		}
+		return nil, fmt.Errorf("wasm guardrail %s: %s: %w", g.name, handler, err)
+	}
+	return g.verdict(call, md.Direction)
+}
+
+func (g *WASMGuardrail) call(ctx context.Context, handler string) error {
+	mod, err := g.instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	defer mod.Close(context.Background())
+
+	results, err := mod.ExportedFunction(handler).Call(ctx)
+	if err != nil {
+		return err
+	}
+	if status := int32(results[0]); status != 0 {
+		return fmt.Errorf("returned %d", status)
+	}
+	return nil
+}
+
+// verdict reads the verdict the plugin left, turning a rewritten payload
+// into a redaction.
+func (g *WASMGuardrail) verdict(call *wasmCall, direction domain.Direction) (*domain.GuardrailResponse, error) {
+	var verdict domain.GuardrailResponse
+	if err := json.Unmarshal(call.buffers[wasmVerdict], &verdict); err != nil {
+		return nil, fmt.Errorf("wasm guardrail %s: unreadable verdict: %w", g.name, err)
+	}
+	if err := checkContractVersion(verdict.Version); err != nil {
+		return nil, fmt.Errorf("wasm guardrail %s: %w", g.name, err)
+	}
+	if verdict.Action != "" && !verdict.Action.Valid() {
+		return nil, fmt.Errorf("wasm guardrail %s: unknown action %q", g.name, verdict.Action)
+	}
+
+	payload := call.buffers[wasmPayload]
+	if !call.written[wasmPayload] || verdict.HasSanitizedInput() {
+		return &verdict, nil
+	}
+	// Responses may be event streams, requests must stay JSON
+	if direction != domain.DirectionOutput && !json.Valid(payload) {
+		return nil, fmt.Errorf("wasm guardrail %s: rewrote the request into invalid JSON", g.name)
+	}
+	verdict.SanitizedInput = payload
+	if verdict.EffectiveAction() == domain.ActionAllow {
+		verdict.Action = domain.ActionRedact
+	}
+	return &verdict, nil
+}
+
+// Close releases the runtime and the compiled plugin.
+func (g *WASMGuardrail) Close() error {
+	return g.runtime.Close(context.Background())
+}
+
+func wasmRead(ctx context.Context, m api.Module, buffer, ptr, length uint32) int32 {
+	call, ok := ctx.Value(wasmCallKey{}).(*wasmCall)
+	if !ok || buffer >= uint32(len(call.buffers)) {
+		return -1
+	}
+	data := call.buffers[buffer]
+	if length > uint32(len(data)) {
+		length = uint32(len(data))
+	}
+	if !m.Memory().Write(ptr, data[:length]) {
+		return -1
+	}
+	return int32(len(data))
+}
+
+func wasmWrite(ctx context.Context, m api.Module, buffer, ptr, length uint32) int32 {
+	call, ok := ctx.Value(wasmCallKey{}).(*wasmCall)
+	if !ok || (buffer != wasmPayload && buffer != wasmVerdict) {
+		return -1
+	}
+	data, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return -1
+	}
+	// Read is a view of the instance's memory, which is closed after the call
+	call.buffers[buffer] = bytes.Clone(data)
+	call.written[buffer] = true
+	return 0
+}
+
+func (g *WASMGuardrail) log(_ context.Context, m api.Module, ptr, length uint32) {
+	data, ok := m.Memory().Read(ptr, min(length, wasmLogLimit))
+	if ok {
+		log.Printf("WASM guardrail %s: %s", g.name, data)
+	}
+}
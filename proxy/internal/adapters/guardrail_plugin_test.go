@@ -0,0 +1,264 @@
+package adapters_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// wasmFunc is an exported plugin function of type () -> i32.
+type wasmFunc struct {
+	export string
+	code   []byte // Instructions, without the final end
+}
+
+// wasmModule assembles a plugin importing baldr.read and baldr.write
+// (functions 0 and 1), with memory of the given pages and data at 1024.
+func wasmModule(pages int, data string, funcs ...wasmFunc) []byte {
+	section := func(id byte, items ...[]byte) []byte {
+		body := uleb(len(items))
+		for _, item := range items {
+			body = append(body, item...)
+		}
+		return append(append([]byte{id}, uleb(len(body))...), body...)
+	}
+	name := func(s string) []byte { return append(uleb(len(s)), s...) }
+	cat := func(parts ...[]byte) []byte {
+		var out []byte
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1,
+		[]byte{0x60, 0x03, 0x7f, 0x7f, 0x7f, 0x01, 0x7f}, // (i32, i32, i32) -> i32
+		[]byte{0x60, 0x00, 0x01, 0x7f},                   // () -> i32
+	)...)
+	module = append(module, section(2,
+		cat(name("baldr"), name("read"), []byte{0x00, 0x00}),
+		cat(name("baldr"), name("write"), []byte{0x00, 0x00}),
+	)...)
+	types := make([][]byte, len(funcs))
+	for i := range funcs {
+		types[i] = []byte{0x01}
+	}
+	module = append(module, section(3, types...)...)
+	module = append(module, section(5, cat([]byte{0x00}, uleb(pages)))...)
+	exports := [][]byte{cat(name("memory"), []byte{0x02, 0x00})}
+	codes := make([][]byte, len(funcs))
+	for i, f := range funcs {
+		exports = append(exports, cat(name(f.export), []byte{0x00}, uleb(i+2)))
+		body := cat([]byte{0x00}, f.code, []byte{0x0b}) // No locals
+		codes[i] = cat(uleb(len(body)), body)
+	}
+	module = append(module, section(7, exports...)...)
+	module = append(module, section(10, codes...)...)
+	if data != "" {
+		module = append(module, section(11, cat([]byte{0x00}, i32(1024), []byte{0x0b}, name(data)))...)
+	}
+	return module
+}
+
+func uleb(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// i32 is an i32.const instruction.
+func i32(n int) []byte {
+	out := []byte{0x41}
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && b&0x40 == 0) || (n == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// hostCall calls baldr.read (0) or baldr.write (1) with constant arguments.
+func hostCall(fn byte, buffer, ptr, length int) []byte {
+	code := append(i32(buffer), i32(ptr)...)
+	code = append(code, i32(length)...)
+	return append(code, 0x10, fn)
+}
+
+// writeData writes the module's data as a buffer, returning write's status.
+func writeData(buffer int, data string) []byte {
+	return hostCall(0x01, buffer, 1024, len(data))
+}
+
+func loadPlugin(t *testing.T, module []byte, query string) (*adapters.WASMGuardrail, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	if err := os.WriteFile(path, module, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := adapters.NewGuardrail(adapters.GuardrailConfig{BaseURL: "wasm://" + path + query, MaxConcurrency: 4})
+	if err != nil {
+		return nil, err
+	}
+	plugin := g.(*adapters.WASMGuardrail)
+	t.Cleanup(func() { plugin.Close() })
+	return plugin, nil
+}
+
+func outputContext() context.Context {
+	return domain.WithRequestMetadata(context.Background(), &domain.RequestMetadata{Direction: domain.DirectionOutput})
+}
+
+func TestWASMGuardrail(t *testing.T) {
+	payload := chatBody("Explain who is Baldr in a sentence.")
+	blocked := `{"version":"3","allowed":false,"action":"block","reason":"plugin says no"}`
+	redacted := `{"model":"gemini-2.5-flash","messages":[]}`
+	// Writes back what it reads, so the payload becomes the verdict
+	echo := append(append(i32(2), i32(0)...), hostCall(0x00, 0, 0, 4096)...)
+	echo = append(echo, 0x10, 0x01)
+
+	tests := []struct {
+		name        string
+		module      []byte
+		ctx         context.Context
+		payload     []byte
+		wantAction  domain.GuardrailAction
+		wantReason  string
+		wantPayload string // Empty means the payload must be left untouched
+	}{
+		{
+			name:       "Default verdict allows",
+			module:     wasmModule(1, "", wasmFunc{"on_request", i32(0)}),
+			wantAction: domain.ActionAllow,
+		},
+		{
+			name:       "Written verdict",
+			module:     wasmModule(1, blocked, wasmFunc{"on_request", writeData(2, blocked)}),
+			wantAction: domain.ActionBlock,
+			wantReason: "plugin says no",
+		},
+		{
+			name:        "Rewritten payload redacts",
+			module:      wasmModule(1, redacted, wasmFunc{"on_request", writeData(0, redacted)}),
+			wantAction:  domain.ActionRedact,
+			wantPayload: redacted,
+		},
+		{
+			name:       "Reads the payload",
+			module:     wasmModule(1, "", wasmFunc{"on_request", echo}),
+			payload:    []byte(`{"allowed":false,"reason":"echoed"}`),
+			wantAction: domain.ActionBlock,
+			wantReason: "echoed",
+		},
+		{
+			name:   "Responses go to on_response",
+			module: wasmModule(1, "data: [DONE]\n\n", wasmFunc{"on_request", i32(1)}, wasmFunc{"on_response", writeData(0, "data: [DONE]\n\n")}),
+			ctx:    outputContext(),
+			// Rewritten responses needn't be JSON
+			wantAction:  domain.ActionRedact,
+			wantPayload: "data: [DONE]\n\n",
+		},
+		{
+			name:       "Without on_response responses are allowed",
+			module:     wasmModule(1, "", wasmFunc{"on_request", i32(1)}),
+			ctx:        outputContext(),
+			wantAction: domain.ActionAllow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin, err := loadPlugin(t, tt.module, "")
+			if err != nil {
+				t.Fatalf("Failed to load plugin: %v", err)
+			}
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			body := tt.payload
+			if body == nil {
+				body = payload
+			}
+			verdict, err := plugin.Validate(ctx, body)
+			if err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if got := verdict.EffectiveAction(); got != tt.wantAction {
+				t.Errorf("Expected action %s, got %s", tt.wantAction, got)
+			}
+			if verdict.Reason != tt.wantReason {
+				t.Errorf("Expected reason %q, got %q", tt.wantReason, verdict.Reason)
+			}
+			if got := string(verdict.SanitizedInput); got != tt.wantPayload {
+				t.Errorf("Expected sanitized payload %q, got %q", tt.wantPayload, got)
+			}
+		})
+	}
+}
+
+func TestWASMGuardrail_Errors(t *testing.T) {
+	payload := chatBody("Explain who is Baldr in a sentence.")
+	loop := []byte{0x03, 0x40, 0x0c, 0x00, 0x0b} // loop br 0 end
+	tests := []struct {
+		name    string
+		module  []byte
+		query   string
+		wantErr string
+	}{
+		{"Runs too long", wasmModule(1, "", wasmFunc{"on_request", append(loop, i32(0)...)}), "?timeout_ms=50", "timed out after 50ms"},
+		{"Traps", wasmModule(1, "", wasmFunc{"on_request", []byte{0x00}}), "", "unreachable"},
+		{"Returns an error", wasmModule(1, "", wasmFunc{"on_request", i32(3)}), "", "returned 3"},
+		{"Writes the metadata", wasmModule(1, "{}", wasmFunc{"on_request", writeData(1, "{}")}), "", "returned -1"},
+		{"Unreadable verdict", wasmModule(1, "nope", wasmFunc{"on_request", writeData(2, "nope")}), "", "unreadable verdict"},
+		{"Unknown action", wasmModule(1, `{"action":"shrug"}`, wasmFunc{"on_request", writeData(2, `{"action":"shrug"}`)}), "", `unknown action "shrug"`},
+		{"Newer contract", wasmModule(1, `{"version":"99"}`, wasmFunc{"on_request", writeData(2, `{"version":"99"}`)}), "", "unsupported guardrail contract version"},
+		{"Request rewritten to invalid JSON", wasmModule(1, "oops", wasmFunc{"on_request", writeData(0, "oops")}), "", "invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin, err := loadPlugin(t, tt.module, tt.query)
+			if err != nil {
+				t.Fatalf("Failed to load plugin: %v", err)
+			}
+			_, err = plugin.Validate(context.Background(), payload)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWASMGuardrail_InvalidPlugins(t *testing.T) {
+	tests := []struct {
+		name    string
+		module  []byte
+		query   string
+		wantErr string
+	}{
+		{"Not WebAssembly", []byte("\x7fELF"), "", "invalid wasm guardrail"},
+		{"No handlers", wasmModule(1, ""), "", "neither on_request nor on_response"},
+		{"Memory over the limit", wasmModule(32, "", wasmFunc{"on_request", i32(0)}), "?memory_mb=1", "over limit of 16 pages"},
+		{"Invalid limit", wasmModule(1, "", wasmFunc{"on_request", i32(0)}), "?memory_mb=lots", "invalid wasm guardrail memory_mb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPlugin(t, tt.module, tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package adapters
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// KeyFileStore keeps virtual keys in memory and saves them to a JSON file
+// after every change. Spend changes with every request, so it is saved at
+// most once per FlushInterval and on Close.
+type KeyFileStore struct {
+	path          string
+	flushInterval time.Duration
+	readOnly      bool
+
+	mu      sync.Mutex
+	keys    map[string]*domain.VirtualKey
+	byHash  map[string]string // Secret hash to key ID
+	dirty   bool
+	flushed time.Time
+}
+
+// storedKey is a key as saved, hash included.
+type storedKey struct {
+	*domain.VirtualKey
+	Hash string `json:"hash"`
+}
+
+type KeyStoreConfig struct {
+	// Path of the JSON file, empty to keep keys in memory only
+	Path string
+	// FlushInterval of spend changes, 0 means 10s
+	FlushInterval time.Duration
+	// ReadOnly loads the file but never writes it, changes stay in memory
+	ReadOnly bool
+}
+
+func NewKeyFileStore(config KeyStoreConfig) (*KeyFileStore, error) {
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	s := &KeyFileStore{
+		path:          config.Path,
+		flushInterval: config.FlushInterval,
+		readOnly:      config.ReadOnly,
+		keys:          make(map[string]*domain.VirtualKey),
+		byHash:        make(map[string]string),
+	}
+	if s.path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key store: %w", err)
+	}
+	var stored []storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse key store: %w", err)
+	}
+	for _, k := range stored {
+		if k.VirtualKey == nil || k.ID == "" || k.Hash == "" {
+			return nil, fmt.Errorf("key store has a key without an id or hash")
+		}
+		k.VirtualKey.Hash = k.Hash
+		s.keys[k.ID] = k.VirtualKey
+		s.byHash[k.Hash] = k.ID
+	}
+	return s, nil
+}
+
+func (s *KeyFileStore) Create(ctx context.Context, key *domain.VirtualKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("key %s already exists", key.ID)
+	}
+	s.put(copyKey(key))
+	return s.save()
+}
+
+func (s *KeyFileStore) Get(ctx context.Context, id string) (*domain.VirtualKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, domain.ErrKeyNotFound
+	}
+	return copyKey(key), nil
+}
+
+func (s *KeyFileStore) FindByHash(ctx context.Context, hash string) (*domain.VirtualKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.byHash[hash]
+	if !ok {
+		return nil, domain.ErrKeyNotFound
+	}
+	return copyKey(s.keys[id]), nil
+}
+
+// List returns the keys, oldest first.
+func (s *KeyFileStore) List(ctx context.Context) ([]*domain.VirtualKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]*domain.VirtualKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, copyKey(key))
+	}
+	slices.SortFunc(keys, func(a, b *domain.VirtualKey) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return keys, nil
+}
+
+// Update replaces a key, keeping the spend recorded since it was read.
+func (s *KeyFileStore) Update(ctx context.Context, key *domain.VirtualKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.keys[key.ID]
+	if !ok {
+		return domain.ErrKeyNotFound
+	}
+	delete(s.byHash, current.Hash)
+	updated := copyKey(key)
+	updated.SpentUSD = current.SpentUSD
+	s.put(updated)
+	return s.save()
+}
+
+func (s *KeyFileStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return domain.ErrKeyNotFound
+	}
+	delete(s.keys, id)
+	delete(s.byHash, key.Hash)
+	return s.save()
+}
+
+func (s *KeyFileStore) AddSpend(ctx context.Context, id string, usd float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return domain.ErrKeyNotFound
+	}
+	key.SpentUSD += usd
+	s.dirty = true
+	if time.Since(s.flushed) < s.flushInterval {
+		return nil
+	}
+	return s.save()
+}
+
+// Close saves the spend not saved yet.
+func (s *KeyFileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *KeyFileStore) put(key *domain.VirtualKey) {
+	s.keys[key.ID] = key
+	s.byHash[key.Hash] = key.ID
+}
+
+// save writes every key to the file, through a temporary file so a crash
+// never leaves it half written. s.mu held.
+func (s *KeyFileStore) save() error {
+	s.dirty = false
+	s.flushed = time.Now()
+	if s.path == "" || s.readOnly {
+		return nil
+	}
+	stored := make([]storedKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		stored = append(stored, storedKey{VirtualKey: key, Hash: key.Hash})
+	}
+	slices.SortFunc(stored, func(a, b storedKey) int { return cmp.Compare(a.ID, b.ID) })
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save key store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save key store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save key store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save key store: %w", err)
+	}
+	return nil
+}
+
+func copyKey(key *domain.VirtualKey) *domain.VirtualKey {
+	c := *key
+	c.Models = slices.Clone(key.Models)
+	if key.ExpiresAt != nil {
+		expires := *key.ExpiresAt
+		c.ExpiresAt = &expires
+	}
+	return &c
+}
@@ -0,0 +1,84 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func TestKeyFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := adapters.NewKeyFileStore(adapters.KeyStoreConfig{Path: path, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	key := &domain.VirtualKey{ID: "vk_1", KeySpec: domain.KeySpec{Owner: "ada", Models: []string{"m"}}, Status: domain.KeyActive, Hash: "h1"}
+	if err := store.Create(ctx, key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	key.Models[0] = "changed"
+	if got, _ := store.Get(ctx, "vk_1"); got.Models[0] != "m" {
+		t.Error("Expected the store to keep a copy of the key")
+	}
+
+	// A rotation replaces the hash the key is found by
+	rotated, _ := store.Get(ctx, "vk_1")
+	rotated.Hash = "h2"
+	store.AddSpend(ctx, "vk_1", 0.5)
+	if err := store.Update(ctx, rotated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := store.FindByHash(ctx, "h1"); !errors.Is(err, domain.ErrKeyNotFound) {
+		t.Errorf("Expected the old hash to be forgotten, got %v", err)
+	}
+	if got, err := store.FindByHash(ctx, "h2"); err != nil || got.SpentUSD != 0.5 {
+		t.Errorf("Expected the update to keep the spend, got %+v (%v)", got, err)
+	}
+
+	// Spend is saved on Close, and the hash is saved but never the secret
+	store.AddSpend(ctx, "vk_1", 0.25)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"hash": "h2"`) {
+		t.Errorf("Expected the hash in the file, got %s", data)
+	}
+	reloaded, err := adapters.NewKeyFileStore(adapters.KeyStoreConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, err := reloaded.FindByHash(ctx, "h2"); err != nil || got.SpentUSD != 0.75 || got.Owner != "ada" {
+		t.Errorf("Expected the key back from the file, got %+v (%v)", got, err)
+	}
+
+	// A read-only store has the keys, but its changes never reach the file
+	saved, _ := os.ReadFile(path)
+	readOnly, err := adapters.NewKeyFileStore(adapters.KeyStoreConfig{Path: path, ReadOnly: true, FlushInterval: -1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	readOnly.AddSpend(ctx, "vk_1", 1)
+	readOnly.Delete(ctx, "vk_1")
+	if err := readOnly.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(saved) {
+		t.Errorf("Expected a read-only store to leave the file alone, got %s", data)
+	}
+
+	if err := reloaded.Delete(ctx, "vk_1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if keys, _ := reloaded.List(ctx); len(keys) != 0 {
+		t.Errorf("Expected no keys left, got %d", len(keys))
+	}
+}
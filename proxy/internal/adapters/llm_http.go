@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
 )
 
 type LLMConfig struct {
@@ -34,7 +37,8 @@ func NewLLM(config LLMConfig) *LLM {
 func (a *LLM) Generate(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
 
 	// Re-create the request for the upstream
-	req, err := http.NewRequestWithContext(ctx, "POST", a.baseURL, bytes.NewReader(payload))
+	endpoint := domain.RequestMetadataFrom(ctx).Endpoint
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpointURL(endpoint), bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
@@ -61,3 +65,36 @@ func (a *LLM) Generate(ctx context.Context, payload []byte, headers map[string]s
 	// Return the body directly for streaming
 	return resp.Body, nil
 }
+
+// Models lists the upstream's models, as the upstream formats them.
+func (a *LLM) Models(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.endpointURL(domain.EndpointModels), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("upstream returned status: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// endpointURL derives the URL of an endpoint from BaseURL, which is the
+// chat completions URL (https://host/v1/chat/completions) or the API root
+// (https://host/v1/).
+func (a *LLM) endpointURL(endpoint domain.Endpoint) string {
+	root, isChat := strings.CutSuffix(a.baseURL, "/chat/completions")
+	if endpoint.IsChat() {
+		if isChat {
+			return a.baseURL
+		}
+		endpoint = domain.EndpointChat
+	}
+	return strings.TrimSuffix(root, "/") + "/" + string(endpoint)
+}
@@ -0,0 +1,55 @@
+package adapters_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func TestLLM_Endpoints(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	call := func(llm *adapters.LLM, endpoint domain.Endpoint) {
+		ctx := domain.WithRequestMetadata(context.Background(), &domain.RequestMetadata{Endpoint: endpoint})
+		var stream io.ReadCloser
+		var err error
+		if endpoint == domain.EndpointModels {
+			stream, err = llm.Models(ctx)
+		} else {
+			stream, err = llm.Generate(ctx, []byte(`{}`), nil)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stream.Close()
+	}
+
+	// The base URL may be the chat completions URL or the API root
+	for _, base := range []string{srv.URL + "/v1/chat/completions", srv.URL + "/v1/", srv.URL + "/v1"} {
+		paths = nil
+		llm := adapters.NewLLM(adapters.LLMConfig{BaseURL: base})
+		for _, endpoint := range []domain.Endpoint{"", domain.EndpointEmbeddings, domain.EndpointResponses, domain.EndpointModels} {
+			call(llm, endpoint)
+		}
+		want := []string{"POST /v1/chat/completions", "POST /v1/embeddings", "POST /v1/responses", "GET /v1/models"}
+		if len(paths) != len(want) {
+			t.Fatalf("Base %s: expected %v, got %v", base, want, paths)
+		}
+		for i := range want {
+			if paths[i] != want[i] {
+				t.Errorf("Base %s: expected %v, got %v", base, want, paths)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// ModelCatalogConfig is the JSON file describing the models the proxy
+// offers besides the main upstream's.
+type ModelCatalogConfig struct {
+	// RefreshSeconds between listings of the providers' models, 0 means 300
+	RefreshSeconds int              `json:"refresh_seconds"`
+	Providers      []ProviderConfig `json:"providers"`
+	Aliases        []AliasConfig    `json:"aliases"`
+	// Models holds the metadata shown for each model, by name
+	Models map[string]domain.ModelMetadata `json:"models"`
+	// Access lists the model patterns each key fingerprint may use. Keys
+	// without an entry get the "*" entry's, or no models at all.
+	Access map[string][]string `json:"access"`
+}
+
+// ProviderConfig is an OpenAI compatible upstream. The key is read from the
+// named environment variable.
+type ProviderConfig struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+}
+
+type AliasConfig struct {
+	Name     string `json:"name"`
+	Model    string `json:"model"`
+	Provider string `json:"provider,omitempty"` // Empty for the main upstream
+}
+
+func LoadModelCatalogConfig(path string) (ModelCatalogConfig, error) {
+	var config ModelCatalogConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read models config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse models config: %w", err)
+	}
+	return config, config.validate()
+}
+
+func (c ModelCatalogConfig) validate() error {
+	providers := map[string]bool{"": true, "default": true}
+	for _, p := range c.Providers {
+		if p.Name == "" || p.URL == "" {
+			return fmt.Errorf("provider needs a name and a url")
+		}
+		if providers[p.Name] {
+			return fmt.Errorf("provider %q is defined twice, or reserved", p.Name)
+		}
+		providers[p.Name] = true
+	}
+	aliases := make(map[string]bool)
+	for _, a := range c.Aliases {
+		if a.Name == "" || a.Model == "" {
+			return fmt.Errorf("alias needs a name and a model")
+		}
+		if aliases[a.Name] {
+			return fmt.Errorf("alias %q is defined twice", a.Name)
+		}
+		aliases[a.Name] = true
+		if !providers[a.Provider] {
+			return fmt.Errorf("alias %q: unknown provider %q", a.Name, a.Provider)
+		}
+	}
+	for key, patterns := range c.Access {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("access of %s: bad pattern %q", key, pattern)
+			}
+		}
+	}
+	return nil
+}
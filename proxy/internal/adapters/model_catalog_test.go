@@ -0,0 +1,32 @@
+package adapters_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+)
+
+func TestLoadModelCatalogConfig(t *testing.T) {
+	config, err := adapters.LoadModelCatalogConfig("../../config/models.example.json")
+	if err != nil {
+		t.Fatalf("Failed to load the example: %v", err)
+	}
+	if len(config.Providers) != 1 || len(config.Aliases) != 2 || config.Models["gpt-4o"].ContextWindow != 128000 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for name, body := range map[string]string{
+		"unknown provider":  `{"aliases": [{"name": "smart", "model": "gpt-4o", "provider": "openai"}]}`,
+		"reserved provider": `{"providers": [{"name": "default", "url": "http://x"}]}`,
+		"duplicate alias":   `{"aliases": [{"name": "a", "model": "m"}, {"name": "a", "model": "n"}]}`,
+		"bad pattern":       `{"access": {"key-1": ["gpt-["]}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "models.json")
+		os.WriteFile(path, []byte(body), 0o600)
+		if _, err := adapters.LoadModelCatalogConfig(path); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
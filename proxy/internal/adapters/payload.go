@@ -0,0 +1,112 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// textField is a piece of free text inside a request body that guardrails
+// may inspect and rewrite.
+type textField struct {
+	Path  string // Location in the body, e.g. "messages.1.content"
+	Role  string // Message role, empty for non-chat fields
+	Value string
+	set   func(string)
+}
+
+// requestDocument is a decoded request body that can be re-encoded after its
+// text fields have been rewritten.
+type requestDocument struct {
+	root   any
+	raw    []byte
+	isJSON bool
+	Fields []*textField
+}
+
+// parseRequestDocument extracts the free-text fields of an OpenAI-compatible
+// body: chat message contents (plain or multi-part) and legacy prompts.
+// Bodies that aren't JSON are treated as a single text field.
+func parseRequestDocument(payload []byte) *requestDocument {
+	doc := &requestDocument{raw: payload}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber() // Keep numeric parameters byte-for-byte
+	if err := dec.Decode(&doc.root); err != nil {
+		doc.Fields = []*textField{{Value: string(payload), set: func(v string) { doc.raw = []byte(v) }}}
+		return doc
+	}
+	doc.isJSON = true
+
+	switch root := doc.root.(type) {
+	case string:
+		doc.Fields = append(doc.Fields, &textField{Value: root, set: func(v string) { doc.root = v }})
+	case map[string]any:
+		if messages, ok := root["messages"].([]any); ok {
+			for i, m := range messages {
+				if msg, ok := m.(map[string]any); ok {
+					doc.addMessage(fmt.Sprintf("messages.%d", i), msg)
+				}
+			}
+		}
+		doc.addStrings(root, "prompt")
+	}
+	return doc
+}
+
+func (d *requestDocument) addMessage(path string, msg map[string]any) {
+	role, _ := msg["role"].(string)
+	path += ".content"
+	switch content := msg["content"].(type) {
+	case string:
+		d.Fields = append(d.Fields, &textField{Path: path, Role: role, Value: content, set: func(v string) { msg["content"] = v }})
+	case []any:
+		// Multi-part content: only the text parts are inspected
+		for i, p := range content {
+			if part, ok := p.(map[string]any); ok {
+				if text, ok := part["text"].(string); ok {
+					d.Fields = append(d.Fields, &textField{
+						Path:  fmt.Sprintf("%s.%d.text", path, i),
+						Role:  role,
+						Value: text,
+						set:   func(v string) { part["text"] = v },
+					})
+				}
+			}
+		}
+	}
+}
+
+// addStrings registers obj[key] when it holds a string or a list of strings.
+func (d *requestDocument) addStrings(obj map[string]any, key string) {
+	switch value := obj[key].(type) {
+	case string:
+		d.Fields = append(d.Fields, &textField{Path: key, Value: value, set: func(v string) { obj[key] = v }})
+	case []any:
+		for i, item := range value {
+			if s, ok := item.(string); ok {
+				d.Fields = append(d.Fields, &textField{Path: fmt.Sprintf("%s.%d", key, i), Value: s, set: func(v string) { value[i] = v }})
+			}
+		}
+	}
+}
+
+// Set rewrites a field both in the document and in the field itself.
+func (f *textField) Set(value string) {
+	f.Value = value
+	f.set(value)
+}
+
+// Bytes re-encodes the document.
+func (d *requestDocument) Bytes() ([]byte, error) {
+	if !d.isJSON {
+		return d.raw, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // Leave <, > and & in prompts untouched
+	if err := enc.Encode(d.root); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
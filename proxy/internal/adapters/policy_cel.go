@@ -0,0 +1,242 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"strings"
+	_ "time/tzdata" // Policies may ask for the hour in any time zone
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// ExpressionPoliciesConfig is the JSON file of expression policies,
+// evaluated in order.
+type ExpressionPoliciesConfig struct {
+	Policies []ExpressionPolicy `json:"policies"`
+	// CostLimit bounds the work of a single expression, 0 means 100000
+	CostLimit uint64 `json:"cost_limit,omitempty"`
+}
+
+// ExpressionPolicy applies its action to the requests its When expression
+// holds for. An allow stops the evaluation, a deny refuses the request,
+// and routes and transforms apply before the next policy is evaluated.
+type ExpressionPolicy struct {
+	Name   string `json:"name"`
+	When   string `json:"when"`
+	Action string `json:"action"` // allow, deny, route or transform
+	// Reason given to denied clients
+	Reason string `json:"reason,omitempty"`
+	// Model a route sends the request to
+	Model string `json:"model,omitempty"`
+	// Set maps the fields a transform sets to the expression of their
+	// value. A null value removes the field.
+	Set map[string]string `json:"set,omitempty"`
+}
+
+// Variables of the expressions. Dotted names are declared one by one, so a
+// misspelt field fails to compile instead of failing requests.
+var policyVariables = []cel.EnvOption{
+	cel.Variable("key.id", cel.StringType),
+	cel.Variable("key.team", cel.StringType),
+	cel.Variable("key.owner", cel.StringType),
+	cel.Variable("key.name", cel.StringType),
+	cel.Variable("key.virtual", cel.BoolType),
+	cel.Variable("key.spent_usd", cel.DoubleType),
+	cel.Variable("key.budget_usd", cel.DoubleType),
+	cel.Variable("request.model", cel.StringType),
+	cel.Variable("request.endpoint", cel.StringType),
+	cel.Variable("request.user", cel.StringType),
+	cel.Variable("request.provider", cel.StringType),
+	cel.Variable("request.body", cel.MapType(cel.StringType, cel.DynType)),
+	cel.Variable("tokens.prompt", cel.IntType),
+	cel.Variable("guardrail.action", cel.StringType),
+	cel.Variable("guardrail.scores", cel.MapType(cel.StringType, cel.DoubleType)),
+	cel.Variable("now", cel.TimestampType),
+}
+
+// CELPolicies evaluates expression policies written in CEL. Expressions are
+// compiled once, when the policies are loaded.
+type CELPolicies struct {
+	policies []celPolicy
+}
+
+type celPolicy struct {
+	ExpressionPolicy
+	when cel.Program
+	set  map[string]cel.Program
+}
+
+func LoadExpressionPolicies(path string) (*CELPolicies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expression policies: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var config ExpressionPoliciesConfig
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse expression policies: %w", err)
+	}
+	return NewCELPolicies(config)
+}
+
+// NewCELPolicies compiles the policies, and reports every expression that
+// doesn't compile or doesn't return what its policy needs.
+func NewCELPolicies(config ExpressionPoliciesConfig) (*CELPolicies, error) {
+	if config.CostLimit == 0 {
+		config.CostLimit = 100000
+	}
+	env, err := cel.NewEnv(append(policyVariables, cel.OptionalTypes())...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up expression policies: %w", err)
+	}
+	compile := func(src string, output *cel.Type) (cel.Program, error) {
+		ast, issues := env.Compile(src)
+		if issues.Err() != nil {
+			return nil, issues.Err()
+		}
+		// Dynamic results, e.g. from request.body, are checked when evaluated
+		if output != nil && !ast.OutputType().IsExactType(output) && !ast.OutputType().IsExactType(cel.DynType) {
+			return nil, fmt.Errorf("expected %s, the expression returns %s", output, ast.OutputType())
+		}
+		return env.Program(ast, cel.CostLimit(config.CostLimit), cel.InterruptCheckFrequency(100))
+	}
+
+	var errs []string
+	names := make(map[string]bool, len(config.Policies))
+	p := &CELPolicies{}
+	for _, policy := range config.Policies {
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Sprintf("policy %q: ", policy.Name)+fmt.Sprintf(format, args...))
+		}
+		switch {
+		case policy.Name == "":
+			fail("a name is required")
+		case names[policy.Name]:
+			fail("defined twice")
+		}
+		names[policy.Name] = true
+
+		compiled := celPolicy{ExpressionPolicy: policy}
+		if compiled.when, err = compile(policy.When, cel.BoolType); err != nil {
+			fail("when: %v", err)
+		}
+		switch policy.Action {
+		case "allow", "deny":
+		case "route":
+			if policy.Model == "" {
+				fail("a route needs a model")
+			}
+		case "transform":
+			if len(policy.Set) == 0 {
+				fail("a transform needs fields to set")
+			}
+			compiled.set = make(map[string]cel.Program, len(policy.Set))
+			for field, src := range policy.Set {
+				if compiled.set[field], err = compile(src, nil); err != nil {
+					fail("set %s: %v", field, err)
+				}
+			}
+		default:
+			fail("action must be allow, deny, route or transform, got %q", policy.Action)
+		}
+		p.policies = append(p.policies, compiled)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid expression policies:\n%s", strings.Join(errs, "\n"))
+	}
+	return p, nil
+}
+
+// Evaluate runs the policies in order. An expression that fails to evaluate
+// is an error, which the service treats as a denial.
+func (p *CELPolicies) Evaluate(ctx context.Context, input domain.PolicyInput) (*domain.PolicyDecision, error) {
+	vars := policyActivation(input)
+	decision := &domain.PolicyDecision{}
+	for _, policy := range p.policies {
+		out, _, err := policy.when.ContextEval(ctx, vars)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", policy.Name, err)
+		}
+		if out.Type() != types.BoolType {
+			return nil, fmt.Errorf("policy %q: when returned %s, not a bool", policy.Name, out.Type())
+		}
+		if out != types.True {
+			continue
+		}
+		decision.Applied = append(decision.Applied, policy.Name)
+		switch policy.Action {
+		case "allow":
+			return decision, nil
+		case "deny":
+			decision.Denied, decision.Reason = policy.Name, policy.Reason
+			return decision, nil
+		case "route":
+			decision.Model = policy.Model
+			vars["request.model"] = policy.Model
+		case "transform":
+			if decision.Set == nil {
+				decision.Set = make(map[string]any)
+			}
+			// Copied, the caller's body is left alone
+			body := maps.Clone(vars["request.body"].(map[string]any))
+			for field, prg := range policy.set {
+				out, _, err := prg.ContextEval(ctx, vars)
+				if err != nil {
+					return nil, fmt.Errorf("policy %q: set %s: %w", policy.Name, field, err)
+				}
+				value, err := out.ConvertToNative(reflect.TypeFor[*structpb.Value]())
+				if err != nil {
+					return nil, fmt.Errorf("policy %q: set %s: %w", policy.Name, field, err)
+				}
+				decision.Set[field] = value.(*structpb.Value).AsInterface()
+				body[field] = decision.Set[field]
+			}
+			// Later policies see the request as transformed
+			vars["request.body"] = body
+		}
+	}
+	return decision, nil
+}
+
+func policyActivation(input domain.PolicyInput) map[string]any {
+	scores := make(map[string]float64, len(input.Violations))
+	for _, v := range input.Violations {
+		scores[v.Category] = max(scores[v.Category], v.Score)
+	}
+	vars := map[string]any{
+		"key.id":           input.KeyID,
+		"key.team":         "",
+		"key.owner":        "",
+		"key.name":         "",
+		"key.virtual":      input.Key != nil,
+		"key.spent_usd":    0.0,
+		"key.budget_usd":   0.0,
+		"request.model":    input.Model,
+		"request.endpoint": string(input.Endpoint),
+		"request.user":     input.User,
+		"request.provider": input.Provider,
+		"request.body":     input.Body,
+		"tokens.prompt":    input.PromptTokens,
+		"guardrail.action": string(input.GuardrailAction),
+		"guardrail.scores": scores,
+		"now":              input.Time,
+	}
+	if k := input.Key; k != nil {
+		vars["key.team"], vars["key.owner"], vars["key.name"] = k.Team, k.Owner, k.Name
+		vars["key.spent_usd"], vars["key.budget_usd"] = k.SpentUSD, k.BudgetUSD
+	}
+	if input.Body == nil {
+		vars["request.body"] = map[string]any{}
+	}
+	return vars
+}
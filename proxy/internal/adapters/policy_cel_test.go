@@ -0,0 +1,96 @@
+package adapters_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func TestCELPolicies(t *testing.T) {
+	policies, err := adapters.LoadExpressionPolicies("../../config/expression-policies.example.json")
+	if err != nil {
+		t.Fatalf("Failed to load the example: %v", err)
+	}
+	rome, _ := time.LoadLocation("Europe/Rome")
+	evening := time.Date(2026, 10, 19, 20, 0, 0, 0, rome)
+	morning := time.Date(2026, 10, 19, 10, 0, 0, 0, rome)
+	interns := &domain.VirtualKey{KeySpec: domain.KeySpec{Team: "interns"}}
+
+	tests := []struct {
+		name  string
+		input domain.PolicyInput
+		want  domain.PolicyDecision
+	}{
+		{"intern in the evening", domain.PolicyInput{Key: interns, Model: "gpt-4o", PromptTokens: 3000, Time: evening},
+			domain.PolicyDecision{Denied: "interns-business-hours", Reason: "gpt-4o is for business hours, or prompts under 2k tokens", Applied: []string{"interns-business-hours"}}},
+		{"intern in the morning", domain.PolicyInput{Key: interns, Model: "gpt-4o", PromptTokens: 3000, Time: morning},
+			domain.PolicyDecision{}},
+		// Without a virtual key there is no team, whatever the caller claims
+		{"no key in the evening", domain.PolicyInput{KeyID: "key-123", Model: "gpt-4o", PromptTokens: 3000, Time: evening},
+			domain.PolicyDecision{}},
+		{"intern with a short prompt", domain.PolicyInput{Key: interns, Model: "gpt-4o", PromptTokens: 100, Time: evening},
+			domain.PolicyDecision{}},
+		{"admins skip the rest", domain.PolicyInput{Key: &domain.VirtualKey{KeySpec: domain.KeySpec{Team: "admins"}}, PromptTokens: 60000, Time: morning, Violations: []domain.Violation{{Category: "prompt_injection", Score: 0.9}}},
+			domain.PolicyDecision{Applied: []string{"admins"}}},
+		{"flagged and long", domain.PolicyInput{Model: "gpt-4o", PromptTokens: 60000, Time: morning,
+			Violations: []domain.Violation{{Category: "prompt_injection", Score: 0.3}, {Category: "prompt_injection", Score: 0.7}},
+			Body:       map[string]any{"max_tokens": 300.0}},
+			domain.PolicyDecision{Model: "gemini-2.5-flash", Set: map[string]any{"max_tokens": 300.0, "temperature": 0.2}, Applied: []string{"flagged-to-cheap-model", "cap-long-prompts"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policies.Evaluate(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got.Denied != tt.want.Denied || got.Reason != tt.want.Reason || got.Model != tt.want.Model ||
+				strings.Join(got.Applied, ",") != strings.Join(tt.want.Applied, ",") || len(got.Set) != len(tt.want.Set) {
+				t.Fatalf("Expected %+v, got %+v", tt.want, got)
+			}
+			for field, value := range tt.want.Set {
+				if got.Set[field] != value {
+					t.Errorf("Expected %s set to %v, got %v", field, value, got.Set[field])
+				}
+			}
+		})
+	}
+}
+
+func TestCELPolicies_Errors(t *testing.T) {
+	_, err := adapters.NewCELPolicies(adapters.ExpressionPoliciesConfig{Policies: []adapters.ExpressionPolicy{
+		{Name: "syntax", When: "key.team ==", Action: "deny"},
+		{Name: "typo", When: "key.tem == 'a'", Action: "deny"},
+		{Name: "not a condition", When: "tokens.prompt", Action: "deny"},
+		{Name: "unknown action", When: "true", Action: "block"},
+		{Name: "route", When: "true", Action: "route"},
+		{Name: "transform", When: "true", Action: "transform", Set: map[string]string{"temperature": "request.temperatur"}},
+		{Name: "typo", When: "true", Action: "allow"},
+	}})
+	if err == nil {
+		t.Fatal("Expected the policies to be rejected")
+	}
+	// Every problem is reported at once, with its policy
+	for _, want := range []string{
+		`"syntax": when:`, `"typo": when:`, "undeclared reference to 'key'", `"not a condition": when: expected bool`,
+		`"unknown action": action must be`, `"route": a route needs a model`, `"transform": set temperature:`, `"typo": defined twice`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+
+	// Expressions failing on a request fail it
+	policies, err := adapters.NewCELPolicies(adapters.ExpressionPoliciesConfig{Policies: []adapters.ExpressionPolicy{
+		{Name: "strict", When: "request.body.temperature > 1.0", Action: "deny"},
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := policies.Evaluate(context.Background(), domain.PolicyInput{Body: map[string]any{}}); err == nil || !strings.Contains(err.Error(), `"strict"`) {
+		t.Errorf("Expected the missing field to fail the request, got %v", err)
+	}
+}
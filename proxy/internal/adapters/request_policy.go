@@ -0,0 +1,58 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// RequestPoliciesConfig is the JSON file of request policies, checked in
+// order.
+type RequestPoliciesConfig struct {
+	Policies []domain.RequestPolicy `json:"policies"`
+}
+
+// LoadRequestPolicies reads the policies file. Unknown fields are refused: a
+// misspelt rule would otherwise be silently left unenforced.
+func LoadRequestPolicies(path string) ([]domain.RequestPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request policies: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var config RequestPoliciesConfig
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse request policies: %w", err)
+	}
+	return config.Policies, config.validate()
+}
+
+func (c RequestPoliciesConfig) validate() error {
+	names := make(map[string]bool, len(c.Policies))
+	for _, p := range c.Policies {
+		if p.Name == "" {
+			return fmt.Errorf("request policy needs a name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("request policy %q is defined twice", p.Name)
+		}
+		names[p.Name] = true
+		for _, pattern := range append(p.Models, p.AllowedTools...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("request policy %q: bad pattern %q", p.Name, pattern)
+			}
+		}
+		if p.MaxTokens < 0 {
+			return fmt.Errorf("request policy %q: max_tokens can't be negative", p.Name)
+		}
+		if r := p.Temperature; r != nil && r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("request policy %q: temperature min is over max", p.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package adapters_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+)
+
+func TestLoadRequestPolicies(t *testing.T) {
+	policies, err := adapters.LoadRequestPolicies("../../config/policies.example.json")
+	if err != nil {
+		t.Fatalf("Failed to load the example: %v", err)
+	}
+	if len(policies) != 3 || *policies[0].Temperature.Max != 1.5 || *policies[1].Images || *policies[2].Stream {
+		t.Errorf("Unexpected policies %+v", policies)
+	}
+
+	for name, body := range map[string]string{
+		"no name":             `{"policies": [{"max_tokens": 10}]}`,
+		"duplicate":           `{"policies": [{"name": "a"}, {"name": "a"}]}`,
+		"misspelt rule":       `{"policies": [{"name": "a", "max_token": 10}]}`,
+		"bad pattern":         `{"policies": [{"name": "a", "allowed_tools": ["get_["]}]}`,
+		"negative cap":        `{"policies": [{"name": "a", "max_tokens": -1}]}`,
+		"inverted range":      `{"policies": [{"name": "a", "temperature": {"min": 1, "max": 0.5}}]}`,
+		"not a policies file": `[]`,
+	} {
+		path := filepath.Join(t.TempDir(), "policies.json")
+		os.WriteFile(path, []byte(body), 0o600)
+		if _, err := adapters.LoadRequestPolicies(path); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
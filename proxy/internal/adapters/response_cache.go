@@ -0,0 +1,100 @@
+package adapters
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+type ResponseCacheConfig struct {
+	MaxEntries int
+	MaxBytes   int // Total body bytes held, 0 means no limit
+	TTL        time.Duration
+}
+
+// ResponseCache is an in-memory LRU of upstream responses. Entries expire
+// after the TTL and the least recently used ones are evicted once either the
+// entry or the byte budget is exceeded.
+type ResponseCache struct {
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	size    int
+	lru     *list.List // Front is the most recently used entry
+	entries map[string]*list.Element
+}
+
+type responseEntry struct {
+	key       string
+	response  *domain.CachedResponse
+	size      int
+	expiresAt time.Time
+}
+
+func NewResponseCache(config ResponseCacheConfig) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: config.MaxEntries,
+		maxBytes:   config.MaxBytes,
+		ttl:        config.TTL,
+		now:        time.Now,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *ResponseCache) Get(key string) (*domain.CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*responseEntry)
+	if c.ttl > 0 && c.now().After(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	// Chunks are never written after Put, so sharing them is safe
+	return entry.response, true
+}
+
+func (c *ResponseCache) Put(key string, response *domain.CachedResponse) {
+	size := response.Size()
+	if c.maxEntries <= 0 || (c.maxBytes > 0 && size > c.maxBytes) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	entry := &responseEntry{key: key, response: response, size: size, expiresAt: c.now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+
+	for c.lru.Len() > c.maxEntries || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *ResponseCache) remove(elem *list.Element) {
+	entry := elem.Value.(*responseEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
@@ -0,0 +1,58 @@
+package adapters_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func cachedResponse(chunks ...string) *domain.CachedResponse {
+	response := &domain.CachedResponse{}
+	for _, c := range chunks {
+		response.Chunks = append(response.Chunks, []byte(c))
+	}
+	return response
+}
+
+func TestResponseCache_Bounds(t *testing.T) {
+	cache := adapters.NewResponseCache(adapters.ResponseCacheConfig{MaxEntries: 2, MaxBytes: 10, TTL: time.Minute})
+
+	cache.Put("a", cachedResponse("aaaa"))
+	cache.Put("b", cachedResponse("bb", "bb"))
+	cache.Get("a") // a is now the most recently used
+
+	// The byte budget evicts b, the least recently used entry
+	cache.Put("c", cachedResponse("cccc"))
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected b to be evicted by the byte budget")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Expected a to survive")
+	}
+
+	// The entry budget evicts the oldest one
+	cache.Put("d", cachedResponse("d"))
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+
+	// Responses larger than the whole budget are never stored
+	cache.Put("huge", cachedResponse("0123456789abc"))
+	if _, ok := cache.Get("huge"); ok {
+		t.Error("Expected an oversized response to be skipped")
+	}
+}
+
+func TestResponseCache_TTL(t *testing.T) {
+	cache := adapters.NewResponseCache(adapters.ResponseCacheConfig{MaxEntries: 10, TTL: 20 * time.Millisecond})
+	cache.Put("a", cachedResponse("data"))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("Expected a fresh entry to be served")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expected the entry to expire")
+	}
+}
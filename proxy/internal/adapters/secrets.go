@@ -0,0 +1,134 @@
+package adapters
+
+import (
+	"math"
+	"regexp"
+	"sort"
+)
+
+// secretFormat is a credential with a recognisable shape. When the pattern
+// has a "secret" group only that part is flagged, e.g. the value of
+// password=... rather than the whole assignment.
+type secretFormat struct {
+	category string
+	pattern  *regexp.Regexp
+}
+
+var secretFormats = []secretFormat{
+	{"PRIVATE_KEY", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?(?:-----END (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----|$)`)},
+	{"AWS_ACCESS_KEY", regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{"AWS_SECRET_KEY", regexp.MustCompile(`(?i)\baws_?secret_?(?:access_?)?key\b["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+]{40})\b`)},
+	{"GITHUB_TOKEN", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{"SLACK_TOKEN", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}|https://hooks\.slack\.com/services/[A-Za-z0-9/_]+`)},
+	{"GOOGLE_API_KEY", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}`)},
+	{"STRIPE_KEY", regexp.MustCompile(`\b(?:sk|rk)_(?:live|test)_[0-9A-Za-z]{24,}\b`)},
+	{"API_KEY", regexp.MustCompile(`\bsk-(?:proj-|ant-)?[A-Za-z0-9_\-]{20,}`)},
+	{"JWT", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	// URLs with inline credentials and ADO.NET-style strings with a password
+	{"CONNECTION_STRING", regexp.MustCompile(`\b(?:postgres(?:ql)?|mysql|mariadb|mongodb(?:\+srv)?|rediss?|amqps?|mssql|sqlserver|jdbc:[a-z]+)://[^\s:@/]+:[^\s@/]+@[^\s"'<>]+`)},
+	{"CONNECTION_STRING", regexp.MustCompile(`(?i)\b(?:server|data source|host)=[^;\n]+;[^\n]*?\b(?:password|pwd)=[^;\s"']+`)},
+	{"GENERIC_SECRET", regexp.MustCompile(`(?i)(?:secret|token|api[_-]?key|access[_-]?key|passw(?:or)?d|pwd)\b["']?\s*[:=]\s*["']?(?P<secret>[^\s"',;]{8,})`)},
+}
+
+var (
+	// Long runs of key-like characters, candidates for the entropy check
+	entropyCandidate = regexp.MustCompile(`[A-Za-z0-9+/_\-]{24,}={0,2}`)
+	hexOnly          = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// Default entropy thresholds in bits per character. Hex can carry at most 4
+// bits per character, so it needs a lower bar than base64-like strings.
+const (
+	defaultMinEntropy    = 4.0
+	defaultMinHexEntropy = 3.0
+)
+
+// secretDetector finds credentials pasted into prompts: well-known token
+// formats first, then any remaining random-looking string whose Shannon
+// entropy suggests a key rather than a word.
+type secretDetector struct {
+	name       string
+	minEntropy float64
+}
+
+func newSecretDetector(name string, minEntropy float64) Detector {
+	if minEntropy == 0 {
+		minEntropy = defaultMinEntropy
+	}
+	return &secretDetector{name: name, minEntropy: minEntropy}
+}
+
+func (d *secretDetector) Name() string { return d.name }
+
+func (d *secretDetector) Detect(text string) []Match {
+	var matches []Match
+	for _, format := range secretFormats {
+		group := format.pattern.SubexpIndex("secret")
+		for _, loc := range format.pattern.FindAllStringSubmatchIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			if group > 0 && loc[2*group] >= 0 {
+				start, end = loc[2*group], loc[2*group+1]
+			}
+			if !overlaps(matches, start, end) {
+				matches = append(matches, Match{Start: start, End: end, Detector: d.name, Category: format.category})
+			}
+		}
+	}
+
+	for _, loc := range entropyCandidate.FindAllStringIndex(text, -1) {
+		if overlaps(matches, loc[0], loc[1]) {
+			continue
+		}
+		if d.looksRandom(text[loc[0]:loc[1]]) {
+			matches = append(matches, Match{Start: loc[0], End: loc[1], Detector: d.name, Category: "HIGH_ENTROPY_STRING"})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Start < matches[j].Start })
+	return matches
+}
+
+// looksRandom tells keys apart from long identifiers such as
+// "internationalization_settings": keys mix letters and digits and use
+// their alphabet evenly.
+func (d *secretDetector) looksRandom(s string) bool {
+	var letters, digits bool
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters = true
+		}
+	}
+	if !letters || !digits {
+		return false
+	}
+	if hexOnly.MatchString(s) {
+		return len(s) >= 32 && shannonEntropy(s) >= defaultMinHexEntropy
+	}
+	return shannonEntropy(s) >= d.minEntropy
+}
+
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	entropy := 0.0
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func overlaps(matches []Match, start, end int) bool {
+	for _, m := range matches {
+		if start < m.End && m.Start < end {
+			return true
+		}
+	}
+	return false
+}
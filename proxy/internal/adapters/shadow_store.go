@@ -0,0 +1,54 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// ShadowFileStore appends shadow records to a JSONL file, one per line.
+type ShadowFileStore struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewShadowFileStore(path string) (*ShadowFileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shadow log: %w", err)
+	}
+	return &ShadowFileStore{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (s *ShadowFileStore) Save(ctx context.Context, record *domain.ShadowRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(record)
+}
+
+// Close syncs the records written so far to disk and closes the file.
+func (s *ShadowFileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.file.Sync(), s.file.Close())
+}
+
+// LoadModelPricing reads a JSON object of model names to prices in USD per
+// million tokens, e.g. {"gemini-2.5-flash": {"input": 0.3, "output": 2.5}}.
+func LoadModelPricing(path string) (map[string]domain.ModelPrice, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model pricing: %w", err)
+	}
+	var pricing map[string]domain.ModelPrice
+	if err := json.Unmarshal(data, &pricing); err != nil {
+		return nil, fmt.Errorf("failed to parse model pricing: %w", err)
+	}
+	return pricing, nil
+}
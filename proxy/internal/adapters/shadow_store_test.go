@@ -0,0 +1,52 @@
+package adapters_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func TestShadowFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow.jsonl")
+	store, err := adapters.NewShadowFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		record := &domain.ShadowRecord{RequestID: id, Shadow: domain.ShadowResult{Model: "candidate"}}
+		if err := store.Save(context.Background(), record); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	store.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per record, got %d", len(lines))
+	}
+	var record domain.ShadowRecord
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil || record.RequestID != "b" || record.Shadow.Model != "candidate" {
+		t.Errorf("Unexpected record %s (%v)", lines[1], err)
+	}
+}
+
+func TestLoadModelPricing(t *testing.T) {
+	pricing, err := adapters.LoadModelPricing("../../config/pricing.example.json")
+	if err != nil {
+		t.Fatalf("Failed to load the example pricing: %v", err)
+	}
+	price, ok := pricing["gemini-2.5-flash"]
+	if !ok || price.Cost(domain.Usage{PromptTokens: 1_000_000, CompletionTokens: 1_000_000}) != 2.8 {
+		t.Errorf("Unexpected price %+v", price)
+	}
+}
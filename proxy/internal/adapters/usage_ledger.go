@@ -0,0 +1,215 @@
+package adapters
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// UsageLedger totals usage in memory by the hour, key, team, endpoint,
+// model and provider, which every report and summary is built from. With a
+// log file, records are also appended to it and the totals survive restarts.
+type UsageLedger struct {
+	mu     sync.Mutex
+	totals map[usageKey]*domain.UsageSummary
+	file   *os.File
+	enc    *json.Encoder
+}
+
+type usageKey struct {
+	hour     int64 // Unix time of the hour's start
+	keyID    string
+	team     string
+	endpoint domain.Endpoint
+	model    string
+	provider string
+}
+
+type UsageLedgerConfig struct {
+	// Path of the JSONL log of usage records, empty to keep totals in
+	// memory only
+	Path string
+}
+
+func NewUsageLedger(config UsageLedgerConfig) (*UsageLedger, error) {
+	l := &UsageLedger{totals: make(map[usageKey]*domain.UsageSummary)}
+	if config.Path == "" {
+		return l, nil
+	}
+	if err := l.load(config.Path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage log: %w", err)
+	}
+	l.file, l.enc = file, json.NewEncoder(file)
+	return l, nil
+}
+
+// load replays the records of an earlier run.
+func (l *UsageLedger) load(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read usage log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	skipped := 0
+	for scanner.Scan() {
+		var record domain.UsageRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			skipped++ // A line cut short by a crash
+			continue
+		}
+		l.add(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read usage log: %w", err)
+	}
+	if skipped > 0 {
+		log.Printf("Usage log %s: %d unreadable records skipped", path, skipped)
+	}
+	return nil
+}
+
+func (l *UsageLedger) Record(record domain.UsageRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(record)
+	if l.enc != nil {
+		if err := l.enc.Encode(record); err != nil {
+			log.Printf("Usage of request %s not logged: %v", record.RequestID, err)
+		}
+	}
+}
+
+func (l *UsageLedger) add(record domain.UsageRecord) {
+	key := usageKey{
+		hour:     record.Time.Truncate(time.Hour).Unix(),
+		keyID:    record.KeyID,
+		team:     record.Team,
+		endpoint: record.Endpoint,
+		model:    record.Model,
+		provider: record.Provider,
+	}
+	total, ok := l.totals[key]
+	if !ok {
+		total = &domain.UsageSummary{}
+		l.totals[key] = total
+	}
+	total.Requests++
+	if record.Failed {
+		total.Errors++
+	}
+	total.PromptTokens += record.Usage.PromptTokens
+	total.CompletionTokens += record.Usage.CompletionTokens
+	total.TotalTokens += record.Usage.TotalTokens
+	total.CostUSD += record.CostUSD
+}
+
+func (l *UsageLedger) Summary() []domain.UsageSummary {
+	summary, _ := l.Report(domain.UsageQuery{GroupBy: []string{"endpoint", "model"}})
+	return summary
+}
+
+// Report totals the hours from query.From (rounded down to the hour) to
+// query.To. A zero bound leaves that side of the range open.
+func (l *UsageLedger) Report(query domain.UsageQuery) ([]domain.UsageSummary, error) {
+	for _, dim := range query.GroupBy {
+		if !slices.Contains(domain.UsageDimensions, dim) {
+			return nil, fmt.Errorf("can't group usage by %q", dim)
+		}
+	}
+	if query.Interval != "" && query.Interval != "hour" && query.Interval != "day" {
+		return nil, fmt.Errorf("interval must be hour or day, got %q", query.Interval)
+	}
+	from := query.From.Truncate(time.Hour)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	groups := make(map[domain.UsageSummary]*domain.UsageSummary)
+	for key, total := range l.totals {
+		hour := time.Unix(key.hour, 0).UTC()
+		if (!query.From.IsZero() && hour.Before(from)) || (!query.To.IsZero() && !hour.Before(query.To)) {
+			continue
+		}
+		group := key.group(query.GroupBy)
+		switch query.Interval {
+		case "hour":
+			group.Period = hour
+		case "day":
+			group.Period = time.Date(hour.Year(), hour.Month(), hour.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		sum, ok := groups[group]
+		if !ok {
+			sum = &group
+			groups[group] = sum
+		}
+		sum.Requests += total.Requests
+		sum.Errors += total.Errors
+		sum.PromptTokens += total.PromptTokens
+		sum.CompletionTokens += total.CompletionTokens
+		sum.TotalTokens += total.TotalTokens
+		sum.CostUSD += total.CostUSD
+	}
+
+	report := make([]domain.UsageSummary, 0, len(groups))
+	for _, sum := range groups {
+		report = append(report, *sum)
+	}
+	slices.SortFunc(report, func(a, b domain.UsageSummary) int {
+		return cmp.Or(
+			a.Period.Compare(b.Period),
+			cmp.Compare(a.KeyID, b.KeyID),
+			cmp.Compare(a.Team, b.Team),
+			cmp.Compare(a.Endpoint, b.Endpoint),
+			cmp.Compare(a.Model, b.Model),
+			cmp.Compare(a.Provider, b.Provider),
+		)
+	})
+	return report, nil
+}
+
+// group is the summary of the dimensions asked for, with no totals yet.
+func (k usageKey) group(dims []string) domain.UsageSummary {
+	var group domain.UsageSummary
+	for _, dim := range dims {
+		switch dim {
+		case "key":
+			group.KeyID = k.keyID
+		case "team":
+			group.Team = k.team
+		case "model":
+			group.Model = k.model
+		case "provider":
+			group.Provider = k.provider
+		case "endpoint":
+			group.Endpoint = k.endpoint
+		}
+	}
+	return group
+}
+
+func (l *UsageLedger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,85 @@
+package adapters_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+func TestUsageLedger(t *testing.T) {
+	ledger, err := adapters.NewUsageLedger(adapters.UsageLedgerConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ledger.Record(domain.UsageRecord{Endpoint: domain.EndpointEmbeddings, Model: "e", Usage: domain.Usage{PromptTokens: 5, TotalTokens: 5}, CostUSD: 0.1})
+	ledger.Record(domain.UsageRecord{Endpoint: domain.EndpointChat, Model: "m", Usage: domain.Usage{PromptTokens: 2, CompletionTokens: 3, TotalTokens: 5}})
+	ledger.Record(domain.UsageRecord{Endpoint: domain.EndpointEmbeddings, Model: "e", Usage: domain.Usage{PromptTokens: 4, TotalTokens: 4}, CostUSD: 0.2})
+	ledger.Record(domain.UsageRecord{Endpoint: domain.EndpointEmbeddings, Model: "e", Failed: true})
+
+	summary := ledger.Summary()
+	if len(summary) != 2 {
+		t.Fatalf("Expected a line per endpoint and model, got %+v", summary)
+	}
+	if chat := summary[0]; chat.Endpoint != domain.EndpointChat || chat.Requests != 1 || chat.CompletionTokens != 3 {
+		t.Errorf("Unexpected chat usage %+v", chat)
+	}
+	embeddings := summary[1]
+	if embeddings.Requests != 3 || embeddings.Errors != 1 || embeddings.TotalTokens != 9 || embeddings.CostUSD < 0.3-1e-9 || embeddings.CostUSD > 0.3+1e-9 {
+		t.Errorf("Unexpected embeddings usage %+v", embeddings)
+	}
+}
+
+func TestUsageLedger_Report(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	ledger, err := adapters.NewUsageLedger(adapters.UsageLedgerConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	day := time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)
+	record := func(at time.Duration, key, team, model string, tokens int, cost float64) {
+		ledger.Record(domain.UsageRecord{
+			Time: day.Add(at), KeyID: key, Team: team, Endpoint: domain.EndpointChat, Model: model, Provider: "default",
+			Usage: domain.Usage{TotalTokens: tokens}, CostUSD: cost,
+		})
+	}
+	record(9*time.Hour+10*time.Minute, "vk_a", "research", "flash", 100, 1)
+	record(9*time.Hour+50*time.Minute, "vk_a", "research", "pro", 10, 2)
+	record(15*time.Hour, "vk_b", "research", "flash", 200, 4)
+	record(24*time.Hour+time.Hour, "vk_b", "platform", "flash", 1000, 8) // The next day
+	ledger.Close()
+
+	// Totals come back from the log
+	reloaded, err := adapters.NewUsageLedger(adapters.UsageLedgerConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer reloaded.Close()
+
+	byTeam, err := reloaded.Report(domain.UsageQuery{From: day, To: day.Add(24 * time.Hour), GroupBy: []string{"team"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(byTeam) != 1 || byTeam[0].Team != "research" || byTeam[0].Requests != 3 || byTeam[0].TotalTokens != 310 || byTeam[0].CostUSD != 7 || byTeam[0].Model != "" {
+		t.Errorf("Expected the first day of research only, got %+v", byTeam)
+	}
+
+	hourly, _ := reloaded.Report(domain.UsageQuery{From: day.Add(9*time.Hour + 30*time.Minute), GroupBy: []string{"key", "model"}, Interval: "hour"})
+	if len(hourly) != 4 || !hourly[0].Period.Equal(day.Add(9*time.Hour)) || hourly[0].Model != "flash" || hourly[1].Model != "pro" {
+		t.Errorf("Expected the range to start at 9:00, by hour, key and model, got %+v", hourly)
+	}
+
+	daily, _ := reloaded.Report(domain.UsageQuery{GroupBy: []string{"provider"}, Interval: "day"})
+	if len(daily) != 2 || daily[0].Requests != 3 || daily[1].CostUSD != 8 || daily[1].Provider != "default" {
+		t.Errorf("Expected a line per day, got %+v", daily)
+	}
+
+	if _, err := reloaded.Report(domain.UsageQuery{GroupBy: []string{"owner"}}); err == nil {
+		t.Error("Expected an unknown dimension to be rejected")
+	}
+	if _, err := reloaded.Report(domain.UsageQuery{Interval: "week"}); err == nil {
+		t.Error("Expected an unknown interval to be rejected")
+	}
+}
@@ -0,0 +1,115 @@
+package adapters
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+type VectorIndexConfig struct {
+	MaxEntriesPerScope int
+	TTL                time.Duration
+}
+
+// VectorIndex is an in-process, brute-force cosine index. Each scope holds
+// a bounded number of entries, so a search costs at most MaxEntriesPerScope
+// dot products and tenants can't crowd each other out.
+type VectorIndex struct {
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu     sync.RWMutex
+	scopes map[string][]vectorEntry // Oldest first
+}
+
+type vectorEntry struct {
+	vector    []float32 // Unit length
+	response  *domain.CachedResponse
+	expiresAt time.Time
+}
+
+func NewVectorIndex(config VectorIndexConfig) *VectorIndex {
+	return &VectorIndex{
+		maxEntries: config.MaxEntriesPerScope,
+		ttl:        config.TTL,
+		now:        time.Now,
+		scopes:     make(map[string][]vectorEntry),
+	}
+}
+
+func (x *VectorIndex) Search(scope string, vector []float32, minSimilarity float64) (*domain.CachedResponse, float64, bool) {
+	query := normalize(vector)
+	if query == nil {
+		return nil, 0, false
+	}
+	now := x.now()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var (
+		best           *domain.CachedResponse
+		bestSimilarity = minSimilarity
+	)
+	for _, entry := range x.scopes[scope] {
+		if x.ttl > 0 && now.After(entry.expiresAt) {
+			continue
+		}
+		if len(entry.vector) != len(query) {
+			continue // The embeddings model changed
+		}
+		var dot float64
+		for i, v := range entry.vector {
+			dot += float64(v) * float64(query[i])
+		}
+		if dot >= bestSimilarity {
+			best, bestSimilarity = entry.response, dot
+		}
+	}
+	return best, bestSimilarity, best != nil
+}
+
+func (x *VectorIndex) Add(scope string, vector []float32, response *domain.CachedResponse) {
+	unit := normalize(vector)
+	if unit == nil || x.maxEntries <= 0 {
+		return
+	}
+	now := x.now()
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// Drop expired entries first, then the oldest ones over the limit
+	entries := x.scopes[scope][:0]
+	for _, entry := range x.scopes[scope] {
+		if x.ttl <= 0 || now.Before(entry.expiresAt) {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, vectorEntry{vector: unit, response: response, expiresAt: now.Add(x.ttl)})
+	if len(entries) > x.maxEntries {
+		entries = entries[len(entries)-x.maxEntries:]
+	}
+	x.scopes[scope] = entries
+}
+
+// normalize returns a unit length copy of v, so cosine similarity becomes a
+// dot product. Zero vectors can't be compared and return nil.
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, f := range v {
+		norm += float64(f) * float64(f)
+	}
+	if norm == 0 {
+		return nil
+	}
+	norm = math.Sqrt(norm)
+	unit := make([]float32, len(v))
+	for i, f := range v {
+		unit[i] = float32(float64(f) / norm)
+	}
+	return unit
+}
@@ -0,0 +1,44 @@
+package adapters_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+)
+
+func TestVectorIndex_Search(t *testing.T) {
+	index := adapters.NewVectorIndex(adapters.VectorIndexConfig{MaxEntriesPerScope: 2, TTL: time.Minute})
+	refund := cachedResponse("refund policy")
+	shipping := cachedResponse("shipping times")
+	index.Add("team:a", []float32{1, 0, 0}, refund)
+	index.Add("team:a", []float32{0, 1, 0}, shipping)
+
+	// Length doesn't matter, only direction
+	got, similarity, ok := index.Search("team:a", []float32{2, 0.2, 0}, 0.95)
+	if !ok || got != refund {
+		t.Fatalf("Expected the refund answer, got %v (similarity %.3f)", got, similarity)
+	}
+	if _, _, ok := index.Search("team:a", []float32{1, 1, 0}, 0.95); ok {
+		t.Error("Expected no hit below the threshold")
+	}
+	// Tenants never see each other's entries
+	if _, _, ok := index.Search("team:b", []float32{1, 0, 0}, 0.5); ok {
+		t.Error("Expected scopes to be isolated")
+	}
+
+	// The oldest entry goes once the scope is full
+	index.Add("team:a", []float32{0, 0, 1}, cachedResponse("opening hours"))
+	if _, _, ok := index.Search("team:a", []float32{1, 0, 0}, 0.95); ok {
+		t.Error("Expected the oldest entry to be evicted")
+	}
+}
+
+func TestVectorIndex_TTL(t *testing.T) {
+	index := adapters.NewVectorIndex(adapters.VectorIndexConfig{MaxEntriesPerScope: 10, TTL: 20 * time.Millisecond})
+	index.Add("key:a", []float32{1, 0}, cachedResponse("answer"))
+	time.Sleep(30 * time.Millisecond)
+	if _, _, ok := index.Search("key:a", []float32{1, 0}, 0.9); ok {
+		t.Error("Expected the entry to expire")
+	}
+}
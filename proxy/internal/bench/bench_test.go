@@ -0,0 +1,85 @@
+package bench_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/bench"
+)
+
+func TestRun(t *testing.T) {
+	results, err := bench.Run(context.Background(), bench.Config{
+		StackConfig: bench.StackConfig{Chunks: 3},
+		Concurrency: []int{1, 4},
+		Requests:    40,
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected a result per concurrency, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Errors != 0 {
+			t.Errorf("Concurrency %d: %d requests failed", r.Concurrency, r.Errors)
+		}
+		if r.Proxied.P50 <= 0 || r.Direct.P50 <= 0 || r.Throughput <= 0 {
+			t.Errorf("Concurrency %d: missing measurements: %+v", r.Concurrency, r)
+		}
+	}
+
+	var out bytes.Buffer
+	bench.Print(&out, results)
+	if !strings.Contains(out.String(), "added p50") || strings.Count(out.String(), "\n") != 3 {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
+
+func newStack(b *testing.B, config bench.StackConfig) *bench.Stack {
+	stack, err := bench.NewStack(config)
+	if err != nil {
+		b.Fatalf("Failed to start stack: %v", err)
+	}
+	b.Cleanup(stack.Close)
+	return stack
+}
+
+// BenchmarkHandleProxy calls the handler directly, so allocations are those
+// of HandleProxy, the service and the guardrail and LLM adapters.
+func BenchmarkHandleProxy(b *testing.B) {
+	for _, guardrail := range []struct{ name, url string }{
+		{"remote", ""},
+		{"native", "native://"},
+	} {
+		b.Run(guardrail.name, func(b *testing.B) {
+			stack := newStack(b, bench.StackConfig{Chunks: 20, GuardrailURL: guardrail.url})
+			b.ReportAllocs()
+			for b.Loop() {
+				req := httptest.NewRequest("POST", "/chat/completions", strings.NewReader(bench.Payload))
+				rec := httptest.NewRecorder()
+				stack.Handler.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("Unexpected status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProxy measures end to end requests over loopback, concurrently.
+func BenchmarkProxy(b *testing.B) {
+	stack := newStack(b, bench.StackConfig{Chunks: 20})
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := bench.Do(client, stack.ProxyURL); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// Config describes a benchmark run.
+type Config struct {
+	StackConfig
+	// Concurrency lists the numbers of concurrent streams to measure
+	Concurrency []int
+	// Requests per measurement, after a short warm-up
+	Requests int
+}
+
+// Result compares requests through the proxy with the same requests sent
+// straight to the fake LLM, at one concurrency.
+type Result struct {
+	Concurrency int
+	Direct      Percentiles
+	Proxied     Percentiles
+	// Throughput of proxied requests, per second
+	Throughput float64
+	// AllocsPerRequest and BytesPerRequest are what the proxy path
+	// allocates on top of the direct one. They include the fake sidecar,
+	// which is small next to the proxy.
+	AllocsPerRequest float64
+	BytesPerRequest  float64
+	Errors           int
+}
+
+// Percentiles of request latency.
+type Percentiles struct {
+	P50, P90, P99 time.Duration
+}
+
+// Added is the latency the proxy adds at each percentile.
+func (r Result) Added() Percentiles {
+	return Percentiles{
+		P50: r.Proxied.P50 - r.Direct.P50,
+		P90: r.Proxied.P90 - r.Direct.P90,
+		P99: r.Proxied.P99 - r.Direct.P99,
+	}
+}
+
+// Run measures every configured concurrency on a fresh stack.
+func Run(ctx context.Context, config Config) ([]Result, error) {
+	if config.Requests < 1 {
+		config.Requests = 1000
+	}
+	var results []Result
+	for _, concurrency := range config.Concurrency {
+		stackConfig := config.StackConfig
+		stackConfig.MaxConcurrency = max(stackConfig.MaxConcurrency, concurrency)
+		stack, err := NewStack(stackConfig)
+		if err != nil {
+			return results, err
+		}
+		result, err := measure(ctx, stack, concurrency, config.Requests)
+		stack.Close()
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func measure(ctx context.Context, stack *Stack, concurrency, requests int) (Result, error) {
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency}}
+	defer client.CloseIdleConnections()
+
+	// Open connections and fill caches before anything is counted
+	warmup := min(requests, max(concurrency, 20))
+	if _, err := load(ctx, client, stack.UpstreamURL, concurrency, warmup); err != nil {
+		return Result{}, err
+	}
+	if _, err := load(ctx, client, stack.ProxyURL, concurrency, warmup); err != nil {
+		return Result{}, err
+	}
+
+	direct, err := load(ctx, client, stack.UpstreamURL, concurrency, requests)
+	if err != nil {
+		return Result{}, err
+	}
+	proxied, err := load(ctx, client, stack.ProxyURL, concurrency, requests)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Concurrency:      concurrency,
+		Direct:           percentiles(direct.latencies),
+		Proxied:          percentiles(proxied.latencies),
+		Throughput:       float64(requests) / proxied.duration.Seconds(),
+		AllocsPerRequest: float64(int64(proxied.mallocs)-int64(direct.mallocs)) / float64(requests),
+		BytesPerRequest:  float64(int64(proxied.bytes)-int64(direct.bytes)) / float64(requests),
+		Errors:           direct.errors + proxied.errors,
+	}, nil
+}
+
+type loadResult struct {
+	latencies      []time.Duration
+	duration       time.Duration
+	mallocs, bytes uint64
+	errors         int
+}
+
+// load sends requests to url from concurrency workers.
+func load(ctx context.Context, client *http.Client, url string, concurrency, requests int) (loadResult, error) {
+	result := loadResult{latencies: make([]time.Duration, requests)}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				begin := time.Now()
+				err := Do(client, url)
+				result.latencies[i] = time.Since(begin)
+				if err != nil {
+					mu.Lock()
+					result.errors++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range requests {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.mallocs = after.Mallocs - before.Mallocs
+	result.bytes = after.TotalAlloc - before.TotalAlloc
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if result.errors == requests {
+		return result, fmt.Errorf("every request to %s failed", url)
+	}
+	return result, nil
+}
+
+func percentiles(values []time.Duration) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return Percentiles{
+		P50: domain.Percentile(sorted, 0.50),
+		P90: domain.Percentile(sorted, 0.90),
+		P99: domain.Percentile(sorted, 0.99),
+	}
+}
+
+// Print writes results as a table.
+func Print(w io.Writer, results []Result) {
+	fmt.Fprintf(w, "%-7s %10s %10s %10s %10s %10s %12s %12s %8s\n",
+		"streams", "direct p50", "added p50", "added p90", "added p99", "req/s", "allocs/req", "bytes/req", "errors")
+	for _, r := range results {
+		added := r.Added()
+		fmt.Fprintf(w, "%-7d %10s %10s %10s %10s %10.0f %12.0f %12.0f %8d\n",
+			r.Concurrency, round(r.Direct.P50), round(added.P50), round(added.P90), round(added.P99),
+			r.Throughput, r.AllocsPerRequest, r.BytesPerRequest, r.Errors)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
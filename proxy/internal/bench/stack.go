@@ -0,0 +1,144 @@
+// Package bench measures the overhead the proxy adds to an LLM call. It runs
+// the real handler, service and adapters against in-process fake guardrail
+// and LLM servers, so the only variable is the proxy itself.
+package bench
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/adapters"
+	"github.com/simone-trubian/baldr/proxy/internal/core"
+	"github.com/simone-trubian/baldr/proxy/internal/handlers"
+)
+
+// Payload is the request sent through the stack.
+const Payload = `{"model": "gemini-2.5-flash", "stream": true, "messages": [{"role": "user", "content": "Explain who is Baldr in a sentence."}]}`
+
+// StackConfig shapes the fake servers.
+type StackConfig struct {
+	// Chunks is the number of SSE events the fake LLM streams per response
+	Chunks int
+	// ChunkDelay is the pause between events, 0 streams them back to back
+	ChunkDelay time.Duration
+	// GuardrailURL replaces the fake sidecar, e.g. native:// to measure the
+	// in-process engine. Empty uses the fake sidecar over HTTP.
+	GuardrailURL string
+	// MaxConcurrency of the guardrail adapter
+	MaxConcurrency int
+}
+
+// Stack is a proxy wired to fake upstreams.
+type Stack struct {
+	// Handler is the proxy handler, for benchmarks that skip the network
+	Handler http.Handler
+	// ProxyURL and UpstreamURL are the chat completion endpoints of the
+	// proxy and of the fake LLM it forwards to
+	ProxyURL    string
+	UpstreamURL string
+
+	servers []*httptest.Server
+}
+
+// NewStack starts the fake servers and a proxy in front of them.
+func NewStack(config StackConfig) (*Stack, error) {
+	if config.Chunks < 1 {
+		config.Chunks = 1
+	}
+	if config.MaxConcurrency < 1 {
+		config.MaxConcurrency = 50
+	}
+	s := &Stack{}
+
+	upstream := httptest.NewServer(fakeLLM(config.Chunks, config.ChunkDelay))
+	s.servers = append(s.servers, upstream)
+	s.UpstreamURL = upstream.URL + "/chat/completions"
+
+	guardrailURL := config.GuardrailURL
+	if guardrailURL == "" {
+		sidecar := httptest.NewServer(fakeSidecar())
+		s.servers = append(s.servers, sidecar)
+		guardrailURL = sidecar.URL + "/validate"
+	}
+	guardrail, err := adapters.NewGuardrail(adapters.GuardrailConfig{
+		BaseURL:        guardrailURL,
+		Timeout:        5 * time.Second,
+		MaxConcurrency: config.MaxConcurrency,
+	})
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to create guardrail: %w", err)
+	}
+
+	service := core.NewBaldrService(guardrail, adapters.NewLLM(adapters.LLMConfig{BaseURL: s.UpstreamURL}))
+	handler := handlers.NewHTTPHandler(service)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", handler.HandleProxy)
+	s.Handler = mux
+
+	proxy := httptest.NewServer(mux)
+	s.servers = append(s.servers, proxy)
+	s.ProxyURL = proxy.URL + "/chat/completions"
+	return s, nil
+}
+
+// Close stops the servers.
+func (s *Stack) Close() {
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+}
+
+// fakeSidecar allows everything, speaking the current contract version.
+func fakeSidecar() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"versions": ["1", "2", "3"]}`))
+	})
+	mux.HandleFunc("POST /validate", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version": "3", "allowed": true, "action": "allow"}`))
+	})
+	return mux
+}
+
+// fakeLLM streams a fixed completion as SSE events.
+func fakeLLM(chunks int, delay time.Duration) http.Handler {
+	event := []byte(`data: {"id":"chatcmpl-bench","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Baldr "}}]}` + "\n\n")
+	done := []byte(`data: {"id":"chatcmpl-bench","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\ndata: [DONE]\n\n")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for range chunks {
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			w.Write(event)
+			flusher.Flush()
+		}
+		w.Write(done)
+	})
+}
+
+// Do sends one request to url and reads the whole response.
+func Do(client *http.Client, url string) error {
+	resp, err := client.Post(url, "application/json", strings.NewReader(Payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
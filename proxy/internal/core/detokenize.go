@@ -0,0 +1,376 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// detokenizer restores the values behind guardrail placeholders in the
+// upstream response. SSE streams are rewritten event by event: a placeholder
+// the model emits across several deltas ("<EMA", "IL_1>") of a chat choice or
+// of a Responses API output part is held back until it is complete, so
+// neither half leaks to the client as garbage. Plain JSON responses are
+// rewritten in one go.
+type detokenizer struct {
+	source io.ReadCloser
+	reader *bufio.Reader
+	tokens map[string]string
+	// Sorted placeholders, to tell a partial placeholder from a stray "<"
+	placeholders []string
+
+	sniffed   bool
+	sse       bool
+	pending   []byte                // Rewritten output not yet handed to the caller
+	event     []byte                // The "event:" line of the event being read
+	carry     map[int]string        // Held back text per choice index
+	partCarry map[outputPart]string // Held back text per Responses API output part
+	done      bool
+}
+
+// outputPart is where a Responses API text delta goes.
+type outputPart struct {
+	ItemID       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+}
+
+func newDetokenizer(source io.ReadCloser, tokens map[string]string) *detokenizer {
+	placeholders := make([]string, 0, len(tokens))
+	for p := range tokens {
+		placeholders = append(placeholders, p)
+	}
+	sort.Strings(placeholders)
+
+	return &detokenizer{
+		source:       source,
+		reader:       bufio.NewReader(source),
+		tokens:       tokens,
+		placeholders: placeholders,
+		carry:        make(map[int]string),
+		partCarry:    make(map[outputPart]string),
+	}
+}
+
+func (d *detokenizer) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *detokenizer) Close() error {
+	return d.source.Close()
+}
+
+// fill rewrites the next line of an SSE stream, or the whole body otherwise.
+func (d *detokenizer) fill() error {
+	if !d.sniffed {
+		d.sniffed = true
+		d.sse = !startsJSON(d.reader)
+	}
+
+	if !d.sse {
+		body, err := io.ReadAll(d.reader)
+		if err != nil {
+			return err
+		}
+		d.pending = d.restoreJSON(body)
+		d.done = true
+		return nil
+	}
+
+	line, err := d.reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err == io.EOF {
+		d.done = true
+		d.pending = append(append(d.flushCarry(), d.event...), line...)
+		return nil
+	}
+
+	if bytes.HasPrefix(line, []byte("event:")) {
+		// Held until its data, held back text may have to go out first
+		d.event = append(d.event, line...)
+		return nil
+	}
+	event := d.event
+	d.event = nil
+
+	data, isData := bytes.CutPrefix(line, []byte("data:"))
+	switch {
+	case !isData:
+		d.pending = append(event, line...)
+	case string(bytes.TrimSpace(data)) == "[DONE]":
+		// Whatever is still held back can no longer become a placeholder
+		d.pending = append(append(d.flushCarry(), event...), line...)
+	default:
+		d.pending = d.restoreEvent(event, line, data)
+	}
+	return nil
+}
+
+// startsJSON reports whether the body is a JSON object, looking past the
+// whitespace before it.
+func startsJSON(r *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		peeked, _ := r.Peek(n)
+		if len(peeked) < n {
+			return false
+		}
+		switch peeked[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return peeked[n-1] == '{'
+	}
+}
+
+// restoreJSON replaces placeholders inside the string values of a complete
+// JSON body. Placeholders are plain ASCII, so they can be swapped for the
+// JSON-escaped originals without decoding the body. Some encoders escape the
+// angle brackets, both spellings are restored.
+func (d *detokenizer) restoreJSON(body []byte) []byte {
+	for _, placeholder := range d.placeholders {
+		original := marshalJSON(d.tokens[placeholder])
+		original = original[1 : len(original)-1]
+		escaped := `\u003c` + placeholder[1:len(placeholder)-1] + `\u003e`
+		body = bytes.ReplaceAll(body, []byte(placeholder), original)
+		body = bytes.ReplaceAll(body, []byte(escaped), original)
+	}
+	return body
+}
+
+type chunkChoice struct {
+	Index        int             `json:"index"`
+	Delta        *chunkDelta     `json:"delta,omitempty"`
+	FinishReason json.RawMessage `json:"finish_reason,omitempty"`
+}
+
+type chunkDelta struct {
+	Content *string `json:"content,omitempty"`
+}
+
+// textDelta is the Responses API event that streams output text.
+const textDelta = "response.output_text.delta"
+
+// restoreEvent rewrites the delta contents of one chat completion chunk or
+// Responses API text delta, after the event line that names it. Lines that
+// aren't either, or need no rewriting, are passed through as is.
+func (d *detokenizer) restoreEvent(event, line, data []byte) []byte {
+	var chunk struct {
+		Type    string        `json:"type"`
+		Choices []chunkChoice `json:"choices"`
+		outputPart
+		Delta *string `json:"delta"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return append(event, d.restoreJSON(line)...)
+	}
+	if chunk.Type == textDelta && chunk.Delta != nil {
+		return append(event, d.restoreTextDelta(chunk.outputPart, *chunk.Delta, line, data)...)
+	}
+	if strings.HasPrefix(chunk.Type, "response.") && len(d.partCarry) > 0 {
+		// The text is over, e.g. output_text.done, whatever is held back
+		// goes out before it
+		return append(append(d.flushCarry(), event...), d.restoreJSON(line)...)
+	}
+	return append(event, d.restoreChunk(chunk.Choices, line, data)...)
+}
+
+// restoreTextDelta rewrites the delta of a Responses API text event.
+func (d *detokenizer) restoreTextDelta(part outputPart, delta string, line, data []byte) []byte {
+	restored, carry := d.restore(d.partCarry[part] + delta)
+	if carry == "" {
+		delete(d.partCarry, part)
+	} else {
+		d.partCarry[part] = carry
+	}
+	if restored == delta {
+		return line
+	}
+
+	var raw map[string]json.RawMessage
+	json.Unmarshal(data, &raw)
+	raw["delta"] = marshalJSON(restored)
+	return append(append([]byte("data: "), marshalJSON(raw)...), lineEnding(line)...)
+}
+
+// restoreChunk rewrites the delta contents of a chat completion chunk.
+func (d *detokenizer) restoreChunk(choices []chunkChoice, line, data []byte) []byte {
+	contents := make(map[int]string)
+	changed := false
+	for _, choice := range choices {
+		text := d.carry[choice.Index]
+		if choice.Delta != nil && choice.Delta.Content != nil {
+			text += *choice.Delta.Content
+		}
+		restored, carry := d.restore(text)
+		if finished(choice.FinishReason) {
+			restored, carry = restored+carry, ""
+		}
+		if carry == "" {
+			delete(d.carry, choice.Index)
+		} else {
+			d.carry[choice.Index] = carry
+		}
+
+		original := ""
+		if choice.Delta != nil && choice.Delta.Content != nil {
+			original = *choice.Delta.Content
+		}
+		if restored != original {
+			contents[choice.Index] = restored
+			changed = true
+		}
+	}
+	if !changed {
+		// Events of other endpoints keep their text elsewhere, complete
+		// placeholders there are still restored
+		return d.restoreJSON(line)
+	}
+
+	// Only the contents change, every other field is kept as sent
+	var raw map[string]json.RawMessage
+	json.Unmarshal(data, &raw)
+	var rawChoices []map[string]json.RawMessage
+	json.Unmarshal(raw["choices"], &rawChoices)
+	for i, choice := range rawChoices {
+		content, ok := contents[choices[i].Index]
+		if !ok {
+			continue
+		}
+		var delta map[string]json.RawMessage
+		json.Unmarshal(choice["delta"], &delta)
+		if delta == nil {
+			delta = make(map[string]json.RawMessage)
+		}
+		delta["content"] = marshalJSON(content)
+		choice["delta"] = marshalJSON(delta)
+	}
+	raw["choices"] = marshalJSON(rawChoices)
+	rewritten := marshalJSON(raw)
+
+	return append(append([]byte("data: "), rewritten...), lineEnding(line)...)
+}
+
+// restore replaces complete placeholders in text. A trailing fragment that
+// could still grow into a placeholder is returned separately as carry.
+func (d *detokenizer) restore(text string) (restored, carry string) {
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(text, '<')
+		if i < 0 {
+			out.WriteString(text)
+			return out.String(), ""
+		}
+		out.WriteString(text[:i])
+		text = text[i:]
+
+		if j := strings.IndexByte(text, '>'); j >= 0 {
+			if original, ok := d.tokens[text[:j+1]]; ok {
+				out.WriteString(original)
+				text = text[j+1:]
+				continue
+			}
+		} else if d.isPrefix(text) {
+			return out.String(), text
+		}
+		out.WriteByte('<')
+		text = text[1:]
+	}
+}
+
+// isPrefix reports whether text is the start of some placeholder.
+func (d *detokenizer) isPrefix(text string) bool {
+	i := sort.SearchStrings(d.placeholders, text)
+	return i < len(d.placeholders) && strings.HasPrefix(d.placeholders[i], text)
+}
+
+// flushCarry emits held back text that never completed a placeholder as a
+// final chunk, or text delta, of its own.
+func (d *detokenizer) flushCarry() []byte {
+	out := d.flushPartCarry()
+	if len(d.carry) == 0 {
+		return out
+	}
+	indexes := make([]int, 0, len(d.carry))
+	for index := range d.carry {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		content := d.carry[index]
+		chunk := marshalJSON(map[string]any{
+			"object":  "chat.completion.chunk",
+			"choices": []chunkChoice{{Index: index, Delta: &chunkDelta{Content: &content}}},
+		})
+		out = append(out, "data: "...)
+		out = append(out, chunk...)
+		out = append(out, "\n\n"...)
+	}
+	clear(d.carry)
+	return out
+}
+
+func (d *detokenizer) flushPartCarry() []byte {
+	parts := make([]outputPart, 0, len(d.partCarry))
+	for part := range d.partCarry {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].OutputIndex != parts[j].OutputIndex {
+			return parts[i].OutputIndex < parts[j].OutputIndex
+		}
+		return parts[i].ContentIndex < parts[j].ContentIndex
+	})
+
+	var out []byte
+	for _, part := range parts {
+		event := marshalJSON(map[string]any{
+			"type":          textDelta,
+			"item_id":       part.ItemID,
+			"output_index":  part.OutputIndex,
+			"content_index": part.ContentIndex,
+			"delta":         d.partCarry[part],
+		})
+		out = append(out, "event: "+textDelta+"\ndata: "...)
+		out = append(out, event...)
+		out = append(out, "\n\n"...)
+	}
+	clear(d.partCarry)
+	return out
+}
+
+func finished(reason json.RawMessage) bool {
+	return len(reason) > 0 && string(reason) != "null"
+}
+
+// marshalJSON encodes v without escaping <, > and &, so restored text reads
+// the same as the upstream's own.
+func marshalJSON(v any) json.RawMessage {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+func lineEnding(line []byte) []byte {
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		return []byte("\r\n")
+	}
+	return []byte("\n")
+}
@@ -0,0 +1,23 @@
+package domain
+
+// ModelInfo is an entry of the model listing, in the OpenAI format with the
+// gateway's metadata alongside.
+type ModelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	// Provider serving the model, "default" for the main upstream
+	Provider string `json:"provider,omitempty"`
+	// AliasOf is the model an alias stands for
+	AliasOf string `json:"alias_of,omitempty"`
+	ModelMetadata
+	Pricing *ModelPrice `json:"pricing,omitempty"`
+}
+
+// ModelMetadata describes a model beyond what upstreams list.
+type ModelMetadata struct {
+	ContextWindow   int    `json:"context_window,omitempty"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"`
+	Description     string `json:"description,omitempty"`
+}
@@ -0,0 +1,20 @@
+package domain
+
+// Endpoint is an OpenAI-compatible API served by the proxy, named by its path
+// without the /v1 prefix.
+type Endpoint string
+
+const (
+	EndpointChat        Endpoint = "chat/completions"
+	EndpointCompletions Endpoint = "completions"
+	EndpointEmbeddings  Endpoint = "embeddings"
+	EndpointModerations Endpoint = "moderations"
+	EndpointResponses   Endpoint = "responses"
+	EndpointModels      Endpoint = "models"
+)
+
+// IsChat reports whether requests to the endpoint are chat completions,
+// which is what requests without an endpoint are taken to be.
+func (e Endpoint) IsChat() bool {
+	return e == "" || e == EndpointChat
+}
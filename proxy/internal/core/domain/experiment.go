@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnknownRequest is returned for feedback on a request that wasn't part
+// of an experiment, or is too old to remember.
+var ErrUnknownRequest = errors.New("unknown request")
+
+// ExperimentOutcome is the result of one upstream call made for a variant.
+type ExperimentOutcome struct {
+	RequestID  string
+	Experiment string
+	Variant    string
+	Model      string
+	Latency    time.Duration
+	Usage      *Usage
+	CostUSD    float64
+	Failed     bool
+}
+
+// VariantStats aggregates the outcomes of a variant.
+type VariantStats struct {
+	Experiment     string  `json:"experiment"`
+	Variant        string  `json:"variant"`
+	Model          string  `json:"model"`
+	Requests       int     `json:"requests"`
+	Errors         int     `json:"errors"`
+	ErrorRate      float64 `json:"error_rate"`
+	LatencyMeanMs  float64 `json:"latency_mean_ms"`
+	LatencyP50Ms   float64 `json:"latency_p50_ms"`
+	LatencyP95Ms   float64 `json:"latency_p95_ms"`
+	CostUSD        float64 `json:"cost_usd"`
+	CostPerRequest float64 `json:"cost_per_request_usd"`
+	Feedback       int     `json:"feedback"`
+	MeanScore      float64 `json:"mean_score"` // From 0 (bad) to 1 (good)
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+)
+
+// VirtualKeyPrefix starts every secret the proxy issues, which tells them
+// apart from other bearer tokens.
+const VirtualKeyPrefix = "sk-baldr-"
+
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrInvalidKeySpec  = errors.New("invalid key settings")
+	ErrInvalidKey      = errors.New("invalid api key")
+	ErrKeySuspended    = errors.New("api key suspended")
+	ErrKeyExpired      = errors.New("api key expired")
+	ErrModelNotAllowed = errors.New("model not allowed")
+	ErrRateLimited     = errors.New("rate limit exceeded")
+	ErrBudgetExceeded  = errors.New("budget exceeded")
+)
+
+type KeyStatus string
+
+const (
+	KeyActive    KeyStatus = "active"
+	KeySuspended KeyStatus = "suspended"
+)
+
+// KeySpec is what an admin decides about a virtual key.
+type KeySpec struct {
+	Name  string `json:"name,omitempty"`
+	Owner string `json:"owner"`
+	Team  string `json:"team,omitempty"`
+	// Models the key may use, as path.Match patterns. Empty allows all.
+	Models []string  `json:"models,omitempty"`
+	Limits KeyLimits `json:"limits"`
+	// BudgetUSD the key may spend in total, 0 for no budget
+	BudgetUSD float64    `json:"budget_usd,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// KeyLimits cap the use of a key, 0 for no cap.
+type KeyLimits struct {
+	RequestsPerMinute   int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute     int `json:"tokens_per_minute,omitempty"`
+	MaxTokensPerRequest int `json:"max_tokens_per_request,omitempty"`
+}
+
+// VirtualKey is a key the proxy issues to its clients. Only a hash of the
+// secret is kept, the secret itself is shown once.
+type VirtualKey struct {
+	ID string `json:"id"`
+	KeySpec
+	Status KeyStatus `json:"status"`
+	// Prefix of the secret, to recognize it
+	Prefix    string    `json:"prefix"`
+	SpentUSD  float64   `json:"spent_usd"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Hash      string    `json:"-"`
+}
+
+// Allows reports whether the key may use the model.
+func (k *VirtualKey) Allows(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, pattern := range k.Models {
+		if matched, _ := path.Match(pattern, model); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the key is past its expiry at now.
+func (k *VirtualKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+type virtualKeyKey struct{}
+
+// WithVirtualKey attaches the key a request authenticated with.
+func WithVirtualKey(ctx context.Context, key *VirtualKey) context.Context {
+	return context.WithValue(ctx, virtualKeyKey{}, key)
+}
+
+// VirtualKeyFrom returns the key attached to the context, or nil.
+func VirtualKeyFrom(ctx context.Context) *VirtualKey {
+	key, _ := ctx.Value(virtualKeyKey{}).(*VirtualKey)
+	return key
+}
@@ -0,0 +1,16 @@
+package domain
+
+import (
+	"math"
+	"time"
+)
+
+// Percentile is the q quantile of sorted latencies, by nearest rank, 0 when
+// there are none.
+func Percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[min(max(0, i), len(sorted)-1)]
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // RequestPayload represents the core input to the system.
 type RequestPayload struct {
@@ -10,8 +13,55 @@ type RequestPayload struct {
 }
 
 type GuardrailResponse struct {
+	// Version of the verdict schema, see GuardrailContractVersion.
+	Version string `json:"version,omitempty"`
 	Allowed bool   `json:"allowed"`
 	Reason  string `json:"reason,omitempty"`
+	// Action supersedes Allowed when set, except that a verdict that
+	// isn't allowed always blocks.
+	Action     GuardrailAction `json:"action,omitempty"`
+	Violations []Violation     `json:"violations,omitempty"`
+	// RerouteModel is the model to use when Action is ActionReroute.
+	RerouteModel string `json:"reroute_model,omitempty"`
 	// Use RawMessage so we can capture any JSON structure (dict, list, etc.)
 	SanitizedInput json.RawMessage `json:"sanitized_input,omitempty"`
+	// Tokens maps the placeholders in SanitizedInput back to the values they
+	// replaced, so they can be restored in the response. See Vault.
+	Tokens map[string]string `json:"tokens,omitempty"`
+	// PolicyVersion identifies the guardrail policy that produced the verdict.
+	PolicyVersion string `json:"policy_version,omitempty"`
+	// Stages holds the per-stage breakdown when several guardrails are composed.
+	Stages []StageVerdict `json:"stages,omitempty"`
+}
+
+// HasSanitizedInput reports whether the guardrail rewrote the payload.
+// An empty or null value means the payload is unchanged.
+func (r *GuardrailResponse) HasSanitizedInput() bool {
+	return len(r.SanitizedInput) > 0 && string(r.SanitizedInput) != "null"
+}
+
+// EffectiveAction returns the action to enforce. A verdict that doesn't
+// allow the request blocks it, whatever its action says. Verdicts from
+// sidecars that predate actions are mapped from Allowed and SanitizedInput.
+func (r *GuardrailResponse) EffectiveAction() GuardrailAction {
+	switch {
+	case !r.Allowed:
+		return ActionBlock
+	case r.Action != "":
+		return r.Action
+	case r.HasSanitizedInput():
+		return ActionRedact
+	default:
+		return ActionAllow
+	}
+}
+
+// StageVerdict is the outcome of a single stage of a guardrail pipeline.
+type StageVerdict struct {
+	Name     string          `json:"name"`
+	Allowed  bool            `json:"allowed"`
+	Action   GuardrailAction `json:"action"`
+	Reason   string          `json:"reason,omitempty"`
+	Redacted bool            `json:"redacted,omitempty"`
+	Latency  time.Duration   `json:"latency_ns"`
 }
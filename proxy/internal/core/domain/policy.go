@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPolicyViolation refuses a request one of its request policies doesn't
+// allow.
+var ErrPolicyViolation = errors.New("policy violation")
+
+// RequestPolicy restricts what the requests of some keys and teams may ask
+// for. Rules left out don't restrict anything, and only what a request asks
+// for is checked: a request without a temperature passes any range.
+type RequestPolicy struct {
+	Name string `json:"name"`
+	// Keys and Teams the policy applies to, by key ID and team. Teams are
+	// those of virtual keys, never the X-Baldr-Team header. A policy naming
+	// neither applies to every request.
+	Keys  []string `json:"keys,omitempty"`
+	Teams []string `json:"teams,omitempty"`
+
+	// Models that may be asked for, as path.Match patterns
+	Models []string `json:"models,omitempty"`
+	// MaxTokens caps max_tokens, or the endpoint's equivalent, and is set
+	// on requests without one
+	MaxTokens   int          `json:"max_tokens,omitempty"`
+	Temperature *PolicyRange `json:"temperature,omitempty"`
+	// Tools, when false, refuses requests offering tools to the model.
+	// AllowedTools otherwise restricts them, by path.Match patterns over
+	// function names, or types for built-in tools.
+	Tools        *bool    `json:"tools,omitempty"`
+	AllowedTools []string `json:"allowed_tools,omitempty"`
+	// Images, when false, refuses image inputs
+	Images *bool `json:"images,omitempty"`
+	// Stream, when false, refuses streamed responses
+	Stream *bool `json:"stream,omitempty"`
+}
+
+// PolicyRange bounds a parameter, each end optional and inclusive.
+type PolicyRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// Contains reports whether v is within the range.
+func (r PolicyRange) Contains(v float64) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// PolicyViolation is the rule of a policy a request broke. It matches
+// ErrPolicyViolation.
+type PolicyViolation struct {
+	Policy string
+	// Rule is the policy field that fired, e.g. "temperature"
+	Rule string
+	// Param is the request field at fault
+	Param  string
+	Reason string
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("%s: policy %q, rule %s: %s", ErrPolicyViolation, v.Policy, v.Rule, v.Reason)
+}
+
+func (v *PolicyViolation) Unwrap() error {
+	return ErrPolicyViolation
+}
+
+// PolicyInput is what expression policies judge a request by, once the
+// guardrail has seen it.
+type PolicyInput struct {
+	// Key is the caller's virtual key, nil for other bearer tokens. Its
+	// team is the only one policies see, never the X-Baldr-Team header.
+	Key      *VirtualKey
+	KeyID    string
+	User     string
+	Model    string
+	Endpoint Endpoint
+	Provider string
+	// PromptTokens is the estimated prompt
+	PromptTokens    int
+	GuardrailAction GuardrailAction
+	Violations      []Violation
+	// Body is the request as it would go upstream
+	Body map[string]any
+	Time time.Time
+}
+
+// PolicyDecision is what expression policies made of a request. Policies
+// that route or transform it add up, in order.
+type PolicyDecision struct {
+	// Denied is the policy that refused the request, empty when none did
+	Denied string
+	Reason string
+	// Model the request is routed to, empty to keep its own
+	Model string
+	// Set holds the fields to set on the request, a nil value removes one
+	Set map[string]any
+	// Applied names every policy that fired, in order
+	Applied []string
+}
@@ -0,0 +1,76 @@
+package domain
+
+import "context"
+
+// Direction tells the guardrail whether it is checking what the client sent
+// or what the model answered.
+type Direction string
+
+const (
+	DirectionInput  Direction = "input"
+	DirectionOutput Direction = "output"
+)
+
+// RequestMetadata describes who is calling and what for. The handler builds
+// it and it travels with the request context through the service and into
+// the adapters.
+type RequestMetadata struct {
+	RequestID string    `json:"request_id"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Team      string    `json:"team,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Direction Direction `json:"direction"`
+	PolicyID  string    `json:"policy_id,omitempty"`
+	Endpoint  Endpoint  `json:"endpoint,omitempty"`
+
+	// Key is the virtual key the caller authenticated with, nil for other
+	// bearer tokens. KeyID and Team are then the key's.
+	Key *VirtualKey `json:"-"`
+	// User is the end user the client acts for, from the request's "user"
+	// field or the X-Baldr-User header.
+	User string `json:"-"`
+	// Experiment and Variant are set by the service when the request takes
+	// part in an A/B experiment.
+	Experiment string `json:"-"`
+	Variant    string `json:"-"`
+	// Provider is the upstream the service sends the request to, "default"
+	// for the main one.
+	Provider string `json:"-"`
+	// PromptTokens is the service's estimate of the request's prompt
+	// tokens, 0 when it wasn't estimated.
+	PromptTokens int `json:"-"`
+
+	// ResponseCache is the client's cache preference (X-Baldr-Cache): "true"
+	// makes the request cacheable whatever its temperature, "false" opts out.
+	ResponseCache string `json:"-"`
+	// CacheStatus is set by the service once it knows whether the response
+	// came from the cache, so the handler can report it.
+	CacheStatus CacheStatus `json:"-"`
+	// GuardrailAction is the enforced action, empty when the guardrail
+	// failed or was skipped by a cache hit.
+	GuardrailAction GuardrailAction `json:"-"`
+}
+
+type CacheStatus string
+
+const (
+	CacheHit         CacheStatus = "HIT"
+	CacheSemanticHit CacheStatus = "SEMANTIC_HIT" // Answer to a similar request
+	CacheMiss        CacheStatus = "MISS"
+)
+
+type metadataKey struct{}
+
+// WithRequestMetadata attaches metadata to the context.
+func WithRequestMetadata(ctx context.Context, md *RequestMetadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// RequestMetadataFrom returns the metadata attached to the context, or an
+// empty value when there is none, so callers never have to nil-check.
+func RequestMetadataFrom(ctx context.Context) *RequestMetadata {
+	if md, ok := ctx.Value(metadataKey{}).(*RequestMetadata); ok {
+		return md
+	}
+	return &RequestMetadata{Direction: DirectionInput}
+}
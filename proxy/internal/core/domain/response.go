@@ -0,0 +1,26 @@
+package domain
+
+// CachedResponse is an upstream response kept for replay. Chunks are stored
+// as they were read so a streamed hit has the original SSE chunking.
+// Responses are kept as the upstream wrote them, with the guardrail's
+// placeholders rather than the values behind them, so caches never hold
+// what the guardrail took out.
+type CachedResponse struct {
+	Chunks [][]byte
+	// Verdict is the guardrail's verdict on the request the response
+	// answered, so policies can judge a hit as they judged the original.
+	// It carries no tokens.
+	Verdict *GuardrailResponse
+	// Tokenized responses need the tokens of the request they answer,
+	// which only the guardrail can give back
+	Tokenized bool
+}
+
+// Size is the number of body bytes held by the response.
+func (r *CachedResponse) Size() int {
+	n := 0
+	for _, c := range r.Chunks {
+		n += len(c)
+	}
+	return n
+}
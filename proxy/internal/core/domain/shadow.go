@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// ShadowRecord pairs the response the client got with the response of the
+// shadow model to the same (sanitized) request.
+type ShadowRecord struct {
+	RequestID string       `json:"request_id"`
+	KeyID     string       `json:"key_id,omitempty"`
+	Team      string       `json:"team,omitempty"`
+	Time      time.Time    `json:"time"`
+	Primary   ShadowResult `json:"primary"`
+	Shadow    ShadowResult `json:"shadow"`
+}
+
+// ShadowResult is one side of a mirrored request. Bodies are kept as the
+// upstream sent them, placeholders included.
+type ShadowResult struct {
+	Model     string        `json:"model"`
+	Content   string        `json:"content"` // Text of the answer, reassembled from SSE
+	Body      string        `json:"body,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Latency   time.Duration `json:"latency_ns"`
+	Usage     *Usage        `json:"usage,omitempty"`
+	CostUSD   float64       `json:"cost_usd"`
+	Error     string        `json:"error,omitempty"`
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "errors"
+
+var (
+	// ErrContextLengthExceeded refuses a request that doesn't fit the
+	// model's context window.
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	// ErrTokenLimitExceeded refuses a request over its key's per-request
+	// token cap.
+	ErrTokenLimitExceeded = errors.New("token limit exceeded")
+)
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ModelPrice is the list price of a model, in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Cost of a call with the given usage.
+func (p ModelPrice) Cost(u Usage) float64 {
+	return (p.Input*float64(u.PromptTokens) + p.Output*float64(u.CompletionTokens)) / 1e6
+}
+
+// Usage is the token count an upstream reports for a call.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// UnmarshalJSON also reads the input/output naming of the Responses API.
+func (u *Usage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		InputTokens      int `json:"input_tokens"`
+		OutputTokens     int `json:"output_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	u.PromptTokens = max(raw.PromptTokens, raw.InputTokens)
+	u.CompletionTokens = max(raw.CompletionTokens, raw.OutputTokens)
+	u.TotalTokens = raw.TotalTokens
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return nil
+}
+
+// UsageRecord is the usage of one upstream call.
+type UsageRecord struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id"`
+	KeyID     string        `json:"key_id,omitempty"`
+	Team      string        `json:"team,omitempty"`
+	Endpoint  Endpoint      `json:"endpoint"`
+	Model     string        `json:"model"`
+	Provider  string        `json:"provider,omitempty"`
+	Usage     Usage         `json:"usage"`
+	CostUSD   float64       `json:"cost_usd"`
+	Latency   time.Duration `json:"latency_ns"`
+	Failed    bool          `json:"failed,omitempty"`
+}
+
+// UsageSummary aggregates usage records. Only the fields the records are
+// grouped by are set.
+type UsageSummary struct {
+	Period           time.Time `json:"period,omitzero"` // Start of the hour or day
+	KeyID            string    `json:"key_id,omitempty"`
+	Team             string    `json:"team,omitempty"`
+	Endpoint         Endpoint  `json:"endpoint,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	Provider         string    `json:"provider,omitempty"`
+	Requests         int       `json:"requests"`
+	Errors           int       `json:"errors"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	CostUSD          float64   `json:"cost_usd"`
+}
+
+// UsageDimensions are what usage reports can be grouped by.
+var UsageDimensions = []string{"key", "team", "model", "provider", "endpoint"}
+
+// UsageQuery selects and groups usage records for a report.
+type UsageQuery struct {
+	// From and To bound the range, To excluded. Records are kept by the
+	// hour, so the range is too.
+	From, To time.Time
+	// GroupBy dimensions, among UsageDimensions
+	GroupBy []string
+	// Interval splits the range by "hour" or "day", empty for none
+	Interval string
+}
@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Vault maps the placeholders a guardrail substituted for sensitive values
+// (e.g. "<EMAIL_1>") back to the originals. It lives for a single request:
+// the guardrail fills it on the way in, the service drains it on the way out
+// so the client gets its own data back while the upstream never sees it.
+type Vault struct {
+	mu       sync.Mutex
+	tokens   map[string]string // Placeholder to original
+	byValue  map[string]string // Original to placeholder, keeps placeholders stable
+	counters map[string]int
+}
+
+func NewVault() *Vault {
+	return &Vault{
+		tokens:   make(map[string]string),
+		byValue:  make(map[string]string),
+		counters: make(map[string]int),
+	}
+}
+
+// Tokenize returns the placeholder for value, minting a new one labelled
+// after the category the first time the value is seen.
+func (v *Vault) Tokenize(category, value string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if placeholder, ok := v.byValue[value]; ok {
+		return placeholder
+	}
+	for {
+		v.counters[category]++
+		placeholder := fmt.Sprintf("<%s_%d>", category, v.counters[category])
+		if _, taken := v.tokens[placeholder]; !taken {
+			v.tokens[placeholder] = value
+			v.byValue[value] = placeholder
+			return placeholder
+		}
+	}
+}
+
+// Merge adds placeholders minted elsewhere, e.g. by a sidecar. A placeholder
+// already bound to a different value is an error, restoring either one would
+// hand the client the wrong data.
+func (v *Vault) Merge(tokens map[string]string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for placeholder, value := range tokens {
+		if existing, ok := v.tokens[placeholder]; ok && existing != value {
+			return fmt.Errorf("placeholder %s is bound to two different values", placeholder)
+		}
+		v.tokens[placeholder] = value
+		if _, ok := v.byValue[value]; !ok {
+			v.byValue[value] = placeholder
+		}
+	}
+	return nil
+}
+
+// Tokens returns a copy of the placeholder to original mapping.
+func (v *Vault) Tokens() map[string]string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	tokens := make(map[string]string, len(v.tokens))
+	for placeholder, value := range v.tokens {
+		tokens[placeholder] = value
+	}
+	return tokens
+}
+
+func (v *Vault) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.tokens)
+}
+
+type vaultKey struct{}
+
+// WithVault attaches the request's vault to the context.
+func WithVault(ctx context.Context, v *Vault) context.Context {
+	return context.WithValue(ctx, vaultKey{}, v)
+}
+
+// VaultFrom returns the vault attached to the context, or a fresh one when
+// there is none, so callers never have to nil-check.
+func VaultFrom(ctx context.Context) *Vault {
+	if v, ok := ctx.Value(vaultKey{}).(*Vault); ok {
+		return v
+	}
+	return NewVault()
+}
@@ -0,0 +1,67 @@
+package domain
+
+// GuardrailContractVersion is the newest proxy/sidecar contract the proxy
+// speaks:
+//   - 1 (or no version at all): raw body in, allowed/reason/sanitized_input out
+//   - 2: verdicts carry actions and violations
+//   - 3: requests are wrapped in an envelope carrying RequestMetadata
+const GuardrailContractVersion = "3"
+
+// GuardrailAction tells the service how to treat a request.
+type GuardrailAction string
+
+const (
+	ActionAllow   GuardrailAction = "allow"   // Forward unchanged
+	ActionRedact  GuardrailAction = "redact"  // Forward the sanitized input
+	ActionBlock   GuardrailAction = "block"   // Reject the request
+	ActionWarn    GuardrailAction = "warn"    // Forward, but log the violations
+	ActionReroute GuardrailAction = "reroute" // Forward to RerouteModel instead
+)
+
+// actionRank orders actions from least to most severe, so the strongest one
+// wins when verdicts are combined.
+var actionRank = map[GuardrailAction]int{
+	ActionAllow:   0,
+	ActionWarn:    1,
+	ActionRedact:  2,
+	ActionReroute: 3,
+	ActionBlock:   4,
+}
+
+// Valid reports whether the action is one the service knows how to enforce.
+func (a GuardrailAction) Valid() bool {
+	_, ok := actionRank[a]
+	return ok
+}
+
+// StrongerThan reports whether a should override b.
+func (a GuardrailAction) StrongerThan(b GuardrailAction) bool {
+	return actionRank[a] > actionRank[b]
+}
+
+type Severity string
+
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// Span locates a match inside the request body. Path points at the text
+// field (e.g. "messages.1.content"), offsets are bytes within that field.
+type Span struct {
+	Path  string `json:"path,omitempty"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Violation is a single finding behind a verdict. It never carries the
+// matched text, only where it was found.
+type Violation struct {
+	Category string   `json:"category"`
+	Score    float64  `json:"score"` // Confidence between 0 and 1
+	Severity Severity `json:"severity,omitempty"`
+	Detector string   `json:"detector,omitempty"`
+	Spans    []Span   `json:"spans,omitempty"`
+}
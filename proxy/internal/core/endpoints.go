@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// endpointInput is a request to an endpoint other than chat completions.
+// Guardrails speak chat completions, so they are shown a chat request whose
+// messages are the request's free text inputs, and what they sanitize is
+// written back into the original fields.
+type endpointInput struct {
+	root  map[string]any
+	model string
+	texts []endpointText
+	// tokenIDs is set when an input is given as token IDs, which guardrails
+	// can't read
+	tokenIDs bool
+}
+
+type endpointText struct {
+	role  string
+	value string
+	set   func(string)
+}
+
+func newEndpointInput(endpoint domain.Endpoint, payload []byte) (*endpointInput, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber() // Keep numeric parameters byte-for-byte
+	var root map[string]any
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+
+	in := &endpointInput{root: root}
+	in.model, _ = root["model"].(string)
+	switch endpoint {
+	case domain.EndpointCompletions:
+		in.addStrings(root, "prompt", "user")
+	case domain.EndpointEmbeddings, domain.EndpointModerations:
+		in.addStrings(root, "input", "user")
+	case domain.EndpointResponses:
+		in.addStrings(root, "instructions", "system")
+		in.addResponseInput(root)
+	}
+	if in.tokenIDs {
+		// Token IDs would reach the upstream unchecked: fail closed
+		return nil, fmt.Errorf("inputs given as token IDs can't be checked by the guardrail")
+	}
+	return in, nil
+}
+
+// addStrings registers obj[key] when it holds a string, or a list of strings
+// and text parts, and notes token arrays.
+func (in *endpointInput) addStrings(obj map[string]any, key, role string) {
+	switch value := obj[key].(type) {
+	case string:
+		in.add(role, value, func(v string) { obj[key] = v })
+	case []any:
+		for i, item := range value {
+			switch item := item.(type) {
+			case string:
+				in.add(role, item, func(v string) { value[i] = v })
+			case map[string]any:
+				in.addStrings(item, "text", role)
+			case json.Number, []any:
+				in.tokenIDs = true
+			}
+		}
+	}
+}
+
+// addResponseInput registers the input of a Responses API request: a string
+// or a list of items, each a message or the output of a tool call.
+func (in *endpointInput) addResponseInput(root map[string]any) {
+	items, ok := root["input"].([]any)
+	if !ok {
+		in.addStrings(root, "input", "user")
+		return
+	}
+	for _, item := range items {
+		msg, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if msg["type"] == "function_call_output" {
+			in.addStrings(msg, "output", "tool")
+			continue
+		}
+		role, _ := msg["role"].(string)
+		if role == "" {
+			role = "user"
+		}
+		in.addStrings(msg, "content", role)
+	}
+}
+
+func (in *endpointInput) add(role, value string, set func(string)) {
+	in.texts = append(in.texts, endpointText{role: role, value: value, set: set})
+}
+
+type viewMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatView struct {
+	Model    string        `json:"model"`
+	Messages []viewMessage `json:"messages"`
+}
+
+// chatView is the request as the guardrail sees it.
+func (in *endpointInput) chatView() []byte {
+	view := chatView{Model: in.model, Messages: make([]viewMessage, 0, len(in.texts))}
+	for _, t := range in.texts {
+		view.Messages = append(view.Messages, viewMessage{Role: t.role, Content: t.value})
+	}
+	return marshalJSON(view)
+}
+
+// apply writes the guardrail's version of the chat view back into the
+// request. A view whose messages no longer line up with the inputs can't be
+// mapped back, and fails closed.
+func (in *endpointInput) apply(view []byte) ([]byte, error) {
+	var sanitized chatView
+	if err := json.Unmarshal(view, &sanitized); err != nil {
+		return nil, fmt.Errorf("unreadable sanitized input: %w", err)
+	}
+	if len(sanitized.Messages) != len(in.texts) {
+		return nil, fmt.Errorf("sanitized input has %d messages for %d inputs", len(sanitized.Messages), len(in.texts))
+	}
+	for i, msg := range sanitized.Messages {
+		in.texts[i].set(msg.Content)
+	}
+	if sanitized.Model != "" && sanitized.Model != in.model {
+		in.root["model"] = sanitized.Model // Rerouted
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(in.root); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
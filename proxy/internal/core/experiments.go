@@ -0,0 +1,97 @@
+package core
+
+import (
+	"hash/fnv"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// Experiment splits the traffic of a logical model between variants.
+type Experiment struct {
+	Name string
+	// Model is the logical model clients ask for
+	Model string
+	// Sticky is what assignment sticks to: "key" (the default) or "user".
+	// Requests without a user fall back to their key.
+	Sticky   string
+	Variants []Variant
+}
+
+// Variant is one arm of an experiment.
+type Variant struct {
+	Name  string
+	Model string // Model sent upstream
+	// Weight is the variant's share of the traffic, relative to the others
+	Weight float64
+	// LLM serves the variant, nil for the service's default upstream
+	LLM ports.LLMPort
+}
+
+// ExperimentsConfig holds the experiments run by the service.
+type ExperimentsConfig struct {
+	Experiments []Experiment
+	Stats       ports.ExperimentStatsPort
+	// Pricing by model, for the cost of each variant
+	Pricing map[string]domain.ModelPrice
+}
+
+// WithExperiments assigns requests for the experiments' logical models to a
+// variant, sticky per key or end user, and records each variant's latency,
+// cost and errors.
+func (s *BaldrService) WithExperiments(config ExperimentsConfig) *BaldrService {
+	s.experiments = make(map[string]*Experiment, len(config.Experiments))
+	for i := range config.Experiments {
+		s.experiments[config.Experiments[i].Model] = &config.Experiments[i]
+	}
+	s.experimentStats = config.Stats
+	s.pricing = config.Pricing
+	return s
+}
+
+// assignVariant picks the variant of a request for an experimented model and
+// rewrites the payload to ask for it. Other requests are returned as is.
+func (s *BaldrService) assignVariant(md *domain.RequestMetadata, payload []byte) ([]byte, *Variant, error) {
+	experiment, ok := s.experiments[md.Model]
+	if !ok || len(experiment.Variants) == 0 {
+		return payload, nil, nil
+	}
+	variant := experiment.assign(md)
+	rewritten, err := setModel(payload, variant.Model)
+	if err != nil {
+		return nil, nil, err
+	}
+	md.Experiment = experiment.Name
+	md.Variant = variant.Name
+	md.Model = variant.Model
+	return rewritten, variant, nil
+}
+
+// assign hashes the caller onto the variants' weights, so the same caller
+// always lands on the same variant while the experiment is unchanged.
+func (e *Experiment) assign(md *domain.RequestMetadata) *Variant {
+	subject := md.KeyID
+	if e.Sticky == "user" && md.User != "" {
+		subject = "user:" + md.User
+	}
+	if subject == "" {
+		subject = md.RequestID
+	}
+	h := fnv.New64a()
+	h.Write([]byte(e.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(subject))
+
+	total := 0.0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	point := float64(h.Sum64()%10000) / 10000 * total
+	for i := range e.Variants {
+		point -= e.Variants[i].Weight
+		if point < 0 {
+			return &e.Variants[i]
+		}
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
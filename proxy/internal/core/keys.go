@@ -0,0 +1,274 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// KeyManager issues virtual keys, authenticates them and holds them to
+// their models, limits and budget.
+type KeyManager struct {
+	store ports.KeyStorePort
+	now   func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*keyWindow // Use in the current minute, by key ID
+}
+
+type keyWindow struct {
+	start    time.Time
+	requests int
+	tokens   int
+}
+
+func NewKeyManager(store ports.KeyStorePort) *KeyManager {
+	return &KeyManager{store: store, now: time.Now, windows: make(map[string]*keyWindow)}
+}
+
+// WithVirtualKeys enforces the limits of the virtual key each request
+// authenticated with, and charges it for the calls it makes.
+func (s *BaldrService) WithVirtualKeys(keys *KeyManager) *BaldrService {
+	s.keys = keys
+	return s
+}
+
+func (m *KeyManager) Create(ctx context.Context, spec domain.KeySpec) (*domain.VirtualKey, string, error) {
+	if err := m.validate(spec); err != nil {
+		return nil, "", err
+	}
+	if spec.ExpiresAt != nil && !spec.ExpiresAt.After(m.now()) {
+		return nil, "", fmt.Errorf("%w: expires_at is in the past", domain.ErrInvalidKeySpec)
+	}
+	secret := newSecret()
+	now := m.now().UTC()
+	key := &domain.VirtualKey{
+		ID:        "vk_" + randomHex(8),
+		KeySpec:   spec,
+		Status:    domain.KeyActive,
+		Prefix:    secret[:len(domain.VirtualKeyPrefix)+4],
+		CreatedAt: now,
+		UpdatedAt: now,
+		Hash:      hashSecret(secret),
+	}
+	if err := m.store.Create(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+func (m *KeyManager) List(ctx context.Context) ([]*domain.VirtualKey, error) {
+	return m.store.List(ctx)
+}
+
+func (m *KeyManager) Get(ctx context.Context, id string) (*domain.VirtualKey, error) {
+	return m.store.Get(ctx, id)
+}
+
+// Update merges patch, a partial spec in JSON, into the key's spec as a JSON
+// merge patch (RFC 7386): objects merge and fields set to null are cleared.
+func (m *KeyManager) Update(ctx context.Context, id string, patch json.RawMessage) (*domain.VirtualKey, error) {
+	key, err := m.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var changes any
+	if err := decodeNumbers(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidKeySpec, err)
+	}
+	if _, ok := changes.(map[string]any); !ok {
+		return nil, fmt.Errorf("%w: expected a JSON object", domain.ErrInvalidKeySpec)
+	}
+	// encoding/json leaves fields alone on null, so the merge is done on
+	// the JSON and decoded into a fresh spec
+	var current any
+	if err := decodeNumbers(marshalJSON(key.KeySpec), &current); err != nil {
+		return nil, fmt.Errorf("failed to read key spec: %w", err)
+	}
+	var spec domain.KeySpec
+	dec := json.NewDecoder(bytes.NewReader(marshalJSON(mergePatch(current, changes))))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidKeySpec, err)
+	}
+	if err := m.validate(spec); err != nil {
+		return nil, err
+	}
+	key.KeySpec = spec
+	return key, m.save(ctx, key)
+}
+
+func decodeNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// mergePatch applies a JSON merge patch to target.
+func mergePatch(target, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	merged, ok := target.(map[string]any)
+	if !ok {
+		merged = map[string]any{}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = mergePatch(merged[name], value)
+		}
+	}
+	return merged
+}
+
+// Rotate replaces the key's secret, the old one stops working at once.
+func (m *KeyManager) Rotate(ctx context.Context, id string) (*domain.VirtualKey, string, error) {
+	key, err := m.store.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := newSecret()
+	key.Prefix = secret[:len(domain.VirtualKeyPrefix)+4]
+	key.Hash = hashSecret(secret)
+	if err := m.save(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// SetStatus suspends a key, or reactivates it.
+func (m *KeyManager) SetStatus(ctx context.Context, id string, status domain.KeyStatus) (*domain.VirtualKey, error) {
+	if status != domain.KeyActive && status != domain.KeySuspended {
+		return nil, fmt.Errorf("%w: unknown status %q", domain.ErrInvalidKeySpec, status)
+	}
+	key, err := m.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	key.Status = status
+	return key, m.save(ctx, key)
+}
+
+func (m *KeyManager) Delete(ctx context.Context, id string) error {
+	return m.store.Delete(ctx, id)
+}
+
+// Authenticate returns the active key a secret belongs to.
+func (m *KeyManager) Authenticate(ctx context.Context, secret string) (*domain.VirtualKey, error) {
+	key, err := m.store.FindByHash(ctx, hashSecret(secret))
+	if errors.Is(err, domain.ErrKeyNotFound) {
+		return nil, domain.ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Status == domain.KeySuspended {
+		return nil, domain.ErrKeySuspended
+	}
+	if key.Expired(m.now()) {
+		return nil, domain.ErrKeyExpired
+	}
+	return key, nil
+}
+
+// admit checks a request against its key's models, budget and per-minute
+// limits, and counts it.
+func (m *KeyManager) admit(md *domain.RequestMetadata) error {
+	key := md.Key
+	if md.Model != "" && !key.Allows(md.Model) {
+		return fmt.Errorf("%w: %q is not available to this key", domain.ErrModelNotAllowed, md.Model)
+	}
+	if key.BudgetUSD > 0 && key.SpentUSD >= key.BudgetUSD {
+		return fmt.Errorf("%w: the key has spent its %.2f USD", domain.ErrBudgetExceeded, key.BudgetUSD)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.window(key.ID)
+	if limit := key.Limits.RequestsPerMinute; limit > 0 && w.requests >= limit {
+		return fmt.Errorf("%w: %d requests per minute", domain.ErrRateLimited, limit)
+	}
+	if limit := key.Limits.TokensPerMinute; limit > 0 && w.tokens >= limit {
+		return fmt.Errorf("%w: %d tokens per minute", domain.ErrRateLimited, limit)
+	}
+	w.requests++
+	return nil
+}
+
+// charge counts the tokens and cost of a call against its key.
+func (m *KeyManager) charge(md *domain.RequestMetadata, usage *domain.Usage, cost float64) {
+	if usage != nil {
+		m.mu.Lock()
+		m.window(md.Key.ID).tokens += usage.TotalTokens
+		m.mu.Unlock()
+	}
+	if cost > 0 {
+		if err := m.store.AddSpend(context.Background(), md.Key.ID, cost); err != nil {
+			log.Printf("Spend of key %s not recorded: %v", md.Key.ID, err)
+		}
+	}
+}
+
+// window returns the key's use in the minute since its window opened,
+// m.mu held.
+func (m *KeyManager) window(id string) *keyWindow {
+	now := m.now()
+	w, ok := m.windows[id]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &keyWindow{start: now}
+		m.windows[id] = w
+	}
+	return w
+}
+
+func (m *KeyManager) save(ctx context.Context, key *domain.VirtualKey) error {
+	key.UpdatedAt = m.now().UTC()
+	return m.store.Update(ctx, key)
+}
+
+func (m *KeyManager) validate(spec domain.KeySpec) error {
+	switch {
+	case spec.Owner == "":
+		return fmt.Errorf("%w: owner is required", domain.ErrInvalidKeySpec)
+	case spec.BudgetUSD < 0:
+		return fmt.Errorf("%w: budget_usd can't be negative", domain.ErrInvalidKeySpec)
+	case spec.Limits.RequestsPerMinute < 0 || spec.Limits.TokensPerMinute < 0 || spec.Limits.MaxTokensPerRequest < 0:
+		return fmt.Errorf("%w: limits can't be negative", domain.ErrInvalidKeySpec)
+	}
+	for _, pattern := range spec.Models {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: bad model pattern %q", domain.ErrInvalidKeySpec, pattern)
+		}
+	}
+	return nil
+}
+
+func newSecret() string {
+	return domain.VirtualKeyPrefix + randomHex(24)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,279 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// WithUsage records the token usage and cost of every upstream call.
+func (s *BaldrService) WithUsage(usage ports.UsagePort, pricing map[string]domain.ModelPrice) *BaldrService {
+	s.usage = usage
+	if pricing != nil {
+		s.pricing = pricing
+	}
+	return s
+}
+
+// metering is what an upstream call took and cost.
+type metering struct {
+	latency time.Duration
+	usage   *domain.Usage
+	failed  bool
+}
+
+// metered reports whether anything keeps track of the request's upstream
+// calls.
+func (s *BaldrService) metered(md *domain.RequestMetadata, variant *Variant) bool {
+	return variant != nil || s.usage != nil || (s.keys != nil && md.Key != nil)
+}
+
+// recordCall reports an upstream call to the usage ledger, to the caller's
+// virtual key and, for experiments, to the variant's stats.
+func (s *BaldrService) recordCall(md *domain.RequestMetadata, experiment bool, m metering) {
+	cost := 0.0
+	if m.usage != nil {
+		cost = s.pricing[md.Model].Cost(*m.usage)
+	}
+	if experiment {
+		s.experimentStats.Record(domain.ExperimentOutcome{
+			RequestID:  md.RequestID,
+			Experiment: md.Experiment,
+			Variant:    md.Variant,
+			Model:      md.Model,
+			Latency:    m.latency,
+			Usage:      m.usage,
+			CostUSD:    cost,
+			Failed:     m.failed,
+		})
+	}
+	if s.keys != nil && md.Key != nil {
+		s.keys.charge(md, m.usage, cost)
+	}
+	if s.usage != nil {
+		record := domain.UsageRecord{
+			Time:      time.Now().UTC(),
+			RequestID: md.RequestID,
+			KeyID:     md.KeyID,
+			Team:      md.Team,
+			Endpoint:  md.Endpoint,
+			Model:     md.Model,
+			Provider:  md.Provider,
+			CostUSD:   cost,
+			Latency:   m.latency,
+			Failed:    m.failed,
+		}
+		if record.Endpoint == "" {
+			record.Endpoint = domain.EndpointChat
+		}
+		if record.Provider == "" {
+			record.Provider = DefaultProvider
+		}
+		if m.usage != nil {
+			record.Usage = *m.usage
+		}
+		s.usage.Record(record)
+	}
+}
+
+// requestStreamUsage asks the upstream to end a streamed chat or
+// completions response with its token usage, which it only reports when
+// asked. It returns whether the request streams, and whether the usage was
+// asked for on the client's behalf, so the client doesn't get the chunk.
+func requestStreamUsage(endpoint domain.Endpoint, payload []byte) ([]byte, bool, bool) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var root map[string]any
+	if dec.Decode(&root) != nil {
+		return payload, false, false
+	}
+	if streamed, _ := root["stream"].(bool); !streamed {
+		return payload, false, false
+	}
+	if !endpoint.IsChat() && endpoint != domain.EndpointCompletions {
+		return payload, true, false // Responses API streams always report it
+	}
+	options, _ := root["stream_options"].(map[string]any)
+	if options["include_usage"] == true {
+		return payload, true, false
+	}
+	options = maps.Clone(options)
+	if options == nil {
+		options = make(map[string]any)
+	}
+	options["include_usage"] = true
+	asked, err := setField(payload, "stream_options", options)
+	if err != nil {
+		return payload, true, false
+	}
+	return asked, true, true
+}
+
+// meteredStream meters a response once it has been read or abandoned. It
+// keeps the tail of the response, where streams report their token usage,
+// and estimates the usage of streams that don't report it.
+type meteredStream struct {
+	io.ReadCloser
+	start    time.Time
+	tail     bytes.Buffer
+	estimate *streamEstimate // nil for responses that aren't streamed
+	done     bool
+	finish   func(metering)
+}
+
+const meteredTailBytes = 64 << 10
+
+func newMeteredStream(source io.ReadCloser, start time.Time, estimate *streamEstimate, finish func(metering)) *meteredStream {
+	return &meteredStream{ReadCloser: source, start: start, estimate: estimate, finish: finish}
+}
+
+func (m *meteredStream) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	if n > 0 {
+		m.tail.Write(p[:n])
+		if m.tail.Len() > 2*meteredTailBytes {
+			m.tail.Next(m.tail.Len() - meteredTailBytes)
+		}
+		if m.estimate != nil {
+			m.estimate.write(p[:n])
+		}
+	}
+	if err != nil {
+		m.end(err != io.EOF)
+	}
+	return n, err
+}
+
+func (m *meteredStream) Close() error {
+	m.end(false)
+	return m.ReadCloser.Close()
+}
+
+func (m *meteredStream) end(failed bool) {
+	if m.done {
+		return
+	}
+	m.done = true
+	_, usage := parseCompletion(m.tail.Bytes())
+	if usage == nil && m.estimate != nil {
+		usage = m.estimate.usage()
+	}
+	m.finish(metering{latency: time.Since(m.start), usage: usage, failed: failed})
+}
+
+// streamEstimate stands in for the usage of a stream whose upstream doesn't
+// report it: the prompt's estimate, plus the estimate of the text streamed.
+type streamEstimate struct {
+	prompt    int
+	estimator tokenEstimator
+	line      []byte // Start of a line not yet complete
+	output    int
+}
+
+func newStreamEstimate(md *domain.RequestMetadata, payload []byte) *streamEstimate {
+	prompt := md.PromptTokens
+	if prompt == 0 {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.UseNumber()
+		var root map[string]any
+		dec.Decode(&root)
+		prompt = estimatePrompt(md.Model, md.Endpoint, root).total
+	}
+	return &streamEstimate{prompt: prompt, estimator: newTokenEstimator(md.Model)}
+}
+
+func (e *streamEstimate) write(p []byte) {
+	e.line = append(e.line, p...)
+	for {
+		i := bytes.IndexByte(e.line, '\n')
+		if i < 0 {
+			return
+		}
+		if data, ok := bytes.CutPrefix(e.line[:i], []byte("data:")); ok {
+			text, _ := parseCompletion(data)
+			e.output += e.estimator.text(text)
+		}
+		e.line = e.line[i+1:]
+	}
+}
+
+func (e *streamEstimate) usage() *domain.Usage {
+	return &domain.Usage{PromptTokens: e.prompt, CompletionTokens: e.output, TotalTokens: e.prompt + e.output}
+}
+
+// usageFilter hides from the client the usage chunk the service asked a
+// stream for: the last one, with usage and no choices. Events are handed
+// out whole, as soon as they are complete.
+type usageFilter struct {
+	io.ReadCloser
+	partial []byte // Start of an event not yet complete
+	out     []byte // Events to hand out
+	err     error
+}
+
+func newUsageFilter(source io.ReadCloser) *usageFilter {
+	return &usageFilter{ReadCloser: source}
+}
+
+func (f *usageFilter) Read(p []byte) (int, error) {
+	for len(f.out) == 0 {
+		if f.err != nil {
+			if len(f.partial) == 0 {
+				return 0, f.err
+			}
+			f.out, f.partial = f.partial, nil
+			break
+		}
+		var n int
+		n, f.err = f.ReadCloser.Read(p)
+		f.partial = append(f.partial, p[:n]...)
+		for {
+			end := eventEnd(f.partial)
+			if end < 0 {
+				break
+			}
+			if event := f.partial[:end]; !isUsageChunk(event) {
+				f.out = append(f.out, event...)
+			}
+			f.partial = f.partial[end:]
+		}
+	}
+	n := copy(p, f.out)
+	f.out = f.out[n:]
+	return n, nil
+}
+
+// eventEnd is the end of the first server-sent event in b, -1 when it isn't
+// complete yet.
+func eventEnd(b []byte) int {
+	end := -1
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		end = i + 2
+	}
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 && (end < 0 || i+4 < end) {
+		end = i + 4
+	}
+	return end
+}
+
+// isUsageChunk tells the chunk include_usage adds: no choices, and a usage.
+// The event is decoded, since upstreams space their JSON as they please.
+func isUsageChunk(event []byte) bool {
+	if !bytes.Contains(event, []byte(`"usage"`)) {
+		return false
+	}
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(event), []byte("data:"))
+	if !ok {
+		return false
+	}
+	var chunk struct {
+		Choices []json.RawMessage `json:"choices"`
+		Usage   *domain.Usage     `json:"usage"`
+	}
+	return json.Unmarshal(data, &chunk) == nil && len(chunk.Choices) == 0 && chunk.Usage != nil
+}
@@ -0,0 +1,377 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// DefaultProvider names the service's main upstream in the model listing.
+const DefaultProvider = "default"
+
+// AnyKey is the model access entry of keys that have none of their own.
+const AnyKey = "*"
+
+// ModelProvider is an upstream besides the default one, whose models are
+// listed and served by the proxy.
+type ModelProvider struct {
+	Name string
+	// LLM serves the provider's models, and lists them when it implements
+	// ports.ModelsPort
+	LLM ports.LLMPort
+}
+
+// ModelAlias is a name of our own for an upstream model.
+type ModelAlias struct {
+	Name     string // Name clients ask for
+	Model    string // Model sent upstream
+	Provider string // Empty for the default upstream
+}
+
+// ModelCatalogConfig describes the models the proxy offers.
+type ModelCatalogConfig struct {
+	Providers []ModelProvider
+	Aliases   []ModelAlias
+	// Metadata by model, shown in the listing
+	Metadata map[string]domain.ModelMetadata
+	// Access lists the models each key may use, as path.Match patterns
+	// over the names clients ask for. Key IDs are fingerprints of whatever
+	// token the client sends, so once access is configured keys without an
+	// entry get the AnyKey entry's models, or none. Virtual keys without an
+	// entry are held to their own models only.
+	Access map[string][]string
+	// Pricing by model, shown in the listing
+	Pricing map[string]domain.ModelPrice
+	// Refresh interval of the upstream listings, 0 means 5 minutes
+	Refresh time.Duration
+}
+
+type modelCatalog struct {
+	config    ModelCatalogConfig
+	providers map[string]ports.LLMPort // Including the default upstream
+	aliases   map[string]ModelAlias
+
+	mu       sync.Mutex
+	listings map[string]*providerListing
+	// refreshed is closed when the running refresh ends, nil when none runs
+	refreshed chan struct{}
+}
+
+// providerListing is the last model list a provider returned.
+type providerListing struct {
+	models  []domain.ModelInfo
+	fetched time.Time
+}
+
+// WithModelCatalog lists the models of every provider, plus aliases and
+// experiments, at the models endpoint, filtered to what the calling key may
+// use. Requests for an alias, or for a model listed by another provider,
+// are routed to its provider, and requests for models the key may not use
+// are refused.
+func (s *BaldrService) WithModelCatalog(config ModelCatalogConfig) *BaldrService {
+	if config.Refresh <= 0 {
+		config.Refresh = 5 * time.Minute
+	}
+	c := &modelCatalog{
+		config:    config,
+		providers: map[string]ports.LLMPort{DefaultProvider: s.llm},
+		aliases:   make(map[string]ModelAlias, len(config.Aliases)),
+		listings:  make(map[string]*providerListing),
+	}
+	for _, p := range config.Providers {
+		c.providers[p.Name] = p.LLM
+	}
+	for _, a := range config.Aliases {
+		c.aliases[a.Name] = a
+	}
+	s.catalog = c
+	if len(config.Providers) > 0 {
+		// Requests are routed by the listings, which shouldn't wait for
+		// the first client to ask for them
+		c.mu.Lock()
+		c.startRefresh()
+		c.mu.Unlock()
+	}
+	return s
+}
+
+// Models lists the models the caller may use, or passes the upstream's list
+// through when the service has no model catalog.
+func (s *BaldrService) Models(ctx context.Context) (io.ReadCloser, error) {
+	md := domain.RequestMetadataFrom(ctx)
+	md.Endpoint = domain.EndpointModels
+
+	start := time.Now()
+	var models io.ReadCloser
+	var err error
+	if s.catalog != nil {
+		var list []domain.ModelInfo
+		allowed := func(model string) bool {
+			return s.catalog.allowed(md, model) && (md.Key == nil || md.Key.Allows(model))
+		}
+		if list, err = s.catalog.list(ctx, allowed, s.experimentModels()); err == nil {
+			models = io.NopCloser(bytes.NewReader(marshalJSON(map[string]any{"object": "list", "data": list})))
+		}
+	} else if lister, ok := s.llm.(ports.ModelsPort); ok {
+		models, err = lister.Models(ctx)
+	} else {
+		err = fmt.Errorf("upstream llm can't list its models")
+	}
+	if s.usage != nil {
+		s.recordCall(md, false, metering{latency: time.Since(start), failed: err != nil})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upstream llm error: %w", err)
+	}
+	return models, nil
+}
+
+func (s *BaldrService) experimentModels() []string {
+	models := make([]string, 0, len(s.experiments))
+	for model := range s.experiments {
+		models = append(models, model)
+	}
+	return models
+}
+
+// routeModel checks the caller may use the model it asks for, resolves
+// aliases, and returns the upstream serving the model, nil for the default.
+func (s *BaldrService) routeModel(md *domain.RequestMetadata, payload []byte) ([]byte, ports.LLMPort, error) {
+	c := s.catalog
+	if c == nil || md.Model == "" {
+		return payload, nil, nil
+	}
+	if !c.allowed(md, md.Model) {
+		return nil, nil, fmt.Errorf("%w: %q is not available to this key", domain.ErrModelNotAllowed, md.Model)
+	}
+	if alias, ok := c.aliases[md.Model]; ok {
+		rewritten, err := setModel(payload, alias.Model)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve model alias: %w", err)
+		}
+		md.Model = alias.Model
+		md.Provider = cmp.Or(alias.Provider, DefaultProvider)
+		return rewritten, c.provider(alias.Provider), nil
+	}
+	md.Provider = cmp.Or(c.owner(md.Model), DefaultProvider)
+	return payload, c.provider(md.Provider), nil
+}
+
+// provider returns the upstream of a provider, nil for the default one.
+func (c *modelCatalog) provider(name string) ports.LLMPort {
+	if name == "" || name == DefaultProvider {
+		return nil
+	}
+	return c.providers[name]
+}
+
+// owner is the first provider listing the model. Stale listings are
+// refreshed in the background, requests never wait for them.
+func (c *modelCatalog) owner(model string) string {
+	if len(c.config.Providers) == 0 {
+		return DefaultProvider
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stale() {
+		c.startRefresh()
+	}
+	for _, name := range c.order() {
+		listing, ok := c.listings[name]
+		if ok && slices.ContainsFunc(listing.models, func(m domain.ModelInfo) bool { return m.ID == model }) {
+			return name
+		}
+	}
+	return ""
+}
+
+// stale reports whether the listings are missing or any is due for a
+// refresh. c.mu held.
+func (c *modelCatalog) stale() bool {
+	if len(c.listings) == 0 {
+		return true
+	}
+	for _, listing := range c.listings {
+		if time.Since(listing.fetched) > c.config.Refresh {
+			return true
+		}
+	}
+	return false
+}
+
+// startRefresh lists the providers in the background, unless a refresh is
+// already running, and returns the channel closed when it ends. c.mu held.
+func (c *modelCatalog) startRefresh() <-chan struct{} {
+	if c.refreshed == nil {
+		c.refreshed = make(chan struct{})
+		go c.refresh(c.refreshed)
+	}
+	return c.refreshed
+}
+
+// allowed fails closed: with access configured, unlisted keys only get what
+// the AnyKey entry allows, unless the proxy authenticated them.
+func (c *modelCatalog) allowed(md *domain.RequestMetadata, model string) bool {
+	if len(c.config.Access) == 0 {
+		return true
+	}
+	patterns, ok := c.config.Access[md.KeyID]
+	if !ok {
+		if md.Key != nil {
+			return true
+		}
+		patterns = c.config.Access[AnyKey]
+	}
+	return matchesAny(patterns, model)
+}
+
+// order is the default provider first, then the others as configured.
+func (c *modelCatalog) order() []string {
+	names := []string{DefaultProvider}
+	for _, p := range c.config.Providers {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// refresh lists the providers whose listing is missing or stale, then
+// closes done. A provider that fails keeps its previous listing.
+func (c *modelCatalog) refresh(done chan struct{}) {
+	defer func() {
+		c.mu.Lock()
+		c.refreshed = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, name := range c.order() {
+		c.mu.Lock()
+		listing, ok := c.listings[name]
+		fresh := ok && time.Since(listing.fetched) <= c.config.Refresh
+		c.mu.Unlock()
+		lister, canList := c.providers[name].(ports.ModelsPort)
+		if fresh || !canList {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			models, err := fetchModels(ctx, lister, name)
+			if err != nil {
+				log.Printf("Models of provider %s not refreshed: %v", name, err)
+				return
+			}
+			c.mu.Lock()
+			c.listings[name] = &providerListing{models: models, fetched: time.Now()}
+			c.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func fetchModels(ctx context.Context, lister ports.ModelsPort, provider string) ([]domain.ModelInfo, error) {
+	stream, err := lister.Models(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var list struct {
+		Data []domain.ModelInfo `json:"data"`
+	}
+	if err := json.NewDecoder(stream).Decode(&list); err != nil {
+		return nil, fmt.Errorf("unreadable model list: %w", err)
+	}
+	for i := range list.Data {
+		list.Data[i].Provider = provider
+		list.Data[i].Object = "model"
+	}
+	return list.Data, nil
+}
+
+// list returns the models of every provider, then the aliases and the
+// experiments' logical models, each once and only when allowed. Stale
+// listings are served while they are refreshed in the background; only
+// the first listing is waited for.
+func (c *modelCatalog) list(ctx context.Context, allowed func(model string) bool, experiments []string) ([]domain.ModelInfo, error) {
+	c.mu.Lock()
+	if c.stale() {
+		refreshed := c.startRefresh()
+		if len(c.listings) == 0 {
+			c.mu.Unlock()
+			select {
+			case <-refreshed:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			c.mu.Lock()
+		}
+	}
+	var models []domain.ModelInfo
+	for _, name := range c.order() {
+		if listing, ok := c.listings[name]; ok {
+			models = append(models, listing.models...)
+		}
+	}
+	fetched := len(c.listings) > 0
+	c.mu.Unlock()
+	if !fetched {
+		return nil, fmt.Errorf("no provider could list its models")
+	}
+
+	for _, a := range c.config.Aliases {
+		models = append(models, domain.ModelInfo{
+			ID:       a.Name,
+			Object:   "model",
+			OwnedBy:  "baldr",
+			Provider: cmp.Or(a.Provider, DefaultProvider),
+			AliasOf:  a.Model,
+		})
+	}
+	slices.Sort(experiments)
+	for _, model := range experiments {
+		models = append(models, domain.ModelInfo{ID: model, Object: "model", OwnedBy: "baldr"})
+	}
+
+	seen := make(map[string]bool, len(models))
+	listed := make([]domain.ModelInfo, 0, len(models))
+	for _, m := range models {
+		if seen[m.ID] || !allowed(m.ID) {
+			continue
+		}
+		seen[m.ID] = true
+		c.describe(&m)
+		listed = append(listed, m)
+	}
+	return listed, nil
+}
+
+// describe adds the configured metadata and price of a model, or of the
+// model it is an alias of.
+func (c *modelCatalog) describe(m *domain.ModelInfo) {
+	for _, id := range []string{m.ID, m.AliasOf} {
+		if id == "" {
+			continue
+		}
+		if metadata, ok := c.config.Metadata[id]; ok && m.ModelMetadata == (domain.ModelMetadata{}) {
+			m.ModelMetadata = metadata
+		}
+		if price, ok := c.config.Pricing[id]; ok && m.Pricing == nil {
+			m.Pricing = &price
+		}
+	}
+}
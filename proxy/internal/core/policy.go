@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// WithRequestPolicies holds the requests of keys and teams to their policies
+// before the guardrail sees them. Every policy applying to a request is
+// checked, in order, and the first rule broken refuses it.
+func (s *BaldrService) WithRequestPolicies(policies []domain.RequestPolicy) *BaldrService {
+	s.policies = policies
+	return s
+}
+
+// checkPolicies returns the first rule of the caller's policies the request
+// breaks, as a *domain.PolicyViolation. Requests without a max_tokens get
+// the lowest cap of their policies, or their output would be unbounded.
+func (s *BaldrService) checkPolicies(md *domain.RequestMetadata, payload []byte) ([]byte, error) {
+	var applied []*domain.RequestPolicy
+	for i := range s.policies {
+		if policyApplies(&s.policies[i], md) {
+			applied = append(applied, &s.policies[i])
+		}
+	}
+	if len(applied) == 0 {
+		return payload, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var root map[string]any
+	if err := dec.Decode(&root); err != nil || root == nil {
+		return nil, fmt.Errorf("failed to read request for its policies: expected a JSON object")
+	}
+	request := newPolicyRequest(md, root)
+	maxTokens := 0
+	for _, policy := range applied {
+		if v := request.check(policy); v != nil {
+			v.Policy = policy.Name
+			return nil, v
+		}
+		if policy.MaxTokens > 0 && (maxTokens == 0 || policy.MaxTokens < maxTokens) {
+			maxTokens = policy.MaxTokens
+		}
+	}
+	if _, asked := root[request.tokensField]; maxTokens == 0 || request.tokensField == "" || asked {
+		return payload, nil
+	}
+	capped, err := setField(payload, request.tokensField, maxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %s: %w", request.tokensField, err)
+	}
+	return capped, nil
+}
+
+// policyApplies matches teams only through virtual keys: without one the
+// team is just a header the client chose.
+func policyApplies(policy *domain.RequestPolicy, md *domain.RequestMetadata) bool {
+	if len(policy.Keys) == 0 && len(policy.Teams) == 0 {
+		return true
+	}
+	return (md.KeyID != "" && slices.Contains(policy.Keys, md.KeyID)) ||
+		(md.Key != nil && md.Key.Team != "" && slices.Contains(policy.Teams, md.Key.Team))
+}
+
+// policyRequest is what policies look at in a request, whatever its
+// endpoint.
+type policyRequest struct {
+	model       string
+	maxTokens   int
+	tokensField string
+	temperature *float64
+	tools       []string // Function names, or types for built-in tools
+	images      bool
+	stream      bool
+}
+
+func newPolicyRequest(md *domain.RequestMetadata, root map[string]any) *policyRequest {
+	r := &policyRequest{model: md.Model, tokensField: maxTokensField(md.Endpoint, root)}
+	if r.tokensField != "" {
+		r.maxTokens, _ = intField(root, r.tokensField)
+	}
+	if n, ok := root["temperature"].(json.Number); ok {
+		if t, err := n.Float64(); err == nil {
+			r.temperature = &t
+		}
+	}
+	r.stream, _ = root["stream"].(bool)
+
+	for _, field := range []string{"tools", "functions"} {
+		tools, _ := root[field].([]any)
+		for _, tool := range tools {
+			r.tools = append(r.tools, toolName(tool))
+		}
+	}
+	if md.Endpoint.IsChat() {
+		r.images = hasImage(root["messages"])
+	} else {
+		r.images = hasImage(root["input"])
+	}
+	return r
+}
+
+// toolName is a function's name, from a chat tool, a legacy function or a
+// Responses API tool, or the type of a built-in tool.
+func toolName(tool any) string {
+	t, _ := tool.(map[string]any)
+	if fn, ok := t["function"].(map[string]any); ok {
+		t = fn
+	}
+	if name, ok := t["name"].(string); ok {
+		return name
+	}
+	kind, _ := t["type"].(string)
+	return kind
+}
+
+// hasImage reports whether a JSON value holds an image content part.
+func hasImage(v any) bool {
+	switch v := v.(type) {
+	case []any:
+		return slices.ContainsFunc(v, hasImage)
+	case map[string]any:
+		if v["type"] == "image_url" || v["type"] == "input_image" {
+			return true
+		}
+		for _, item := range v {
+			if hasImage(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check returns the first rule of the policy the request breaks, nil when
+// it follows them all.
+func (r *policyRequest) check(p *domain.RequestPolicy) *domain.PolicyViolation {
+	if len(p.Models) > 0 && r.model != "" && !matchesAny(p.Models, r.model) {
+		return &domain.PolicyViolation{Rule: "models", Param: "model", Reason: fmt.Sprintf("model %q is not allowed", r.model)}
+	}
+	if p.MaxTokens > 0 && r.maxTokens > p.MaxTokens {
+		return &domain.PolicyViolation{Rule: "max_tokens", Param: r.tokensField, Reason: fmt.Sprintf("%s is %d, at most %d is allowed", r.tokensField, r.maxTokens, p.MaxTokens)}
+	}
+	if p.Temperature != nil && r.temperature != nil && !p.Temperature.Contains(*r.temperature) {
+		return &domain.PolicyViolation{Rule: "temperature", Param: "temperature", Reason: fmt.Sprintf("temperature %g is out of the allowed range", *r.temperature)}
+	}
+	if p.Tools != nil && !*p.Tools && len(r.tools) > 0 {
+		return &domain.PolicyViolation{Rule: "tools", Param: "tools", Reason: "tools are not allowed"}
+	}
+	if len(p.AllowedTools) > 0 {
+		for _, tool := range r.tools {
+			if !matchesAny(p.AllowedTools, tool) {
+				return &domain.PolicyViolation{Rule: "allowed_tools", Param: "tools", Reason: fmt.Sprintf("tool %q is not allowed", tool)}
+			}
+		}
+	}
+	if p.Images != nil && !*p.Images && r.images {
+		return &domain.PolicyViolation{Rule: "images", Reason: "image inputs are not allowed"}
+	}
+	if p.Stream != nil && !*p.Stream && r.stream {
+		return &domain.PolicyViolation{Rule: "stream", Param: "stream", Reason: "streaming is not allowed"}
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// WithExpressionPolicies lets a policy engine allow, deny, route or
+// transform requests once the guardrail has judged them, knowing its
+// scores. What transforms add isn't checked by the guardrail. Engine errors
+// fail closed.
+func (s *BaldrService) WithExpressionPolicies(engine ports.PolicyEnginePort) *BaldrService {
+	s.policyEngine = engine
+	return s
+}
+
+// applyExpressionPolicies returns the request as the policy engine leaves
+// it, and whether it was routed to another model, which md then holds.
+func (s *BaldrService) applyExpressionPolicies(ctx context.Context, md *domain.RequestMetadata, verdict *domain.GuardrailResponse, payload []byte) ([]byte, bool, error) {
+	var body map[string]any
+	if err := json.Unmarshal(payload, &body); err != nil || body == nil {
+		return nil, false, fmt.Errorf("failed to read request for its expression policies: expected a JSON object")
+	}
+	promptTokens := md.PromptTokens
+	if promptTokens == 0 {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.UseNumber()
+		var root map[string]any
+		dec.Decode(&root)
+		promptTokens = estimatePrompt(md.Model, md.Endpoint, root).total
+	}
+
+	decision, err := s.policyEngine.Evaluate(ctx, domain.PolicyInput{
+		Key:             md.Key,
+		KeyID:           md.KeyID,
+		User:            md.User,
+		Model:           md.Model,
+		Endpoint:        md.Endpoint,
+		Provider:        md.Provider,
+		PromptTokens:    promptTokens,
+		GuardrailAction: verdict.EffectiveAction(),
+		Violations:      verdict.Violations,
+		Body:            body,
+		Time:            time.Now(),
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("expression policies failed (fail-closed): %w", err)
+	}
+	if decision.Denied != "" {
+		return nil, false, &domain.PolicyViolation{Policy: decision.Denied, Rule: "expression", Reason: cmp.Or(decision.Reason, "denied")}
+	}
+	if len(decision.Set) > 0 {
+		if payload, err = setFields(payload, decision.Set); err != nil {
+			return nil, false, fmt.Errorf("failed to transform request: %w", err)
+		}
+	}
+	if decision.Model == "" || decision.Model == md.Model {
+		return payload, false, nil
+	}
+	if payload, err = setModel(payload, decision.Model); err != nil {
+		return nil, false, fmt.Errorf("failed to route request: %w", err)
+	}
+	md.Model = decision.Model
+	return payload, true, nil
+}
+
+// policiesKeepHit evaluates the expression policies on a request the
+// response cache could answer, with the verdict of the request the cached
+// response answered. A denial refuses the request, and a route or transform
+// makes the hit a miss, as the cached answer is to another request.
+func (s *BaldrService) policiesKeepHit(ctx context.Context, md *domain.RequestMetadata, cached *domain.CachedResponse, payload []byte) (bool, error) {
+	if cached.Verdict == nil {
+		return false, nil
+	}
+	probe := *md
+	judged, rerouted, err := s.applyExpressionPolicies(ctx, &probe, cached.Verdict, payload)
+	if err != nil {
+		return false, err
+	}
+	return !rerouted && bytes.Equal(judged, payload), nil
+}
@@ -0,0 +1,21 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// EmbeddingsPort turns text into a vector through an embeddings provider.
+type EmbeddingsPort interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// VectorIndexPort finds stored responses by embedding similarity. Entries
+// live in scopes that are never searched across.
+type VectorIndexPort interface {
+	// Search returns the most similar response in scope, if its cosine
+	// similarity is at least minSimilarity.
+	Search(scope string, vector []float32, minSimilarity float64) (*domain.CachedResponse, float64, bool)
+	Add(scope string, vector []float32, response *domain.CachedResponse)
+}
@@ -0,0 +1,13 @@
+package ports
+
+import "github.com/simone-trubian/baldr/proxy/internal/core/domain"
+
+// ExperimentStatsPort aggregates experiment outcomes and user feedback per
+// variant.
+type ExperimentStatsPort interface {
+	Record(outcome domain.ExperimentOutcome)
+	// Feedback scores a recorded request from 0 (bad) to 1 (good). A later
+	// score for the same request replaces the earlier one.
+	Feedback(requestID string, score float64) error
+	Results() []domain.VariantStats
+}
@@ -0,0 +1,39 @@
+package ports
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// KeyStorePort keeps virtual keys. Keys are returned as copies, and missing
+// ones as domain.ErrKeyNotFound.
+type KeyStorePort interface {
+	Create(ctx context.Context, key *domain.VirtualKey) error
+	Get(ctx context.Context, id string) (*domain.VirtualKey, error)
+	FindByHash(ctx context.Context, hash string) (*domain.VirtualKey, error)
+	List(ctx context.Context) ([]*domain.VirtualKey, error)
+	Update(ctx context.Context, key *domain.VirtualKey) error
+	Delete(ctx context.Context, id string) error
+	// AddSpend adds to what a key has spent
+	AddSpend(ctx context.Context, id string, usd float64) error
+}
+
+// KeyAuthPort authenticates the secret of a virtual key.
+type KeyAuthPort interface {
+	Authenticate(ctx context.Context, secret string) (*domain.VirtualKey, error)
+}
+
+// KeyAdminPort manages the lifecycle of virtual keys. Secrets are only
+// returned by Create and Rotate.
+type KeyAdminPort interface {
+	Create(ctx context.Context, spec domain.KeySpec) (*domain.VirtualKey, string, error)
+	List(ctx context.Context) ([]*domain.VirtualKey, error)
+	Get(ctx context.Context, id string) (*domain.VirtualKey, error)
+	// Update applies a JSON merge of the key's spec
+	Update(ctx context.Context, id string, patch json.RawMessage) (*domain.VirtualKey, error)
+	Rotate(ctx context.Context, id string) (*domain.VirtualKey, string, error)
+	SetStatus(ctx context.Context, id string, status domain.KeyStatus) (*domain.VirtualKey, error)
+	Delete(ctx context.Context, id string) error
+}
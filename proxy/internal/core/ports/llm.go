@@ -10,3 +10,9 @@ type LLMPort interface {
 	// Returns a stream (io.ReadCloser) to support SSE, or an error.
 	Generate(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error)
 }
+
+// ModelsPort lists the models an upstream serves.
+type ModelsPort interface {
+	// Models returns the upstream's model list, in the OpenAI format.
+	Models(ctx context.Context) (io.ReadCloser, error)
+}
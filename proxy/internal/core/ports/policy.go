@@ -0,0 +1,13 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// PolicyEnginePort decides what happens to a request once the guardrail has
+// judged it: allowed as is, denied, routed to another model or transformed.
+type PolicyEnginePort interface {
+	Evaluate(ctx context.Context, input domain.PolicyInput) (*domain.PolicyDecision, error)
+}
@@ -0,0 +1,9 @@
+package ports
+
+import "github.com/simone-trubian/baldr/proxy/internal/core/domain"
+
+// ResponseCachePort stores complete upstream responses by request key.
+type ResponseCachePort interface {
+	Get(key string) (*domain.CachedResponse, bool)
+	Put(key string, response *domain.CachedResponse)
+}
@@ -0,0 +1,12 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// ShadowStorePort keeps primary and shadow responses for offline comparison.
+type ShadowStorePort interface {
+	Save(ctx context.Context, record *domain.ShadowRecord) error
+}
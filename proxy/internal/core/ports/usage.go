@@ -0,0 +1,12 @@
+package ports
+
+import "github.com/simone-trubian/baldr/proxy/internal/core/domain"
+
+// UsagePort keeps the usage of upstream calls.
+type UsagePort interface {
+	Record(record domain.UsageRecord)
+	// Summary totals usage by endpoint and model
+	Summary() []domain.UsageSummary
+	// Report totals usage over a range, grouped as the query asks
+	Report(query domain.UsageQuery) ([]domain.UsageSummary, error)
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// ResponseCacheConfig decides which requests the response cache may answer.
+type ResponseCacheConfig struct {
+	// Enabled is the default for keys not listed in Keys
+	Enabled bool
+	// Keys turns the cache on or off for individual key IDs
+	Keys map[string]bool
+}
+
+// WithResponseCache answers repeated deterministic requests from cache,
+// skipping both the guardrail and the upstream.
+func (s *BaldrService) WithResponseCache(cache ports.ResponseCachePort, config ResponseCacheConfig) *BaldrService {
+	s.responseCache = cache
+	s.responseCacheConfig = config
+	return s
+}
+
+// responseCacheKey returns the cache key of a request, or false when the
+// request must not be cached.
+func (s *BaldrService) responseCacheKey(md *domain.RequestMetadata, payload []byte) (string, bool) {
+	if s.responseCache == nil {
+		return "", false
+	}
+	body, ok := cacheableBody(md, payload, s.responseCacheConfig.Enabled, s.responseCacheConfig.Keys)
+	if !ok {
+		return "", false
+	}
+
+	// Marshalling a map sorts its keys, so formatting and field order
+	// don't matter
+	normalized, err := json.Marshal(body)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	// Keys never share answers, nor the verdicts behind them, which may
+	// depend on the key's own thresholds and policies
+	h.Write([]byte(md.KeyID))
+	h.Write([]byte{0})
+	h.Write([]byte(md.PolicyID)) // Another policy could have rejected the request
+	h.Write([]byte{0})
+	h.Write([]byte(md.Endpoint))
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// cacheableBody decodes the payload of a request a cache may answer: the
+// cache is switched on for the caller's key and the request is
+// deterministic (temperature 0), unless the client asks for caching
+// explicitly.
+func cacheableBody(md *domain.RequestMetadata, payload []byte, enabled bool, keys map[string]bool) (map[string]any, bool) {
+	if md.ResponseCache == "false" {
+		return nil, false
+	}
+	if on, ok := keys[md.KeyID]; ok {
+		enabled = on
+	}
+	if !enabled {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var body map[string]any
+	if err := dec.Decode(&body); err != nil {
+		return nil, false
+	}
+	if md.ResponseCache != "true" {
+		temperature, ok := body["temperature"].(json.Number)
+		if !ok {
+			return nil, false
+		}
+		if t, err := temperature.Float64(); err != nil || t != 0 {
+			return nil, false
+		}
+	}
+	return body, true
+}
+
+// recordingStream stores the response in the cache once the client has read
+// it to the end. Failed or abandoned streams are never stored.
+type recordingStream struct {
+	io.ReadCloser
+	store    func(*domain.CachedResponse)
+	response domain.CachedResponse
+	stored   bool
+}
+
+func newRecordingStream(source io.ReadCloser, store func(*domain.CachedResponse)) *recordingStream {
+	return &recordingStream{ReadCloser: source, store: store}
+}
+
+func (r *recordingStream) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.response.Chunks = append(r.response.Chunks, bytes.Clone(p[:n]))
+	}
+	if err == io.EOF && !r.stored {
+		r.stored = true
+		r.store(&r.response)
+	}
+	return n, err
+}
+
+// replayStream hands out a cached response one chunk per read, so the
+// handler flushes it with the chunking of the original stream.
+type replayStream struct {
+	chunks [][]byte
+	offset int // Into the first chunk, when the caller's buffer was smaller
+}
+
+func newReplayStream(response *domain.CachedResponse) *replayStream {
+	return &replayStream{chunks: response.Chunks}
+}
+
+func (r *replayStream) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0][r.offset:])
+	r.offset += n
+	if r.offset == len(r.chunks[0]) {
+		r.chunks = r.chunks[1:]
+		r.offset = 0
+	}
+	return n, nil
+}
+
+func (r *replayStream) Close() error { return nil }
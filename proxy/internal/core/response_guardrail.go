@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// WithResponseGuardrail checks the model's answers before clients see them,
+// and before they are cached. The guardrail gets the response body whole,
+// streams included, with the request's metadata in the output direction.
+// It may block or redact the response; other actions fail closed.
+func (s *BaldrService) WithResponseGuardrail(g ports.GuardrailPort) *BaldrService {
+	s.responseGuardrail = g
+	return s
+}
+
+// checkResponse reads the upstream response and returns what the client
+// may see of it.
+func (s *BaldrService) checkResponse(ctx context.Context, md *domain.RequestMetadata, response io.ReadCloser) (io.ReadCloser, error) {
+	body, err := io.ReadAll(response)
+	response.Close()
+	if err != nil {
+		return nil, fmt.Errorf("upstream llm error: %w", err)
+	}
+
+	output := *md
+	output.Direction = domain.DirectionOutput
+	ctx = domain.WithRequestMetadata(ctx, &output)
+	// Placeholders minted in the response must not be restored into it
+	ctx = domain.WithVault(ctx, domain.NewVault())
+	decision, err := s.responseGuardrail.Validate(ctx, body)
+	if err != nil {
+		return nil, fmt.Errorf("response guardrail check failed (fail-closed): %w", err)
+	}
+
+	switch action := decision.EffectiveAction(); action {
+	case domain.ActionAllow, domain.ActionRedact, domain.ActionWarn:
+		if action == domain.ActionWarn {
+			log.Printf("Response guardrail warning: %s %s", decision.Reason, violationCategories(decision.Violations))
+		}
+		if decision.HasSanitizedInput() {
+			body = decision.SanitizedInput
+		} else if action == domain.ActionRedact {
+			return nil, fmt.Errorf("response guardrail asked to redact without a sanitized response (fail-closed)")
+		}
+	case domain.ActionBlock:
+		return nil, fmt.Errorf("response blocked: %s", decision.Reason)
+	default:
+		return nil, fmt.Errorf("response guardrail action %q can't apply to a response (fail-closed)", action)
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// SemanticCacheConfig decides which requests the semantic cache may answer.
+// Eligibility follows the same rules as the exact-match cache.
+type SemanticCacheConfig struct {
+	// Enabled is the default for keys not listed in Keys
+	Enabled bool
+	// Keys turns the cache on or off for individual key IDs
+	Keys map[string]bool
+	// Threshold is the cosine similarity a cached prompt must reach to be
+	// considered the same question. Defaults to 0.95.
+	Threshold float64
+}
+
+// WithSemanticCache answers paraphrases of earlier requests from cache,
+// skipping the upstream. Lookups happen after the guardrail, so the
+// embeddings provider only ever sees sanitized prompts.
+func (s *BaldrService) WithSemanticCache(embeddings ports.EmbeddingsPort, index ports.VectorIndexPort, config SemanticCacheConfig) *BaldrService {
+	if config.Threshold == 0 {
+		config.Threshold = 0.95
+	}
+	s.embeddings = embeddings
+	s.vectorIndex = index
+	s.semanticCacheConfig = config
+	return s
+}
+
+// semanticKey embeds the prompt of a sanitized request and returns the
+// scope to search it in. Any failure just skips the cache.
+func (s *BaldrService) semanticKey(ctx context.Context, md *domain.RequestMetadata, payload, sanitized []byte) (string, []float32, bool) {
+	if s.vectorIndex == nil || !md.Endpoint.IsChat() {
+		return "", nil, false
+	}
+	// Tenants never share answers, and callers we can't tell apart don't
+	// get to share them either. Teams are only trusted from virtual keys,
+	// any client can send the X-Baldr-Team header.
+	tenant := ""
+	switch {
+	case md.Key != nil && md.Key.Team != "":
+		tenant = "team:" + md.Key.Team
+	case md.KeyID != "":
+		tenant = "key:" + md.KeyID
+	default:
+		return "", nil, false
+	}
+	if _, ok := cacheableBody(md, payload, s.semanticCacheConfig.Enabled, s.semanticCacheConfig.Keys); !ok {
+		return "", nil, false
+	}
+
+	prompt, params, ok := splitPrompt(sanitized)
+	if !ok || prompt == "" {
+		return "", nil, false
+	}
+	vector, err := s.embeddings.Embed(ctx, prompt)
+	if err != nil {
+		log.Printf("Semantic cache skipped: %v", err)
+		return "", nil, false
+	}
+
+	// Paraphrases only match under the same model, parameters and policy
+	h := sha256.New()
+	h.Write([]byte(md.PolicyID))
+	h.Write([]byte{0})
+	h.Write(params)
+	return tenant + "/" + hex.EncodeToString(h.Sum(nil)), vector, true
+}
+
+// splitPrompt separates the text of a chat request, which gets embedded,
+// from its other parameters, which must match exactly.
+func splitPrompt(payload []byte) (string, []byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var body map[string]any
+	if err := dec.Decode(&body); err != nil {
+		return "", nil, false
+	}
+
+	var text strings.Builder
+	if messages, ok := body["messages"].([]any); ok {
+		for _, m := range messages {
+			msg, _ := m.(map[string]any)
+			role, _ := msg["role"].(string)
+			switch content := msg["content"].(type) {
+			case string:
+				text.WriteString(role + ": " + content + "\n")
+			case []any:
+				for _, p := range content {
+					part, _ := p.(map[string]any)
+					if t, ok := part["text"].(string); ok {
+						text.WriteString(role + ": " + t + "\n")
+					}
+				}
+			}
+		}
+	}
+	if prompt, ok := body["prompt"].(string); ok {
+		text.WriteString(prompt)
+	}
+	delete(body, "messages")
+	delete(body, "prompt")
+
+	params, err := json.Marshal(body)
+	if err != nil {
+		return "", nil, false
+	}
+	return text.String(), params, true
+}
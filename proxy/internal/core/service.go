@@ -1,17 +1,46 @@
 package core
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"time"
 
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
 	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
 )
 
 type BaldrService struct {
 	guardrail ports.GuardrailPort
 	llm       ports.LLMPort
+
+	responseCache       ports.ResponseCachePort // Optional, see WithResponseCache
+	responseCacheConfig ResponseCacheConfig
+
+	embeddings          ports.EmbeddingsPort // Optional, see WithSemanticCache
+	vectorIndex         ports.VectorIndexPort
+	semanticCacheConfig SemanticCacheConfig
+
+	shadow *shadowMirror // Optional, see WithShadow
+
+	experiments     map[string]*Experiment // By logical model, see WithExperiments
+	experimentStats ports.ExperimentStatsPort
+	pricing         map[string]domain.ModelPrice
+
+	usage ports.UsagePort // Optional, see WithUsage
+
+	catalog *modelCatalog // Optional, see WithModelCatalog
+
+	keys *KeyManager // Optional, see WithVirtualKeys
+
+	tokenLimits *TokenLimitsConfig // Optional, see WithTokenLimits
+
+	policies     []domain.RequestPolicy // Optional, see WithRequestPolicies
+	policyEngine ports.PolicyEnginePort // Optional, see WithExpressionPolicies
+
+	responseGuardrail ports.GuardrailPort // Optional, see WithResponseGuardrail
 }
 
 func NewBaldrService(g ports.GuardrailPort, l ports.LLMPort) *BaldrService {
@@ -23,32 +52,275 @@ func NewBaldrService(g ports.GuardrailPort, l ports.LLMPort) *BaldrService {
 
 // Orchestration method
 func (s *BaldrService) Execute(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+	md := domain.RequestMetadataFrom(ctx)
+	// Virtual keys are held to their models, budget and limits
+	if s.keys != nil && md.Key != nil {
+		if err := s.keys.admit(md); err != nil {
+			return nil, err
+		}
+	}
+	// Policies judge what the client asked for, before aliases resolve
+	payload, err := s.checkPolicies(md, payload)
+	if err != nil {
+		return nil, err
+	}
+	// Aliases resolve to their model, on the provider that serves it
+	payload, routed, err := s.routeModel(md, payload)
+	if err != nil {
+		return nil, err
+	}
+	// Requests for an experimented model ask for their variant from here on
+	payload, variant, err := s.assignVariant(md, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign experiment variant: %w", err)
+	}
+	// Prompts that can't fit are refused before anything is spent on them
+	if payload, err = s.limitTokens(md, payload); err != nil {
+		return nil, err
+	}
+	// 0. Deterministic requests seen before are answered from cache
+	cacheKey, cacheable := s.responseCacheKey(md, payload)
+	var tokenizedHit *domain.CachedResponse
+	if cacheable {
+		cached, ok := s.responseCache.Get(cacheKey)
+		if ok && s.policyEngine != nil {
+			// Policies may judge the request otherwise by now, e.g. after hours
+			if ok, err = s.policiesKeepHit(ctx, md, cached, payload); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case ok && cached.Tokenized:
+			// Replayed once the guardrail has given back this request's tokens
+			tokenizedHit = cached
+		case ok:
+			md.CacheStatus = domain.CacheHit
+			return newReplayStream(cached), nil
+		default:
+			md.CacheStatus = domain.CacheMiss
+		}
+	}
+
+	// Placeholders minted by the guardrail for this request end up here
+	vault := domain.NewVault()
+	ctx = domain.WithVault(ctx, vault)
+
+	// Other endpoints are checked through a chat view of their inputs
+	guarded := payload
+	var input *endpointInput
+	if !md.Endpoint.IsChat() {
+		if input, err = newEndpointInput(md.Endpoint, payload); err != nil {
+			return nil, fmt.Errorf("failed to read %s request: %w", md.Endpoint, err)
+		}
+		guarded = input.chatView()
+	}
+
 	// 1. Guardrail Check
-	decision, err := s.guardrail.Validate(ctx, payload)
+	decision, err := s.guardrail.Validate(ctx, guarded)
 	if err != nil {
 		// FAIL CLOSED: Any technical error blocks the request.
 		return nil, fmt.Errorf("guardrail check failed (fail-closed): %w", err)
 	}
+	md.GuardrailAction = decision.EffectiveAction()
 
-	// 2. Policy Enforcement
-	if !decision.Allowed {
-		return nil, fmt.Errorf("blocked: %s", decision.Reason)
+	// 2. Policy Enforcement (block, redact, warn, reroute)
+	finalPayload, err := applyVerdict(decision, guarded)
+	if err != nil {
+		return nil, err
+	}
+	if input != nil {
+		if finalPayload, err = input.apply(finalPayload); err != nil {
+			return nil, fmt.Errorf("guardrail verdict can't be applied to %s (fail-closed): %w", md.Endpoint, err)
+		}
+	}
+	// Cached and remote verdicts carry their placeholders with them
+	if err := vault.Merge(decision.Tokens); err != nil {
+		return nil, fmt.Errorf("guardrail tokens rejected (fail-closed): %w", err)
+	}
+	// Expression policies have the last word, knowing the guardrail's scores
+	rerouted := false
+	if s.policyEngine != nil {
+		if finalPayload, rerouted, err = s.applyExpressionPolicies(ctx, md, decision, finalPayload); err != nil {
+			return nil, err
+		}
+		if rerouted {
+			// A routed request leaves its experiment, for its new provider
+			variant, md.Experiment, md.Variant = nil, "", ""
+			if finalPayload, routed, err = s.routeModel(md, finalPayload); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// 3. PII Redaction / Sanitization Logic
-	// If the Python sidecar redacted data, we MUST use the new payload.
-	finalPayload := payload
-	if !bytes.Equal(decision.SanitizedInput, []byte("null")) {
-		// Assuming SanitizedInput is the full JSON body string.
-		// Convert back to bytes.
-		finalPayload = []byte(decision.SanitizedInput)
+	// 3. Upstream to LLM using finalPayload, unless the request or a
+	// paraphrase of it was answered before
+	var responseStream io.ReadCloser
+	if tokenizedHit != nil {
+		// A routed request is no longer the one the response answered
+		md.CacheStatus = domain.CacheMiss
+		if !rerouted {
+			md.CacheStatus = domain.CacheHit
+			responseStream = newReplayStream(tokenizedHit)
+		}
+	}
+	var scope string
+	var vector []float32
+	semantic := false
+	if responseStream == nil {
+		scope, vector, semantic = s.semanticKey(ctx, md, payload, finalPayload)
+	}
+	if semantic {
+		if cached, similarity, ok := s.vectorIndex.Search(scope, vector, s.semanticCacheConfig.Threshold); ok {
+			log.Printf("Semantic cache hit (similarity %.3f)", similarity)
+			md.CacheStatus = domain.CacheSemanticHit
+			responseStream = newReplayStream(cached)
+		} else {
+			md.CacheStatus = domain.CacheMiss
+		}
+	}
+	if responseStream == nil {
+		llm := s.llm
+		if routed != nil {
+			llm = routed
+		}
+		if variant != nil && variant.LLM != nil {
+			llm = variant.LLM
+			md.Provider = md.Experiment + "/" + md.Variant
+		}
+		// Streams only report their usage when asked to
+		streamed, usageAsked := false, false
+		if s.metered(md, variant) {
+			finalPayload, streamed, usageAsked = requestStreamUsage(md.Endpoint, finalPayload)
+		}
+		start := time.Now()
+		responseStream, err = llm.Generate(ctx, finalPayload, headers)
+		if err != nil {
+			if s.metered(md, variant) {
+				s.recordCall(md, variant != nil, metering{latency: time.Since(start), failed: true})
+			}
+			return nil, fmt.Errorf("upstream llm error: %w", err)
+		}
+		if s.metered(md, variant) {
+			var estimate *streamEstimate
+			if streamed {
+				estimate = newStreamEstimate(md, finalPayload)
+			}
+			responseStream = newMeteredStream(responseStream, start, estimate, func(m metering) {
+				s.recordCall(md, variant != nil, m)
+			})
+			if usageAsked {
+				responseStream = newUsageFilter(responseStream)
+			}
+		}
+		// Answers are judged before they are mirrored or cached
+		if s.responseGuardrail != nil {
+			if responseStream, err = s.checkResponse(ctx, md, responseStream); err != nil {
+				return nil, err
+			}
+		}
+		if s.shadow != nil && input == nil {
+			responseStream = s.shadow.mirror(ctx, md, finalPayload, headers, responseStream, start)
+		}
+		if semantic {
+			// Stored before detokenization: a hit is restored with the
+			// placeholders of the request it answers, not of this one
+			responseStream = newRecordingStream(responseStream, func(response *domain.CachedResponse) {
+				s.vectorIndex.Add(scope, vector, response)
+			})
+		}
 	}
 
-	// 4. Upstream to LLM using finalPayload
-	responseStream, err := s.llm.Generate(ctx, finalPayload, headers)
-	if err != nil {
-		return nil, fmt.Errorf("upstream llm error: %w", err)
+	// Both caches store responses before detokenization, so they never
+	// hold the values behind the placeholders: hits are restored with the
+	// tokens of the request they answer
+	if cacheable && md.CacheStatus != domain.CacheHit {
+		verdict := *decision
+		verdict.Tokens = nil
+		responseStream = newRecordingStream(responseStream, func(response *domain.CachedResponse) {
+			response.Verdict = &verdict
+			response.Tokenized = vault.Len() > 0
+			s.responseCache.Put(cacheKey, response)
+		})
 	}
 
+	// 4. Give the client back the values the upstream never saw
+	if vault.Len() > 0 {
+		responseStream = newDetokenizer(responseStream, vault.Tokens())
+	}
 	return responseStream, nil
 }
+
+// applyVerdict enforces the guardrail's action and returns the payload to
+// send upstream. Verdicts the service can't honour fail closed.
+func applyVerdict(decision *domain.GuardrailResponse, payload []byte) ([]byte, error) {
+	// If the sidecar redacted data, we MUST use the new payload, whatever
+	// the action.
+	finalPayload := payload
+	if decision.HasSanitizedInput() {
+		finalPayload = decision.SanitizedInput
+	}
+
+	switch action := decision.EffectiveAction(); action {
+	case domain.ActionAllow:
+		return finalPayload, nil
+	case domain.ActionBlock:
+		return nil, fmt.Errorf("blocked: %s", decision.Reason)
+	case domain.ActionRedact:
+		if !decision.HasSanitizedInput() {
+			return nil, fmt.Errorf("guardrail asked to redact without a sanitized input (fail-closed)")
+		}
+		return finalPayload, nil
+	case domain.ActionWarn:
+		log.Printf("Guardrail warning: %s %s", decision.Reason, violationCategories(decision.Violations))
+		return finalPayload, nil
+	case domain.ActionReroute:
+		if decision.RerouteModel == "" {
+			return nil, fmt.Errorf("guardrail asked to reroute without a model (fail-closed)")
+		}
+		rerouted, err := setModel(finalPayload, decision.RerouteModel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reroute request: %w", err)
+		}
+		return rerouted, nil
+	default:
+		return nil, fmt.Errorf("unknown guardrail action %q (fail-closed)", action)
+	}
+}
+
+// setModel swaps the model of a chat request, leaving other fields alone.
+func setModel(payload []byte, model string) ([]byte, error) {
+	return setField(payload, "model", model)
+}
+
+// setField sets a top-level field of a request, leaving the others alone.
+func setField(payload []byte, name string, value any) ([]byte, error) {
+	return setFields(payload, map[string]any{name: value})
+}
+
+// setFields sets top-level fields of a request, removing those set to nil.
+func setFields(payload []byte, fields map[string]any) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(body, name)
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		body[name] = encoded
+	}
+	return json.Marshal(body)
+}
+
+func violationCategories(violations []domain.Violation) []string {
+	categories := make([]string, 0, len(violations))
+	for _, v := range violations {
+		categories = append(categories, v.Category)
+	}
+	return categories
+}
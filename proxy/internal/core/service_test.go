@@ -3,10 +3,15 @@ package core_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/simone-trubian/baldr/proxy/internal/core"
 	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
@@ -77,6 +82,66 @@ func TestBaldrService_Sanitization(t *testing.T) {
 		context.Background(), []byte("unsafe prompt"), make(map[string]string))
 }
 
+func TestBaldrService_EnforcesAction(t *testing.T) {
+	original := []byte(`{"model":"gpt-4o","messages":[]}`)
+
+	tests := []struct {
+		name        string
+		verdict     domain.GuardrailResponse
+		wantErr     bool
+		wantPayload string
+	}{
+		{"Legacy allow", domain.GuardrailResponse{Allowed: true}, false, string(original)},
+		{"Legacy block", domain.GuardrailResponse{Allowed: false, Reason: "nope"}, true, ""},
+		{"Block wins over allowed", domain.GuardrailResponse{Allowed: true, Action: domain.ActionBlock}, true, ""},
+		{"Not allowed blocks whatever the action", domain.GuardrailResponse{Allowed: false, Action: domain.ActionAllow}, true, ""},
+		{"Not allowed redact blocks", domain.GuardrailResponse{Allowed: false, Action: domain.ActionRedact, SanitizedInput: []byte(`{"safe":true}`)}, true, ""},
+		{"Redact", domain.GuardrailResponse{Allowed: true, Action: domain.ActionRedact, SanitizedInput: []byte(`{"safe":true}`)}, false, `{"safe":true}`},
+		{"Redact without input", domain.GuardrailResponse{Allowed: true, Action: domain.ActionRedact}, true, ""},
+		{"Warn", domain.GuardrailResponse{Allowed: true, Action: domain.ActionWarn, Violations: []domain.Violation{{Category: "TOXICITY", Score: 0.4}}}, false, string(original)},
+		{"Reroute", domain.GuardrailResponse{Allowed: true, Action: domain.ActionReroute, RerouteModel: "gemini-2.5-flash"}, false, `{"messages":[],"model":"gemini-2.5-flash"}`},
+		{"Reroute without model", domain.GuardrailResponse{Allowed: true, Action: domain.ActionReroute}, true, ""},
+		{"Unknown action", domain.GuardrailResponse{Allowed: true, Action: "shrug"}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guardrail := &TestMockGuardrail{
+				mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+					verdict := tt.verdict
+					return &verdict, nil
+				},
+			}
+			var sent []byte
+			llm := &TestMockLLM{
+				mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+					sent = payload
+					return io.NopCloser(strings.NewReader("ok")), nil
+				},
+			}
+
+			service := core.NewBaldrService(guardrail, llm)
+			_, err := service.Execute(context.Background(), original, make(map[string]string))
+
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected the request to be rejected")
+				}
+				if sent != nil {
+					t.Error("LLM should not be called for a rejected request")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(sent) != tt.wantPayload {
+				t.Errorf("Expected LLM to receive %s, got %s", tt.wantPayload, sent)
+			}
+		})
+	}
+}
+
 /*
 func TestService_ProcessRequest_FailClosed(t *testing.T) {
 	// Define the "Table"
@@ -98,3 +163,1532 @@ func TestService_ProcessRequest_FailClosed(t *testing.T) {
 	}
 }
 */
+
+func TestBaldrService_RestoresTokens(t *testing.T) {
+	// Scenario: The guardrail swapped an email for a placeholder.
+	// Expected: The upstream only sees the placeholder, the client gets the
+	// email back even when the model splits the placeholder across chunks.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{
+				Allowed:        true,
+				Action:         domain.ActionRedact,
+				SanitizedInput: []byte(`{"prompt": "write to <EMAIL_1>"}`),
+				Tokens:         map[string]string{"<EMAIL_1>": "jane@example.com"},
+			}, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		upstream string
+		want     []string
+	}{
+		{
+			name: "Split across SSE chunks",
+			upstream: "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Mail <EM\"}}]}\n\n" +
+				"data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"AIL_1> now\"}}]}\n\n" +
+				"data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\" <b>\"},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n",
+			want: []string{`"content":"Mail "`, `"content":"jane@example.com now"`, `"content":" <b>"`, "data: [DONE]"},
+		},
+		{
+			name: "Unfinished placeholder is flushed before DONE",
+			upstream: "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"bye <EMAIL\"}}]}\n\n" +
+				"data: [DONE]\n\n",
+			want: []string{`"content":"bye "`, `"content":"<EMAIL"`},
+		},
+		{
+			name:     "Plain JSON response",
+			upstream: `{"choices":[{"index":0,"message":{"role":"assistant","content":"Sent to <EMAIL_1> and <EMAIL_1>"}}]}`,
+			want:     []string{`"content":"Sent to jane@example.com and jane@example.com"`},
+		},
+		{
+			name:     "Plain JSON response after whitespace",
+			upstream: "\n  " + `{"choices":[{"index":0,"message":{"role":"assistant","content":"Sent to <EMAIL_1>"}}]}`,
+			want:     []string{`"content":"Sent to jane@example.com"`},
+		},
+		{
+			name: "Split across Responses API deltas",
+			upstream: "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"delta\":\"Mail <EM\"}\n\n" +
+				"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"delta\":\"AIL_1> now\"}\n\n" +
+				"event: response.output_text.done\ndata: {\"type\":\"response.output_text.done\",\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"text\":\"Mail <EMAIL_1> now\"}\n\n",
+			want: []string{`"delta":"Mail "`, `"delta":"jane@example.com now"`, `"text":"Mail jane@example.com now"`},
+		},
+		{
+			name: "Unfinished Responses API placeholder is flushed before done",
+			upstream: "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"delta\":\"bye <EMAIL\"}\n\n" +
+				"event: response.output_text.done\ndata: {\"type\":\"response.output_text.done\",\"item_id\":\"msg_1\",\"output_index\":0,\"content_index\":0,\"text\":\"bye <EMAIL\"}\n\n",
+			want: []string{`"delta":"bye "`, "event: response.output_text.delta\ndata: {\"content_index\":0,\"delta\":\"<EMAIL\"", "\n\nevent: response.output_text.done\ndata: "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamPayload []byte
+			llm := &TestMockLLM{
+				mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+					upstreamPayload = payload
+					return io.NopCloser(strings.NewReader(tt.upstream)), nil
+				},
+			}
+
+			service := core.NewBaldrService(guardrail, llm)
+			stream, err := service.Execute(context.Background(), []byte(`{"prompt": "write to jane@example.com"}`), nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer stream.Close()
+
+			if bytes.Contains(upstreamPayload, []byte("jane@example.com")) {
+				t.Errorf("Raw email reached the upstream: %s", upstreamPayload)
+			}
+			body, _ := io.ReadAll(stream)
+			for _, want := range tt.want {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("Expected response to contain %s, got:\n%s", want, body)
+				}
+			}
+			if strings.Contains(string(body), "EMAIL_1>") {
+				t.Errorf("A placeholder reached the client:\n%s", body)
+			}
+		})
+	}
+}
+
+// mapResponseCache is a ResponseCachePort without eviction
+type mapResponseCache map[string]*domain.CachedResponse
+
+func (c mapResponseCache) Get(key string) (*domain.CachedResponse, bool) {
+	r, ok := c[key]
+	return r, ok
+}
+
+func (c mapResponseCache) Put(key string, response *domain.CachedResponse) {
+	c[key] = response
+}
+
+// chunkedReader returns one chunk per read, like an SSE upstream
+type chunkedReader struct{ chunks []string }
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestBaldrService_ResponseCache(t *testing.T) {
+	// Scenario: The same deterministic request arrives twice.
+	// Expected: The second one is replayed from cache with the original
+	// chunking, without calling the guardrail or the upstream.
+
+	guardrailCalls, llmCalls := 0, 0
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			guardrailCalls++
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	chunks := []string{"data: {\"n\":1}\n\n", "data: {\"n\":2}\n\n", "data: [DONE]\n\n"}
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			llmCalls++
+			return io.NopCloser(&chunkedReader{chunks: append([]string(nil), chunks...)}), nil
+		},
+	}
+	service := core.NewBaldrService(guardrail, llm).WithResponseCache(mapResponseCache{}, core.ResponseCacheConfig{
+		Enabled: true,
+		Keys:    map[string]bool{"key-off": false},
+	})
+
+	execute := func(body, keyID, preference string) (domain.CacheStatus, []string) {
+		md := &domain.RequestMetadata{KeyID: keyID, ResponseCache: preference}
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(body), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer stream.Close()
+		var reads []string
+		buf := make([]byte, 1024)
+		for {
+			n, err := stream.Read(buf)
+			if n > 0 {
+				reads = append(reads, string(buf[:n]))
+			}
+			if err != nil {
+				break
+			}
+		}
+		return md.CacheStatus, reads
+	}
+
+	deterministic := `{"model": "m", "temperature": 0, "messages": [{"role": "user", "content": "hi"}]}`
+	if status, _ := execute(deterministic, "key-a", ""); status != domain.CacheMiss {
+		t.Errorf("Expected a miss on first sight, got %q", status)
+	}
+	// Same request, different formatting and key order
+	status, reads := execute(`{"messages":[{"content":"hi","role":"user"}],"temperature":0,"model":"m"}`, "key-a", "")
+	if status != domain.CacheHit {
+		t.Fatalf("Expected a hit, got %q", status)
+	}
+	if strings.Join(reads, "|") != strings.Join(chunks, "|") {
+		t.Errorf("Expected the original chunking %q, got %q", chunks, reads)
+	}
+	if guardrailCalls != 1 || llmCalls != 1 {
+		t.Errorf("Expected the hit to skip guardrail and upstream, got %d and %d calls", guardrailCalls, llmCalls)
+	}
+	// Another key sending the same body gets its own verdict and answer
+	if status, _ := execute(deterministic, "key-b", ""); status != domain.CacheMiss || guardrailCalls != 2 || llmCalls != 2 {
+		t.Errorf("Expected another key not to share the entry, got %q after %d guardrail calls", status, guardrailCalls)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		keyID      string
+		preference string
+		want       domain.CacheStatus
+	}{
+		{"Sampled request", `{"model": "m", "temperature": 0.7}`, "key-a", "", ""},
+		{"No temperature", `{"model": "m"}`, "key-a", "", ""},
+		{"Client opts in", `{"model": "m", "temperature": 0.7}`, "key-a", "true", domain.CacheMiss},
+		{"Client opts out", deterministic, "key-a", "false", ""},
+		{"Key switched off", deterministic, "key-off", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := execute(tt.body, tt.keyID, tt.preference); status != tt.want {
+				t.Errorf("Expected cache status %q, got %q", tt.want, status)
+			}
+		})
+	}
+}
+
+func TestBaldrService_ResponseCacheKeepsPlaceholders(t *testing.T) {
+	// Scenario: A deterministic request whose email the guardrail swapped
+	// for a placeholder arrives twice.
+	// Expected: The cache holds the answer with the placeholder only. The
+	// hit goes through the guardrail again for the email, not upstream.
+
+	guardrailCalls, llmCalls := 0, 0
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			guardrailCalls++
+			return &domain.GuardrailResponse{
+				Allowed:        true,
+				Action:         domain.ActionRedact,
+				SanitizedInput: bytes.ReplaceAll(payload, []byte("jane@example.com"), []byte("<EMAIL_1>")),
+				Tokens:         map[string]string{"<EMAIL_1>": "jane@example.com"},
+			}, nil
+		},
+	}
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			llmCalls++
+			return io.NopCloser(strings.NewReader(`{"choices":[{"index":0,"message":{"role":"assistant","content":"Sent to <EMAIL_1>"}}]}`)), nil
+		},
+	}
+	cache := mapResponseCache{}
+	service := core.NewBaldrService(guardrail, llm).WithResponseCache(cache, core.ResponseCacheConfig{Enabled: true})
+
+	execute := func() (domain.CacheStatus, string) {
+		md := &domain.RequestMetadata{KeyID: "key-a"}
+		body := `{"model": "m", "temperature": 0, "messages": [{"role": "user", "content": "write to jane@example.com"}]}`
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(body), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer stream.Close()
+		response, _ := io.ReadAll(stream)
+		return md.CacheStatus, string(response)
+	}
+
+	if status, response := execute(); status != domain.CacheMiss || !strings.Contains(response, "Sent to jane@example.com") {
+		t.Fatalf("Expected a restored miss, got %q: %s", status, response)
+	}
+	for _, entry := range cache {
+		for _, chunk := range entry.Chunks {
+			if bytes.Contains(chunk, []byte("jane@example.com")) {
+				t.Errorf("The cache holds the raw email: %s", chunk)
+			}
+		}
+		if len(entry.Verdict.Tokens) != 0 {
+			t.Errorf("The cached verdict holds tokens: %v", entry.Verdict.Tokens)
+		}
+	}
+
+	status, response := execute()
+	if status != domain.CacheHit || !strings.Contains(response, "Sent to jane@example.com") {
+		t.Errorf("Expected a restored hit, got %q: %s", status, response)
+	}
+	if guardrailCalls != 2 || llmCalls != 1 {
+		t.Errorf("Expected the hit to go through the guardrail only, got %d guardrail and %d upstream calls", guardrailCalls, llmCalls)
+	}
+}
+
+// keywordEmbeddings maps prompts to one axis per topic, so paraphrases of
+// the same question get the same vector
+type keywordEmbeddings struct{ prompts []string }
+
+func (e *keywordEmbeddings) Embed(ctx context.Context, text string) ([]float32, error) {
+	e.prompts = append(e.prompts, text)
+	vector := []float32{0, 0, 0.01}
+	if strings.Contains(text, "refund") {
+		vector[0] = 1
+	}
+	if strings.Contains(text, "shipping") {
+		vector[1] = 1
+	}
+	return vector, nil
+}
+
+// exactVectorIndex is a VectorIndexPort matching identical vectors only
+type exactVectorIndex map[string][]struct {
+	vector   []float32
+	response *domain.CachedResponse
+}
+
+func (x exactVectorIndex) Search(scope string, vector []float32, minSimilarity float64) (*domain.CachedResponse, float64, bool) {
+	for _, e := range x[scope] {
+		if slices.Equal(e.vector, vector) {
+			return e.response, 1, true
+		}
+	}
+	return nil, 0, false
+}
+
+func (x exactVectorIndex) Add(scope string, vector []float32, response *domain.CachedResponse) {
+	x[scope] = append(x[scope], struct {
+		vector   []float32
+		response *domain.CachedResponse
+	}{vector, response})
+}
+
+func TestBaldrService_SemanticCache(t *testing.T) {
+	// Scenario: Paraphrases of a support question arrive from two teams.
+	// Expected: Within a team the paraphrase is answered from cache, across
+	// teams it is not, and the embeddings provider never sees raw PII.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			sanitized := strings.ReplaceAll(string(payload), "jane@example.com", "<EMAIL_1>")
+			return &domain.GuardrailResponse{
+				Allowed:        true,
+				SanitizedInput: []byte(sanitized),
+				Tokens:         map[string]string{"<EMAIL_1>": "jane@example.com"},
+			}, nil
+		},
+	}
+	llmCalls := 0
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			llmCalls++
+			return io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"Refunds go to <EMAIL_1>"}}]}`)), nil
+		},
+	}
+	embeddings := &keywordEmbeddings{}
+	service := core.NewBaldrService(guardrail, llm).WithSemanticCache(embeddings, exactVectorIndex{}, core.SemanticCacheConfig{Enabled: true})
+
+	// Teams are the virtual keys' own
+	keys := map[string]*domain.VirtualKey{
+		"support": {ID: "vk-support", KeySpec: domain.KeySpec{Team: "support"}},
+		"sales":   {ID: "vk-sales", KeySpec: domain.KeySpec{Team: "sales"}},
+	}
+	execute := func(team, question string) (domain.CacheStatus, string) {
+		md := &domain.RequestMetadata{Key: keys[team], KeyID: keys[team].ID, Team: team}
+		body := `{"model": "m", "temperature": 0, "messages": [{"role": "user", "content": "` + question + `"}]}`
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(body), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer stream.Close()
+		answer, _ := io.ReadAll(stream)
+		return md.CacheStatus, string(answer)
+	}
+
+	if status, _ := execute("support", "How do I get a refund? I am jane@example.com"); status != domain.CacheMiss {
+		t.Errorf("Expected a miss on first sight, got %q", status)
+	}
+	status, answer := execute("support", "Can I have a refund please, jane@example.com here")
+	if status != domain.CacheSemanticHit || llmCalls != 1 {
+		t.Errorf("Expected the paraphrase to be served from cache, got %q after %d upstream calls", status, llmCalls)
+	}
+	if !strings.Contains(answer, "jane@example.com") {
+		t.Errorf("Expected the hit to be detokenized for this request, got %s", answer)
+	}
+
+	if status, _ := execute("sales", "How do I get a refund?"); status == domain.CacheSemanticHit {
+		t.Error("Expected another team not to see the cached answer")
+	}
+	if status, _ := execute("support", "What are the shipping times?"); status == domain.CacheSemanticHit {
+		t.Error("Expected an unrelated question to miss")
+	}
+	// A plain bearer token claiming the team by header is a tenant of its own
+	md := &domain.RequestMetadata{KeyID: "key-b", Team: "support"}
+	body := `{"model": "m", "temperature": 0, "messages": [{"role": "user", "content": "How do I get a refund?"}]}`
+	if _, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(body), nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if md.CacheStatus == domain.CacheSemanticHit {
+		t.Error("Expected the X-Baldr-Team header not to reach another team's answers")
+	}
+
+	for _, prompt := range embeddings.prompts {
+		if strings.Contains(prompt, "jane@example.com") {
+			t.Errorf("Raw PII reached the embeddings provider: %q", prompt)
+		}
+	}
+}
+
+// shadowStore hands saved records to the test.
+type shadowStore struct {
+	records chan *domain.ShadowRecord
+}
+
+func (s *shadowStore) Save(ctx context.Context, record *domain.ShadowRecord) error {
+	s.records <- record
+	return nil
+}
+
+func TestBaldrService_Shadow(t *testing.T) {
+	// Scenario: Every request is mirrored to a slow shadow model.
+	// Expected: The client gets the primary answer without waiting for the
+	// shadow one, and both are recorded with their cost.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	primary := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Baldr is \"}}]}\n\n" +
+					"data: {\"choices\":[{\"delta\":{\"content\":\"a god\"}}],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":1000}}\n\n" +
+					"data: [DONE]\n\n")), nil
+		},
+	}
+	release := make(chan struct{})
+	var shadowPayload []byte
+	shadowLLM := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			shadowPayload = payload
+			<-release // The client must not be waiting for this
+			return io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"A Norse god"}}],"usage":{"prompt_tokens":10,"completion_tokens":4}}`)), nil
+		},
+	}
+	store := &shadowStore{records: make(chan *domain.ShadowRecord, 1)}
+	service := core.NewBaldrService(guardrail, primary).WithShadow(shadowLLM, store, core.ShadowConfig{
+		Model:   "candidate",
+		Percent: 100,
+		Pricing: map[string]domain.ModelPrice{"m": {Input: 1, Output: 2}, "candidate": {Input: 0.5, Output: 1}},
+	})
+
+	md := &domain.RequestMetadata{RequestID: "req-1"}
+	stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"m","stream":true}`), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	answer, _ := io.ReadAll(stream)
+	stream.Close()
+	if strings.Contains(string(answer), "Norse") || !strings.Contains(string(answer), "a god") {
+		t.Errorf("Expected the primary answer only, got %s", answer)
+	}
+	close(release)
+
+	record := <-store.records
+	if record.RequestID != "req-1" || !strings.Contains(string(shadowPayload), `"model":"candidate"`) {
+		t.Errorf("Expected the request mirrored to the candidate, got %s", shadowPayload)
+	}
+	if record.Primary.Model != "m" || record.Primary.Content != "Baldr is a god" || record.Primary.Error != "" {
+		t.Errorf("Unexpected primary result: %+v", record.Primary)
+	}
+	if record.Shadow.Model != "candidate" || record.Shadow.Content != "A Norse god" {
+		t.Errorf("Unexpected shadow result: %+v", record.Shadow)
+	}
+	if record.Primary.CostUSD != 0.00201 || record.Shadow.CostUSD != 0.000009 {
+		t.Errorf("Unexpected costs: primary %v, shadow %v", record.Primary.CostUSD, record.Shadow.CostUSD)
+	}
+}
+
+// outcomeRecorder keeps experiment outcomes for inspection.
+type outcomeRecorder struct {
+	outcomes []domain.ExperimentOutcome
+}
+
+func (r *outcomeRecorder) Record(outcome domain.ExperimentOutcome) {
+	r.outcomes = append(r.outcomes, outcome)
+}
+func (r *outcomeRecorder) Feedback(requestID string, score float64) error { return nil }
+func (r *outcomeRecorder) Results() []domain.VariantStats                 { return nil }
+
+func TestBaldrService_Experiments(t *testing.T) {
+	// Scenario: A logical model is split 50/50 between two variants, one of
+	// them on another upstream.
+	// Expected: Each user sticks to a variant, the upstream gets the
+	// variant's model, and outcomes are recorded with their cost.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	answer := `{"choices":[{"message":{"content":"hi"}}],"usage":{"prompt_tokens":1000,"completion_tokens":1000}}`
+	var models []string
+	upstream := func(name string) *TestMockLLM {
+		return &TestMockLLM{
+			mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+				var body struct{ Model string }
+				json.Unmarshal(payload, &body)
+				models = append(models, name+"/"+body.Model)
+				return io.NopCloser(strings.NewReader(answer)), nil
+			},
+		}
+	}
+	stats := &outcomeRecorder{}
+	service := core.NewBaldrService(guardrail, upstream("default")).WithExperiments(core.ExperimentsConfig{
+		Experiments: []core.Experiment{{
+			Name:   "ab",
+			Model:  "chat",
+			Sticky: "user",
+			Variants: []core.Variant{
+				{Name: "a", Model: "model-a", Weight: 1},
+				{Name: "b", Model: "model-b", Weight: 1, LLM: upstream("other")},
+			},
+		}},
+		Stats:   stats,
+		Pricing: map[string]domain.ModelPrice{"model-a": {Input: 1, Output: 1}, "model-b": {Input: 2, Output: 2}},
+	})
+
+	execute := func(user, model string) *domain.RequestMetadata {
+		md := &domain.RequestMetadata{RequestID: user + "-req", KeyID: "key-shared", User: user, Model: model}
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"`+model+`"}`), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		io.ReadAll(stream)
+		stream.Close()
+		return md
+	}
+
+	variants := make(map[string]string)
+	for i := range 20 {
+		user := fmt.Sprintf("user-%d", i)
+		md := execute(user, "chat")
+		if md.Experiment != "ab" || md.Variant == "" {
+			t.Fatalf("Expected the request to be tagged, got %+v", md)
+		}
+		variants[user] = md.Variant
+		if again := execute(user, "chat"); again.Variant != md.Variant {
+			t.Errorf("Expected %s to stick to %s, got %s", user, md.Variant, again.Variant)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, v := range variants {
+		seen[v] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Errorf("Expected 20 users to reach both variants, got %v", variants)
+	}
+	for _, m := range models {
+		if m != "default/model-a" && m != "other/model-b" {
+			t.Errorf("Variant served by the wrong upstream or model: %s", m)
+		}
+	}
+
+	if len(stats.outcomes) != 40 {
+		t.Fatalf("Expected an outcome per request, got %d", len(stats.outcomes))
+	}
+	for _, o := range stats.outcomes {
+		want := map[string]float64{"a": 0.002, "b": 0.004}[o.Variant]
+		if o.Failed || o.CostUSD != want || o.Usage == nil {
+			t.Errorf("Unexpected outcome %+v", o)
+		}
+	}
+
+	if md := execute("user-0", "other"); md.Experiment != "" || models[len(models)-1] != "default/other" {
+		t.Errorf("Expected other models to bypass the experiment, got %+v", md)
+	}
+}
+
+type usageRecorder struct {
+	records []domain.UsageRecord
+}
+
+func (r *usageRecorder) Record(record domain.UsageRecord) { r.records = append(r.records, record) }
+func (r *usageRecorder) Summary() []domain.UsageSummary   { return nil }
+func (r *usageRecorder) Report(query domain.UsageQuery) ([]domain.UsageSummary, error) {
+	return nil, nil
+}
+
+func TestBaldrService_Endpoints(t *testing.T) {
+	// Scenario: Requests to endpoints other than chat completions, whose
+	// guardrail redacts every input.
+	// Expected: The guardrail sees a chat view of the free text inputs, the
+	// upstream gets the redacted text in the original fields, and the usage
+	// of each call is recorded against its endpoint.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			var view struct {
+				Model    string `json:"model"`
+				Messages []struct {
+					Role    string `json:"role"`
+					Content string `json:"content"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal(payload, &view); err != nil {
+				t.Fatalf("Guardrail got an unreadable view: %s", payload)
+			}
+			for i, msg := range view.Messages {
+				if msg.Content == "mismatch" {
+					view.Messages = view.Messages[:i]
+					break
+				}
+				view.Messages[i].Content = "[" + msg.Role + "]"
+			}
+			sanitized, _ := json.Marshal(view)
+			return &domain.GuardrailResponse{Allowed: true, Action: domain.ActionRedact, SanitizedInput: sanitized}, nil
+		},
+	}
+	var sent []byte
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			sent = payload
+			return io.NopCloser(strings.NewReader(`{"usage":{"input_tokens":7,"output_tokens":3}}`)), nil
+		},
+	}
+	usage := &usageRecorder{}
+	service := core.NewBaldrService(guardrail, llm).WithUsage(usage, map[string]domain.ModelPrice{"m": {Input: 1e6, Output: 1e6}})
+
+	tests := []struct {
+		endpoint domain.Endpoint
+		payload  string
+		want     string
+	}{
+		{domain.EndpointEmbeddings, `{"model":"m","input":["a","b"],"dimensions":256}`,
+			`{"dimensions":256,"input":["[user]","[user]"],"model":"m"}`},
+		{domain.EndpointCompletions, `{"model":"m","prompt":"a","max_tokens":5}`,
+			`{"max_tokens":5,"model":"m","prompt":"[user]"}`},
+		{domain.EndpointModerations, `{"input":[{"type":"text","text":"a"},{"type":"image_url","image_url":{"url":"x"}}]}`,
+			`{"input":[{"text":"[user]","type":"text"},{"image_url":{"url":"x"},"type":"image_url"}]}`},
+		{domain.EndpointResponses, `{"model":"m","instructions":"a","input":[{"role":"developer","content":"b"},{"type":"function_call_output","call_id":"c","output":"42"}]}`,
+			`{"input":[{"content":"[developer]","role":"developer"},{"call_id":"c","output":"[tool]","type":"function_call_output"}],"instructions":"[system]","model":"m"}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.endpoint), func(t *testing.T) {
+			md := &domain.RequestMetadata{RequestID: "r", Endpoint: tt.endpoint, Model: "m"}
+			stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(tt.payload), nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			io.ReadAll(stream)
+			stream.Close()
+			if string(sent) != tt.want {
+				t.Errorf("Expected upstream to get %s, got %s", tt.want, sent)
+			}
+			last := usage.records[len(usage.records)-1]
+			if last.Endpoint != tt.endpoint || last.Usage.TotalTokens != 10 || last.CostUSD != 10 {
+				t.Errorf("Unexpected usage record %+v", last)
+			}
+		})
+	}
+
+	// A verdict that no longer lines up with the inputs fails closed
+	sent = nil
+	md := &domain.RequestMetadata{Endpoint: domain.EndpointEmbeddings}
+	_, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"m","input":["a","mismatch"]}`), nil)
+	if err == nil || sent != nil {
+		t.Errorf("Expected a mismatched verdict to fail closed, got %v and %s", err, sent)
+	}
+
+	// Token IDs can't be checked, so they fail closed too
+	for _, payload := range []string{`{"model":"m","prompt":[[1234,567]]}`, `{"model":"m","input":[1234,567]}`} {
+		md := &domain.RequestMetadata{Endpoint: domain.EndpointCompletions}
+		if strings.Contains(payload, "input") {
+			md.Endpoint = domain.EndpointEmbeddings
+		}
+		_, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(payload), nil)
+		if err == nil || sent != nil {
+			t.Errorf("Expected token IDs in %s to fail closed, got %v and %s", payload, err, sent)
+		}
+	}
+}
+
+// listingLLM is an upstream that also lists its models.
+type listingLLM struct {
+	models string
+	lists  atomic.Int32
+	served []string
+}
+
+func (l *listingLLM) Generate(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+	var body struct{ Model string }
+	json.Unmarshal(payload, &body)
+	l.served = append(l.served, body.Model)
+	return io.NopCloser(strings.NewReader(`{}`)), nil
+}
+
+func (l *listingLLM) Models(ctx context.Context) (io.ReadCloser, error) {
+	l.lists.Add(1)
+	return io.NopCloser(strings.NewReader(l.models)), nil
+}
+
+func TestBaldrService_ModelCatalog(t *testing.T) {
+	// Scenario: Two providers, an alias on the second, and a key limited
+	// to some of the models.
+	// Expected: The listing is the union of both providers' models plus
+	// the alias, with metadata and price, fetched once per refresh and
+	// filtered per key. Requests follow the alias and the listings to the
+	// right provider, and keys are held to their models.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	primary := &listingLLM{models: `{"object":"list","data":[{"id":"flash","object":"model","owned_by":"google"},{"id":"shared","object":"model"}]}`}
+	other := &listingLLM{models: `{"object":"list","data":[{"id":"gpt","object":"model","created":1,"owned_by":"openai"},{"id":"shared","object":"model"}]}`}
+	service := core.NewBaldrService(guardrail, primary).WithModelCatalog(core.ModelCatalogConfig{
+		Providers: []core.ModelProvider{{Name: "other", LLM: other}},
+		Aliases:   []core.ModelAlias{{Name: "smart", Model: "gpt", Provider: "other"}},
+		Metadata:  map[string]domain.ModelMetadata{"gpt": {ContextWindow: 128000}},
+		Access:    map[string][]string{"key-limited": {"fla*", "smart"}, "key-any": {"*"}},
+		Pricing:   map[string]domain.ModelPrice{"gpt": {Input: 2, Output: 8}},
+		Refresh:   time.Hour,
+	})
+
+	list := func(key string) []domain.ModelInfo {
+		md := &domain.RequestMetadata{KeyID: key}
+		stream, err := service.Models(domain.WithRequestMetadata(context.Background(), md))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer stream.Close()
+		var body struct {
+			Object string             `json:"object"`
+			Data   []domain.ModelInfo `json:"data"`
+		}
+		if err := json.NewDecoder(stream).Decode(&body); err != nil || body.Object != "list" {
+			t.Fatalf("Expected a model list, got %v", err)
+		}
+		return body.Data
+	}
+	ids := func(models []domain.ModelInfo) []string {
+		var ids []string
+		for _, m := range models {
+			ids = append(ids, m.Provider+"/"+m.ID)
+		}
+		return ids
+	}
+
+	all := list("key-any")
+	if want := []string{"default/flash", "default/shared", "other/gpt", "other/smart"}; !slices.Equal(ids(all), want) {
+		t.Errorf("Expected %v, got %v", want, ids(all))
+	}
+	smart := all[3]
+	if smart.AliasOf != "gpt" || smart.ContextWindow != 128000 || smart.Pricing == nil || smart.Pricing.Output != 8 {
+		t.Errorf("Expected the alias to carry its model's metadata, got %+v", smart)
+	}
+	if limited := list("key-limited"); !slices.Equal(ids(limited), []string{"default/flash", "other/smart"}) {
+		t.Errorf("Expected the key's models only, got %v", ids(limited))
+	}
+	// Any token makes a key ID, so unlisted keys get nothing
+	if unlisted := list("key-random"); len(unlisted) != 0 {
+		t.Errorf("Expected an unlisted key to see no models, got %v", ids(unlisted))
+	}
+	if primary.lists.Load() != 1 || other.lists.Load() != 1 {
+		t.Errorf("Expected each provider listed once within the refresh interval, got %d and %d", primary.lists.Load(), other.lists.Load())
+	}
+
+	execute := func(key, model string) error {
+		md := &domain.RequestMetadata{KeyID: key, Model: model}
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"`+model+`"}`), nil)
+		if err == nil {
+			stream.Close()
+		}
+		return err
+	}
+	for _, model := range []string{"flash", "shared", "gpt", "smart"} {
+		if err := execute("key-any", model); err != nil {
+			t.Fatalf("Unexpected error for %s: %v", model, err)
+		}
+	}
+	if !slices.Equal(primary.served, []string{"flash", "shared"}) || !slices.Equal(other.served, []string{"gpt", "gpt"}) {
+		t.Errorf("Requests reached the wrong provider: primary %v, other %v", primary.served, other.served)
+	}
+	if err := execute("key-limited", "gpt"); err == nil {
+		t.Error("Expected a key to be refused a model it may not use")
+	}
+	if err := execute("key-random", "flash"); err == nil {
+		t.Error("Expected an unlisted key to be refused")
+	}
+	// Virtual keys are authenticated, so their own models are enough
+	virtual := &domain.RequestMetadata{KeyID: "vk-1", Model: "flash", Key: &domain.VirtualKey{ID: "vk-1", KeySpec: domain.KeySpec{Models: []string{"flash"}}}}
+	stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), virtual), []byte(`{"model":"flash"}`), nil)
+	if err != nil {
+		t.Fatalf("Expected an unlisted virtual key to use its models, got %v", err)
+	}
+	stream.Close()
+}
+
+// slowListingLLM answers its first listing at once, and the next ones
+// when hold is closed.
+type slowListingLLM struct {
+	listingLLM
+	hold chan struct{}
+}
+
+func (l *slowListingLLM) Models(ctx context.Context) (io.ReadCloser, error) {
+	if l.lists.Add(1) > 1 {
+		select {
+		case <-l.hold:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return io.NopCloser(strings.NewReader(l.models)), nil
+}
+
+func TestBaldrService_ModelCatalogRefreshesInBackground(t *testing.T) {
+	// Scenario: The listings are stale and the provider is slow to list
+	// its models again.
+	// Expected: Listings and requests are served from the stale listings
+	// at once, and a single refresh runs however many callers notice.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	primary := &listingLLM{models: `{"object":"list","data":[{"id":"flash","object":"model"}]}`}
+	other := &slowListingLLM{listingLLM: listingLLM{models: `{"object":"list","data":[{"id":"gpt","object":"model"}]}`}, hold: make(chan struct{})}
+	defer close(other.hold)
+	service := core.NewBaldrService(guardrail, primary).WithModelCatalog(core.ModelCatalogConfig{
+		Providers: []core.ModelProvider{{Name: "other", LLM: other}},
+		Refresh:   time.Nanosecond,
+	})
+
+	list := func() int {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		stream, err := service.Models(domain.WithRequestMetadata(ctx, &domain.RequestMetadata{}))
+		if err != nil {
+			t.Fatalf("Expected the listing served at once, got %v", err)
+		}
+		defer stream.Close()
+		var body struct{ Data []domain.ModelInfo }
+		json.NewDecoder(stream).Decode(&body)
+		return len(body.Data)
+	}
+
+	// The first listing is the only one waited for
+	if n := list(); n != 2 {
+		t.Fatalf("Expected both providers' models, got %d", n)
+	}
+	for range 5 {
+		if n := list(); n != 2 {
+			t.Errorf("Expected the stale listings served, got %d models", n)
+		}
+	}
+	md := &domain.RequestMetadata{Model: "gpt"}
+	stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"gpt"}`), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stream.Close()
+	if md.Provider != "other" {
+		t.Errorf("Expected the request routed by the stale listing, got %q", md.Provider)
+	}
+	// The refresh is stuck on the provider, later callers don't add any
+	for deadline := time.Now().Add(time.Second); other.lists.Load() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	list()
+	if n := other.lists.Load(); n != 2 {
+		t.Errorf("Expected a single refresh in flight, got %d listings", n)
+	}
+}
+
+// mapKeyStore keeps virtual keys in a map, by ID.
+type mapKeyStore map[string]domain.VirtualKey
+
+func (s mapKeyStore) Create(ctx context.Context, key *domain.VirtualKey) error {
+	s[key.ID] = *key
+	return nil
+}
+func (s mapKeyStore) Get(ctx context.Context, id string) (*domain.VirtualKey, error) {
+	key, ok := s[id]
+	if !ok {
+		return nil, domain.ErrKeyNotFound
+	}
+	return &key, nil
+}
+func (s mapKeyStore) FindByHash(ctx context.Context, hash string) (*domain.VirtualKey, error) {
+	for _, key := range s {
+		if key.Hash == hash {
+			return &key, nil
+		}
+	}
+	return nil, domain.ErrKeyNotFound
+}
+func (s mapKeyStore) List(ctx context.Context) ([]*domain.VirtualKey, error) { return nil, nil }
+func (s mapKeyStore) Update(ctx context.Context, key *domain.VirtualKey) error {
+	return s.Create(ctx, key)
+}
+func (s mapKeyStore) Delete(ctx context.Context, id string) error {
+	delete(s, id)
+	return nil
+}
+func (s mapKeyStore) AddSpend(ctx context.Context, id string, usd float64) error {
+	key := s[id]
+	key.SpentUSD += usd
+	s[id] = key
+	return nil
+}
+
+func TestKeyManager_Lifecycle(t *testing.T) {
+	// Scenario: A key is created, updated, rotated and suspended.
+	// Expected: Only a hash of the secret is kept, the secret authenticates
+	// until rotated or the key is suspended, and bad settings are refused.
+
+	store := mapKeyStore{}
+	keys := core.NewKeyManager(store)
+	ctx := context.Background()
+
+	if _, _, err := keys.Create(ctx, domain.KeySpec{Team: "t"}); !errors.Is(err, domain.ErrInvalidKeySpec) {
+		t.Errorf("Expected a key without owner to be refused, got %v", err)
+	}
+	key, secret, err := keys.Create(ctx, domain.KeySpec{Owner: "ada", Team: "research", Models: []string{"gemini-*"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(secret, domain.VirtualKeyPrefix) || !strings.HasPrefix(secret, key.Prefix) || strings.Contains(store[key.ID].Hash, secret) {
+		t.Errorf("Unexpected secret %q for key %+v", secret, key)
+	}
+	if got, err := keys.Authenticate(ctx, secret); err != nil || got.ID != key.ID {
+		t.Fatalf("Expected the secret to authenticate, got %v", err)
+	}
+	if _, err := keys.Authenticate(ctx, secret+"x"); !errors.Is(err, domain.ErrInvalidKey) {
+		t.Errorf("Expected an unknown secret to be refused, got %v", err)
+	}
+
+	updated, err := keys.Update(ctx, key.ID, []byte(`{"team": "platform", "models": null, "budget_usd": 5}`))
+	if err != nil || updated.Team != "platform" || updated.Models != nil || updated.BudgetUSD != 5 || updated.Owner != "ada" {
+		t.Errorf("Expected a merge of the spec, got %+v (%v)", updated, err)
+	}
+	// null clears strings and numbers too, objects merge
+	keys.Update(ctx, key.ID, []byte(`{"limits": {"requests_per_minute": 10, "tokens_per_minute": 100}}`))
+	updated, err = keys.Update(ctx, key.ID, []byte(`{"team": null, "budget_usd": null, "limits": {"requests_per_minute": null}}`))
+	if err != nil || updated.Team != "" || updated.BudgetUSD != 0 || updated.Limits != (domain.KeyLimits{TokensPerMinute: 100}) || updated.Owner != "ada" {
+		t.Errorf("Expected null to clear fields, got %+v (%v)", updated, err)
+	}
+	if _, err := keys.Update(ctx, key.ID, []byte(`{"owner": null}`)); !errors.Is(err, domain.ErrInvalidKeySpec) {
+		t.Errorf("Expected a key without owner to be refused, got %v", err)
+	}
+	if _, err := keys.Update(ctx, key.ID, []byte(`{"status": "active"}`)); !errors.Is(err, domain.ErrInvalidKeySpec) {
+		t.Errorf("Expected unknown fields to be refused, got %v", err)
+	}
+
+	_, rotated, err := keys.Rotate(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := keys.Authenticate(ctx, secret); !errors.Is(err, domain.ErrInvalidKey) {
+		t.Errorf("Expected the old secret to stop working, got %v", err)
+	}
+	if _, err := keys.SetStatus(ctx, key.ID, domain.KeySuspended); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := keys.Authenticate(ctx, rotated); !errors.Is(err, domain.ErrKeySuspended) {
+		t.Errorf("Expected a suspended key to be refused, got %v", err)
+	}
+}
+
+func TestBaldrService_VirtualKeys(t *testing.T) {
+	// Scenario: Requests made with virtual keys that restrict models, rate
+	// and budget.
+	// Expected: Requests beyond a limit never reach the upstream, and the
+	// cost of each call is charged to its key.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	calls := 0
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			calls++
+			return io.NopCloser(strings.NewReader(`{"usage":{"prompt_tokens":600,"completion_tokens":400}}`)), nil
+		},
+	}
+	store := mapKeyStore{}
+	keys := core.NewKeyManager(store)
+	service := core.NewBaldrService(guardrail, llm).WithVirtualKeys(keys).
+		WithUsage(&usageRecorder{}, map[string]domain.ModelPrice{"flash": {Input: 1000, Output: 1000}})
+
+	create := func(spec domain.KeySpec) string {
+		key, _, err := keys.Create(context.Background(), spec)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return key.ID
+	}
+	execute := func(id, model string) error {
+		key, _ := store.Get(context.Background(), id)
+		md := &domain.RequestMetadata{Key: key, KeyID: key.ID, Model: model}
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"`+model+`"}`), nil)
+		if err == nil {
+			io.ReadAll(stream)
+			stream.Close()
+		}
+		return err
+	}
+
+	limitedModels := create(domain.KeySpec{Owner: "a", Models: []string{"flash*"}})
+	if err := execute(limitedModels, "pro"); !errors.Is(err, domain.ErrModelNotAllowed) || calls != 0 {
+		t.Errorf("Expected the model to be refused, got %v", err)
+	}
+	if err := execute(limitedModels, "flash-lite"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	rated := create(domain.KeySpec{Owner: "a", Limits: domain.KeyLimits{RequestsPerMinute: 2}})
+	for i, want := range []error{nil, nil, domain.ErrRateLimited} {
+		if err := execute(rated, "m"); !errors.Is(err, want) {
+			t.Errorf("Request %d: expected %v, got %v", i, want, err)
+		}
+	}
+
+	tokens := create(domain.KeySpec{Owner: "a", Limits: domain.KeyLimits{TokensPerMinute: 1000}})
+	for i, want := range []error{nil, domain.ErrRateLimited} {
+		if err := execute(tokens, "m"); !errors.Is(err, want) {
+			t.Errorf("Request %d: expected %v, got %v", i, want, err)
+		}
+	}
+
+	budget := create(domain.KeySpec{Owner: "a", BudgetUSD: 1.5})
+	for i, want := range []error{nil, nil, domain.ErrBudgetExceeded} {
+		if err := execute(budget, "flash"); !errors.Is(err, want) {
+			t.Errorf("Request %d: expected %v, got %v", i, want, err)
+		}
+	}
+	if spent := store[budget].SpentUSD; spent != 2 {
+		t.Errorf("Expected 2 USD charged to the key, got %v", spent)
+	}
+}
+
+func TestBaldrService_TokenLimits(t *testing.T) {
+	// Scenario: A model with a 100 token context window and a 50 token
+	// output cap, under each max_tokens policy, and a key capping requests
+	// to 30 tokens.
+	// Expected: Prompts that can't fit never reach the guardrail or the
+	// upstream, and max_tokens is clamped or injected to what fits.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true, Action: domain.ActionAllow}, nil
+		},
+	}
+	var sent []byte
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			sent = payload
+			return io.NopCloser(strings.NewReader("data: [DONE]\n\n")), nil
+		},
+	}
+	models := map[string]domain.ModelMetadata{
+		"gpt-4o":                 {ContextWindow: 100, MaxOutputTokens: 50},
+		"text-embedding-3-small": {ContextWindow: 100},
+	}
+	newService := func(policy core.MaxTokensPolicy) *core.BaldrService {
+		return core.NewBaldrService(guardrail, llm).WithTokenLimits(core.TokenLimitsConfig{Models: models, MaxTokens: policy, DefaultMaxTokens: 20})
+	}
+	// "user" and "hi" are a token each, plus 4 for the message and 3 for the
+	// reply: 9 tokens
+	short := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]`
+	long := `{"model":"gpt-4o","messages":[{"role":"user","content":"` + strings.Repeat("a ", 210) + `"}]}`
+
+	tests := []struct {
+		name     string
+		service  *core.BaldrService
+		endpoint domain.Endpoint
+		key      *domain.VirtualKey
+		payload  string
+		want     string
+		err      error
+	}{
+		{"fits", newService(core.MaxTokensClamp), "", nil, short + `}`, short + `}`, nil},
+		{"clamped to the output cap", newService(core.MaxTokensClamp), "", nil, short + `,"max_tokens":80}`,
+			`{"max_tokens":50,"messages":[{"role":"user","content":"hi"}],"model":"gpt-4o"}`, nil},
+		{"newer field clamped", newService(core.MaxTokensClamp), "", nil, short + `,"max_completion_tokens":80}`,
+			`{"max_completion_tokens":50,"messages":[{"role":"user","content":"hi"}],"model":"gpt-4o"}`, nil},
+		{"over the context window", newService(core.MaxTokensClamp), "", nil, long, "", domain.ErrContextLengthExceeded},
+		{"rejected", newService(core.MaxTokensReject), "", nil, short + `,"max_tokens":80}`, "", domain.ErrContextLengthExceeded},
+		{"injected", newService(core.MaxTokensInject), "", nil, short + `}`,
+			`{"max_tokens":20,"messages":[{"role":"user","content":"hi"}],"model":"gpt-4o"}`, nil},
+		{"injected into a response", newService(core.MaxTokensInject), domain.EndpointResponses, nil, `{"model":"gpt-4o","input":"hi"}`,
+			`{"input":"hi","max_output_tokens":20,"model":"gpt-4o"}`, nil},
+		{"embeddings held to the window one input at a time", newService(core.MaxTokensInject), domain.EndpointEmbeddings, nil,
+			`{"input":["` + strings.Repeat("a ", 150) + `","` + strings.Repeat("a ", 150) + `"],"model":"text-embedding-3-small"}`,
+			`{"input":["` + strings.Repeat("a ", 150) + `","` + strings.Repeat("a ", 150) + `"],"model":"text-embedding-3-small"}`, nil},
+		{"key cap injected", core.NewBaldrService(guardrail, llm), "", &domain.VirtualKey{KeySpec: domain.KeySpec{Limits: domain.KeyLimits{MaxTokensPerRequest: 30}}},
+			short + `}`, `{"max_tokens":21,"messages":[{"role":"user","content":"hi"}],"model":"gpt-4o"}`, nil},
+		{"over the key cap", newService(core.MaxTokensReject), "", &domain.VirtualKey{KeySpec: domain.KeySpec{Limits: domain.KeyLimits{MaxTokensPerRequest: 30}}},
+			short + `,"max_tokens":25}`, "", domain.ErrTokenLimitExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent = nil
+			md := &domain.RequestMetadata{RequestID: "r", Endpoint: tt.endpoint, Model: "gpt-4o", Key: tt.key}
+			if tt.endpoint == domain.EndpointEmbeddings {
+				md.Model = "text-embedding-3-small"
+			}
+			stream, err := tt.service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(tt.payload), nil)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) || sent != nil {
+					t.Fatalf("Expected %v before the upstream, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			stream.Close()
+			if string(sent) != tt.want {
+				t.Errorf("Expected upstream to get %s, got %s", tt.want, sent)
+			}
+			if tt.endpoint == "" && md.PromptTokens != 9 {
+				t.Errorf("Expected a 9 token prompt, estimated %d", md.PromptTokens)
+			}
+		})
+	}
+}
+
+func TestBaldrService_RequestPolicies(t *testing.T) {
+	// Scenario: A policy for everyone, one for the support team and one for
+	// a single key.
+	// Expected: Requests breaking a rule of a policy that applies to them
+	// are refused with that rule before the guardrail sees them; others go
+	// through.
+
+	checked := 0
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			checked++
+			return &domain.GuardrailResponse{Allowed: true, Action: domain.ActionAllow}, nil
+		},
+	}
+	var sent []byte
+	llm := &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			sent = payload
+			return io.NopCloser(strings.NewReader("data: [DONE]\n\n")), nil
+		},
+	}
+	no, maxTemperature := false, 1.0
+	service := core.NewBaldrService(guardrail, llm).WithRequestPolicies([]domain.RequestPolicy{
+		{Name: "baseline", MaxTokens: 1000, Temperature: &domain.PolicyRange{Max: &maxTemperature}},
+		{Name: "support", Teams: []string{"support"}, Models: []string{"gemini-*"}, AllowedTools: []string{"lookup_*"}, Images: &no},
+		{Name: "batch", Keys: []string{"vk_batch"}, Tools: &no, Stream: &no},
+	})
+
+	image := `[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"x"}}]`
+	// Teams come from virtual keys, a bare team header is ignored
+	support := &domain.VirtualKey{ID: "vk_support", KeySpec: domain.KeySpec{Team: "support"}}
+	research := &domain.VirtualKey{ID: "vk_research", KeySpec: domain.KeySpec{Team: "research"}}
+	tests := []struct {
+		name    string
+		md      domain.RequestMetadata
+		payload string
+		policy  string
+		rule    string
+		param   string
+	}{
+		{"allowed", domain.RequestMetadata{Key: support, KeyID: support.ID, Team: "support", Model: "gemini-flash"},
+			`{"model":"gemini-flash","max_tokens":100,"temperature":0.5,"tools":[{"type":"function","function":{"name":"lookup_order"}}],"messages":[{"role":"user","content":"hi"}]}`, "", "", ""},
+		{"max_tokens for everyone", domain.RequestMetadata{Model: "gpt-4o"},
+			`{"model":"gpt-4o","max_tokens":2000,"messages":[{"role":"user","content":"hi"}]}`, "baseline", "max_tokens", "max_tokens"},
+		{"newer max_tokens field", domain.RequestMetadata{Model: "gpt-4o"},
+			`{"model":"gpt-4o","max_completion_tokens":2000,"messages":[{"role":"user","content":"hi"}]}`, "baseline", "max_tokens", "max_completion_tokens"},
+		{"temperature", domain.RequestMetadata{Model: "gpt-4o"},
+			`{"model":"gpt-4o","temperature":1.2,"messages":[{"role":"user","content":"hi"}]}`, "baseline", "temperature", "temperature"},
+		{"other teams' models", domain.RequestMetadata{Key: support, KeyID: support.ID, Team: "support", Model: "gpt-4o"},
+			`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, "support", "models", "model"},
+		{"tool outside the allowed ones", domain.RequestMetadata{Key: support, KeyID: support.ID, Team: "support", Model: "gemini-flash"},
+			`{"model":"gemini-flash","tools":[{"type":"function","function":{"name":"refund"}}],"messages":[{"role":"user","content":"hi"}]}`, "support", "allowed_tools", "tools"},
+		{"images", domain.RequestMetadata{Key: support, KeyID: support.ID, Team: "support", Model: "gemini-flash"},
+			`{"model":"gemini-flash","messages":[{"role":"user","content":` + image + `}]}`, "support", "images", ""},
+		{"images for other teams", domain.RequestMetadata{Key: research, KeyID: research.ID, Team: "research", Model: "gemini-flash"},
+			`{"model":"gemini-flash","messages":[{"role":"user","content":` + image + `}]}`, "", "", ""},
+		{"team header without a key", domain.RequestMetadata{Team: "research", Model: "gemini-flash"},
+			`{"model":"gemini-flash","messages":[{"role":"user","content":` + image + `}]}`, "", "", ""},
+		{"spoofed team header", domain.RequestMetadata{Key: research, KeyID: research.ID, Team: "support", Model: "gpt-4o"},
+			`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, "", "", ""},
+		{"tools on a key", domain.RequestMetadata{KeyID: "vk_batch", Endpoint: domain.EndpointResponses, Model: "gpt-4o"},
+			`{"model":"gpt-4o","input":"hi","tools":[{"type":"web_search"}]}`, "batch", "tools", "tools"},
+		{"streaming on a key", domain.RequestMetadata{KeyID: "vk_batch", Endpoint: domain.EndpointCompletions, Model: "gpt-4o"},
+			`{"model":"gpt-4o","prompt":"hi","stream":true}`, "batch", "stream", "stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checked = 0
+			md := tt.md
+			stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), &md), []byte(tt.payload), nil)
+			if tt.rule == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				stream.Close()
+				return
+			}
+			var violation *domain.PolicyViolation
+			if !errors.As(err, &violation) || !errors.Is(err, domain.ErrPolicyViolation) {
+				t.Fatalf("Expected a policy violation, got %v", err)
+			}
+			if violation.Policy != tt.policy || violation.Rule != tt.rule || violation.Param != tt.param {
+				t.Errorf("Expected %s/%s on %q, got %+v", tt.policy, tt.rule, tt.param, violation)
+			}
+			if checked != 0 {
+				t.Error("Refused requests must not reach the guardrail")
+			}
+		})
+	}
+
+	// Requests without max_tokens get the policy's cap
+	md := &domain.RequestMetadata{Model: "gpt-4o"}
+	stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stream.Close()
+	if !strings.Contains(string(sent), `"max_tokens":1000`) {
+		t.Errorf("Expected the policy's max_tokens to be set, got %s", sent)
+	}
+}
+
+type policyEngineFunc func(ctx context.Context, input domain.PolicyInput) (*domain.PolicyDecision, error)
+
+func (f policyEngineFunc) Evaluate(ctx context.Context, input domain.PolicyInput) (*domain.PolicyDecision, error) {
+	return f(ctx, input)
+}
+
+func TestBaldrService_ExpressionPolicies(t *testing.T) {
+	// Scenario: A policy engine that denies, routes or transforms requests
+	// depending on their user, after a guardrail that warns about PII.
+	// Expected: The engine sees the guardrail's verdict and the prompt's
+	// estimate; denials are policy violations that never reach the
+	// upstream, routes go to the provider of their model, transforms
+	// reach the upstream, and engine errors fail closed.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true, Action: domain.ActionWarn, Violations: []domain.Violation{{Category: "pii", Score: 0.4}}}, nil
+		},
+	}
+	var seen domain.PolicyInput
+	engine := policyEngineFunc(func(ctx context.Context, input domain.PolicyInput) (*domain.PolicyDecision, error) {
+		seen = input
+		switch input.User {
+		case "night-owl":
+			return &domain.PolicyDecision{Denied: "business-hours", Reason: "closed", Applied: []string{"business-hours"}}, nil
+		case "router":
+			return &domain.PolicyDecision{Model: "smart", Applied: []string{"route"}}, nil
+		case "transformer":
+			return &domain.PolicyDecision{Set: map[string]any{"temperature": 0.2, "tools": nil}, Applied: []string{"transform"}}, nil
+		case "broken":
+			return nil, errors.New("no such key: temperature")
+		}
+		return &domain.PolicyDecision{}, nil
+	})
+	primary := &listingLLM{}
+	other := &listingLLM{}
+	var sent []byte
+	service := core.NewBaldrService(guardrail, &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			sent = payload
+			return io.NopCloser(strings.NewReader(`{}`)), nil
+		},
+	}).WithExpressionPolicies(engine)
+	routing := core.NewBaldrService(guardrail, primary).WithModelCatalog(core.ModelCatalogConfig{
+		Providers: []core.ModelProvider{{Name: "other", LLM: other}},
+		Aliases:   []core.ModelAlias{{Name: "smart", Model: "gpt", Provider: "other"}},
+	}).WithExpressionPolicies(engine)
+
+	execute := func(service *core.BaldrService, user string) (*domain.RequestMetadata, error) {
+		md := &domain.RequestMetadata{RequestID: "r", User: user, Model: "flash"}
+		payload := `{"model":"flash","temperature":1,"tools":[{"type":"function","function":{"name":"f"}}],"messages":[{"role":"user","content":"hi"}]}`
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(payload), nil)
+		if err == nil {
+			stream.Close()
+		}
+		return md, err
+	}
+
+	if _, err := execute(service, "anyone"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if seen.GuardrailAction != domain.ActionWarn || len(seen.Violations) != 1 || seen.PromptTokens == 0 || seen.Body["temperature"] != 1.0 || seen.Time.IsZero() {
+		t.Errorf("Expected the engine to see the verdict and the estimate, got %+v", seen)
+	}
+
+	sent = nil
+	_, err := execute(service, "night-owl")
+	var violation *domain.PolicyViolation
+	if !errors.As(err, &violation) || violation.Policy != "business-hours" || violation.Reason != "closed" || sent != nil {
+		t.Errorf("Expected a denial before the upstream, got %v", err)
+	}
+
+	if _, err := execute(service, "transformer"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := `{"messages":[{"role":"user","content":"hi"}],"model":"flash","temperature":0.2}`; string(sent) != want {
+		t.Errorf("Expected upstream to get %s, got %s", want, sent)
+	}
+
+	md, err := execute(routing, "router")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(primary.served) != 0 || !slices.Equal(other.served, []string{"gpt"}) || md.Provider != "other" {
+		t.Errorf("Expected the request routed to the other provider, got primary %v, other %v", primary.served, other.served)
+	}
+
+	sent = nil
+	if _, err := execute(service, "broken"); err == nil || sent != nil {
+		t.Errorf("Expected an engine error to fail closed, got %v", err)
+	}
+}
+
+func TestBaldrService_ResponseGuardrail(t *testing.T) {
+	// Scenario: A guardrail checks what the model answered.
+	// Expected: It sees the whole response in the output direction, and its
+	// blocks and redactions hold for the client and the cache alike.
+
+	request := []byte(`{"model":"gpt-4o","temperature":0,"messages":[]}`)
+	tests := []struct {
+		name     string
+		verdict  *domain.GuardrailResponse
+		err      error
+		wantErr  bool
+		wantBody string
+	}{
+		{"Allow", &domain.GuardrailResponse{Allowed: true}, nil, false, "data: hello\n\ndata: [DONE]\n\n"},
+		{"Warn", &domain.GuardrailResponse{Allowed: true, Action: domain.ActionWarn}, nil, false, "data: hello\n\ndata: [DONE]\n\n"},
+		{"Redact", &domain.GuardrailResponse{Allowed: true, Action: domain.ActionRedact, SanitizedInput: []byte("data: [REDACTED]\n\n")}, nil, false, "data: [REDACTED]\n\n"},
+		{"Block", &domain.GuardrailResponse{Allowed: false, Reason: "leak"}, nil, true, ""},
+		{"Redact without a response", &domain.GuardrailResponse{Allowed: true, Action: domain.ActionRedact}, nil, true, ""},
+		{"Reroute", &domain.GuardrailResponse{Allowed: true, Action: domain.ActionReroute, RerouteModel: "flash"}, nil, true, ""},
+		{"Guardrail error", nil, errors.New("plugin trapped"), true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &TestMockGuardrail{
+				mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+					return &domain.GuardrailResponse{Allowed: true}, nil
+				},
+			}
+			var checked string
+			output := &TestMockGuardrail{
+				mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+					if md := domain.RequestMetadataFrom(ctx); md.Direction != domain.DirectionOutput {
+						t.Errorf("Expected the output direction, got %q", md.Direction)
+					}
+					checked = string(payload)
+					return tt.verdict, tt.err
+				},
+			}
+			llm := &TestMockLLM{
+				mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+					return io.NopCloser(&chunkedReader{chunks: []string{"data: hello\n\n", "data: [DONE]\n\n"}}), nil
+				},
+			}
+			cache := mapResponseCache{}
+			service := core.NewBaldrService(input, llm).
+				WithResponseCache(cache, core.ResponseCacheConfig{Enabled: true}).
+				WithResponseGuardrail(output)
+
+			md := &domain.RequestMetadata{Direction: domain.DirectionInput}
+			stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), request, nil)
+			if checked != "data: hello\n\ndata: [DONE]\n\n" {
+				t.Errorf("Expected the guardrail to check the whole response, got %q", checked)
+			}
+			if md.Direction != domain.DirectionInput {
+				t.Error("The request's metadata should be left in the input direction")
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected the response to be refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(stream)
+			stream.Close()
+			if string(body) != tt.wantBody {
+				t.Errorf("Expected %q, got %q", tt.wantBody, body)
+			}
+			for _, cached := range cache {
+				if got := string(bytes.Join(cached.Chunks, nil)); got != tt.wantBody {
+					t.Errorf("Expected the cache to hold %q, got %q", tt.wantBody, got)
+				}
+			}
+			if len(cache) != 1 {
+				t.Errorf("Expected the response cached, got %d entries", len(cache))
+			}
+		})
+	}
+}
+
+func TestBaldrService_ExpressionPoliciesOnCacheHits(t *testing.T) {
+	// Scenario: A deterministic request is cached while policies allow it,
+	// then a time-based policy starts denying, then another one routes it.
+	// Expected: The cached answer is never served against a denial, the
+	// engine sees the verdict the answer was given under, and a routed
+	// request goes upstream instead of getting the cached answer.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true, Action: domain.ActionWarn}, nil
+		},
+	}
+	decision := &domain.PolicyDecision{}
+	var seen domain.PolicyInput
+	engine := policyEngineFunc(func(ctx context.Context, input domain.PolicyInput) (*domain.PolicyDecision, error) {
+		seen = input
+		return decision, nil
+	})
+	llmCalls := 0
+	service := core.NewBaldrService(guardrail, &TestMockLLM{
+		mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+			llmCalls++
+			return io.NopCloser(strings.NewReader(`{}`)), nil
+		},
+	}).WithResponseCache(mapResponseCache{}, core.ResponseCacheConfig{Enabled: true}).WithExpressionPolicies(engine)
+
+	execute := func() (*domain.RequestMetadata, error) {
+		md := &domain.RequestMetadata{KeyID: "key-a", Model: "gpt-4o"}
+		stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(`{"model":"gpt-4o","temperature":0,"messages":[]}`), nil)
+		if err == nil {
+			io.ReadAll(stream)
+			stream.Close()
+		}
+		return md, err
+	}
+
+	for range 2 {
+		if _, err := execute(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if llmCalls != 1 {
+		t.Fatalf("Expected the second request answered from cache, got %d upstream calls", llmCalls)
+	}
+	if seen.GuardrailAction != domain.ActionWarn {
+		t.Errorf("Expected the hit judged with the cached verdict, got %q", seen.GuardrailAction)
+	}
+
+	decision = &domain.PolicyDecision{Denied: "business-hours", Reason: "closed"}
+	var violation *domain.PolicyViolation
+	if _, err := execute(); !errors.As(err, &violation) || violation.Policy != "business-hours" {
+		t.Errorf("Expected the cached request denied, got %v", err)
+	}
+
+	decision = &domain.PolicyDecision{Model: "gpt-4o-mini"}
+	md, err := execute()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if md.CacheStatus != domain.CacheMiss || llmCalls != 2 {
+		t.Errorf("Expected a routed request to go upstream, got %q after %d calls", md.CacheStatus, llmCalls)
+	}
+}
+
+func TestBaldrService_StreamUsage(t *testing.T) {
+	// Scenario: Streamed chat requests, from upstreams that do and don't
+	// honour stream_options, and from a client asking for usage itself.
+	// Expected: The upstream is asked for usage, which is recorded when it
+	// comes and estimated when it doesn't. Clients only get the usage chunk
+	// when they asked for it.
+
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	deltas := []string{
+		`data: {"choices":[{"delta":{"content":"Baldr is the"}}],"usage":null}` + "\n\n",
+		`data: {"choices":[{"delta":{"content":" Norse god of light"}}],"usage":null}` + "\n\n",
+	}
+	usageChunk := `data: {"choices":[],"usage":{"prompt_tokens":11,"completion_tokens":7,"total_tokens":18}}` + "\n\n"
+	done := "data: [DONE]\n\n"
+
+	tests := []struct {
+		name      string
+		payload   string
+		reported  bool   // Whether the upstream honours include_usage
+		chunk     string // The upstream's usage chunk, usageChunk when empty
+		wantUsage domain.Usage
+		wantChunk bool
+	}{
+		{"Usage asked for the client", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Who is Baldr?"}]}`, true, "",
+			domain.Usage{PromptTokens: 11, CompletionTokens: 7, TotalTokens: 18}, false},
+		{"Usage asked for the client, spaced", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Who is Baldr?"}]}`, true,
+			`data: { "choices": [ ], "usage": {"prompt_tokens": 11, "completion_tokens": 7, "total_tokens": 18} }` + "\n\n",
+			domain.Usage{PromptTokens: 11, CompletionTokens: 7, TotalTokens: 18}, false},
+		// 4 + ceil(4/4.2) + ceil(13/4.2) + 3 prompt tokens, ceil(12/4.2) + ceil(19/4.2) output
+		{"Usage estimated", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Who is Baldr?"}]}`, false, "",
+			domain.Usage{PromptTokens: 12, CompletionTokens: 8, TotalTokens: 20}, false},
+		{"Client asks for usage", `{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true},"messages":[]}`, true, "",
+			domain.Usage{PromptTokens: 11, CompletionTokens: 7, TotalTokens: 18}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]any
+			llm := &TestMockLLM{
+				mockGenerate: func(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+					json.Unmarshal(payload, &sent)
+					chunks := append([]string(nil), deltas...)
+					if tt.reported {
+						chunk := tt.chunk
+						if chunk == "" {
+							chunk = usageChunk
+						}
+						chunks = append(chunks, chunk)
+					}
+					return io.NopCloser(&chunkedReader{chunks: append(chunks, done)}), nil
+				},
+			}
+			usage := &usageRecorder{}
+			service := core.NewBaldrService(guardrail, llm).WithUsage(usage, nil)
+
+			md := &domain.RequestMetadata{Model: "gpt-4o"}
+			stream, err := service.Execute(domain.WithRequestMetadata(context.Background(), md), []byte(tt.payload), nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(stream)
+			stream.Close()
+
+			if options, _ := sent["stream_options"].(map[string]any); options["include_usage"] != true {
+				t.Errorf("Expected the upstream asked for usage, got %v", sent["stream_options"])
+			}
+			if got := strings.Contains(string(body), `"prompt_tokens"`); got != tt.wantChunk {
+				t.Errorf("Expected the usage chunk shown to the client: %v, got %s", tt.wantChunk, body)
+			}
+			if !strings.HasSuffix(string(body), done) || !strings.Contains(string(body), "Norse god") {
+				t.Errorf("Expected the rest of the stream untouched, got %s", body)
+			}
+			if len(usage.records) != 1 || usage.records[0].Usage != tt.wantUsage {
+				t.Errorf("Expected usage %+v recorded, got %+v", tt.wantUsage, usage.records)
+			}
+		})
+	}
+}
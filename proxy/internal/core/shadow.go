@@ -0,0 +1,260 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// ShadowConfig decides which requests are mirrored to the shadow model.
+type ShadowConfig struct {
+	// Model the mirrored requests are sent to
+	Model string
+	// Percent of upstream calls to mirror, from 0 to 100
+	Percent float64
+	// Timeout of a shadow call, the primary path never waits for it
+	Timeout time.Duration
+	// MaxInFlight caps concurrent shadow calls. Requests beyond it are not
+	// mirrored rather than queued.
+	MaxInFlight int
+	// MaxBodyBytes of each response kept in a record, bodies beyond it are
+	// truncated. 0 means 1 MiB.
+	MaxBodyBytes int
+	// Pricing by model, for the cost of both sides
+	Pricing map[string]domain.ModelPrice
+}
+
+type shadowMirror struct {
+	llm    ports.LLMPort
+	store  ports.ShadowStorePort
+	config ShadowConfig
+	slots  chan struct{}
+	sample func() bool
+}
+
+// WithShadow mirrors a share of upstream calls to a candidate model and
+// stores both responses side by side. Mirroring happens after the
+// guardrail, so the shadow model only sees what the primary one saw, and it
+// never delays or alters the client's response.
+func (s *BaldrService) WithShadow(llm ports.LLMPort, store ports.ShadowStorePort, config ShadowConfig) *BaldrService {
+	if config.Timeout == 0 {
+		config.Timeout = 60 * time.Second
+	}
+	if config.MaxInFlight < 1 {
+		config.MaxInFlight = 32
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = 1 << 20
+	}
+	s.shadow = &shadowMirror{
+		llm:    llm,
+		store:  store,
+		config: config,
+		slots:  make(chan struct{}, config.MaxInFlight),
+		sample: func() bool { return rand.Float64()*100 < config.Percent },
+	}
+	return s
+}
+
+// mirror starts the shadow call for a request whose primary call began at
+// start, and returns the primary stream wrapped to capture it. When the
+// request isn't sampled, or too many shadow calls are running, the primary
+// stream is returned untouched.
+func (m *shadowMirror) mirror(ctx context.Context, md *domain.RequestMetadata, payload []byte, headers map[string]string, primary io.ReadCloser, start time.Time) io.ReadCloser {
+	if !m.sample() {
+		return primary
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		return primary
+	}
+
+	record := &domain.ShadowRecord{
+		RequestID: md.RequestID,
+		KeyID:     md.KeyID,
+		Team:      md.Team,
+		Time:      start.UTC(),
+	}
+	capture := &captureStream{
+		ReadCloser: primary,
+		start:      start,
+		limit:      m.config.MaxBodyBytes,
+		done:       make(chan struct{}),
+	}
+	primaryModel := payloadModel(payload)
+
+	// The shadow call outlives the client request, and must not be
+	// cancelled with it
+	shadowCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.config.Timeout)
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+
+		record.Shadow = m.call(shadowCtx, payload, headers)
+		<-capture.done
+		record.Primary = m.result(primaryModel, capture.body.Bytes(), capture.truncated, capture.latency, capture.err)
+		if err := m.store.Save(shadowCtx, record); err != nil {
+			log.Printf("Shadow record for request %s not saved: %v", record.RequestID, err)
+		}
+	}()
+	return capture
+}
+
+// call sends the request to the shadow model and reads the whole response.
+func (m *shadowMirror) call(ctx context.Context, payload []byte, headers map[string]string) domain.ShadowResult {
+	start := time.Now()
+	shadowPayload, err := setModel(payload, m.config.Model)
+	if err != nil {
+		return domain.ShadowResult{Model: m.config.Model, Error: err.Error()}
+	}
+	stream, err := m.llm.Generate(ctx, shadowPayload, headers)
+	if err != nil {
+		return domain.ShadowResult{Model: m.config.Model, Latency: time.Since(start), Error: err.Error()}
+	}
+	defer stream.Close()
+
+	body, err := io.ReadAll(io.LimitReader(stream, int64(m.config.MaxBodyBytes)+1))
+	truncated := len(body) > m.config.MaxBodyBytes
+	if truncated {
+		body = body[:m.config.MaxBodyBytes]
+	}
+	return m.result(m.config.Model, body, truncated, time.Since(start), err)
+}
+
+func (m *shadowMirror) result(model string, body []byte, truncated bool, latency time.Duration, err error) domain.ShadowResult {
+	result := domain.ShadowResult{
+		Model:     model,
+		Body:      string(body),
+		Truncated: truncated,
+		Latency:   latency,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Content, result.Usage = parseCompletion(body)
+	if result.Usage != nil {
+		result.CostUSD = m.config.Pricing[model].Cost(*result.Usage)
+	}
+	return result
+}
+
+// captureStream copies the primary response as the client reads it, and
+// signals once the client is done with it, fully read or not.
+type captureStream struct {
+	io.ReadCloser
+	start     time.Time
+	limit     int
+	body      bytes.Buffer
+	truncated bool
+	latency   time.Duration
+	err       error
+	once      sync.Once
+	done      chan struct{}
+}
+
+func (c *captureStream) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		if room := c.limit - c.body.Len(); room < n {
+			c.body.Write(p[:max(room, 0)])
+			c.truncated = true
+		} else {
+			c.body.Write(p[:n])
+		}
+	}
+	switch {
+	case err == io.EOF:
+		c.finish(nil)
+	case err != nil:
+		c.finish(err)
+	}
+	return n, err
+}
+
+func (c *captureStream) Close() error {
+	c.finish(errors.New("client closed the stream early"))
+	return c.ReadCloser.Close()
+}
+
+func (c *captureStream) finish(err error) {
+	c.once.Do(func() {
+		c.latency = time.Since(c.start)
+		c.err = err
+		close(c.done)
+	})
+}
+
+// parseCompletion extracts the answer text and token usage from a chat
+// completion, streamed or not.
+func parseCompletion(body []byte) (string, *domain.Usage) {
+	type choice struct {
+		Delta   struct{ Content string } `json:"delta"`
+		Message struct{ Content string } `json:"message"`
+		Text    string                   `json:"text"`
+	}
+	type completion struct {
+		Choices []choice      `json:"choices"`
+		Usage   *domain.Usage `json:"usage"`
+		// Responses API streams report usage in their last event
+		Response *struct {
+			Usage *domain.Usage `json:"usage"`
+		} `json:"response"`
+	}
+
+	var content strings.Builder
+	var usage *domain.Usage
+	add := func(c completion) {
+		for _, ch := range c.Choices {
+			content.WriteString(ch.Delta.Content + ch.Message.Content + ch.Text)
+		}
+		if c.Usage != nil {
+			usage = c.Usage
+		}
+		if c.Response != nil && c.Response.Usage != nil {
+			usage = c.Response.Usage
+		}
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var c completion
+		if json.Unmarshal(trimmed, &c) == nil {
+			add(c)
+		}
+		return content.String(), usage
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var c completion
+		if json.Unmarshal([]byte(strings.TrimSpace(data)), &c) == nil {
+			add(c)
+		}
+	}
+	return content.String(), usage
+}
+
+// payloadModel returns the model a chat request asks for.
+func payloadModel(payload []byte) string {
+	var body struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(payload, &body)
+	return body.Model
+}
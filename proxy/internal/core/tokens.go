@@ -0,0 +1,282 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// MaxTokensPolicy is what the service does with requests asking for more
+// output than fits their model and key.
+type MaxTokensPolicy string
+
+const (
+	// MaxTokensClamp lowers max_tokens to what fits, the default
+	MaxTokensClamp MaxTokensPolicy = "clamp"
+	// MaxTokensReject refuses requests asking for more than fits
+	MaxTokensReject MaxTokensPolicy = "reject"
+	// MaxTokensInject clamps max_tokens, and sets it on requests without one
+	MaxTokensInject MaxTokensPolicy = "inject"
+)
+
+// TokenLimitsConfig bounds the tokens of requests before they go upstream.
+type TokenLimitsConfig struct {
+	// Models by name, for their context window and output cap
+	Models    map[string]domain.ModelMetadata
+	MaxTokens MaxTokensPolicy
+	// DefaultMaxTokens is injected into requests without max_tokens, within
+	// what fits. 0 injects all the room left.
+	DefaultMaxTokens int
+}
+
+// Rough costs of what isn't plain text, in the OpenAI tokenizers' terms.
+const (
+	messageTokens = 4   // Role and delimiters of a chat message
+	replyTokens   = 3   // Priming of the assistant's reply
+	imageTokens   = 765 // A high detail 1024x1024 image
+)
+
+// tokenFamilies are the characters per token of each model family's
+// tokenizer on English text, matched in order against the model name.
+var tokenFamilies = []struct {
+	prefix        string
+	charsPerToken float64
+}{
+	{"claude", 3.5},
+	{"mistral", 3.6},
+	{"mixtral", 3.6},
+	{"codestral", 3.6},
+	{"llama", 3.8},
+	{"deepseek", 3.8},
+	{"qwen", 3.8},
+	{"gemini", 4},
+	{"gemma", 4},
+	{"gpt-4o", 4.2},
+	{"gpt-4.1", 4.2},
+	{"gpt-5", 4.2},
+	{"o1", 4.2},
+	{"o3", 4.2},
+	{"o4", 4.2},
+}
+
+// WithTokenLimits estimates the prompt tokens of requests before the upstream
+// call, refuses prompts over their model's context window, and holds
+// max_tokens to the room left, per the policy.
+func (s *BaldrService) WithTokenLimits(config TokenLimitsConfig) *BaldrService {
+	if config.MaxTokens == "" {
+		config.MaxTokens = MaxTokensClamp
+	}
+	s.tokenLimits = &config
+	return s
+}
+
+// limitTokens checks the request's estimated prompt against its model's
+// context window and its key's per-request cap, and sets its max_tokens to
+// fit. Keys' caps hold without token limits too, and a capped key's
+// requests always get a max_tokens, or the cap would only bound the prompt.
+func (s *BaldrService) limitTokens(md *domain.RequestMetadata, payload []byte) ([]byte, error) {
+	keyCap := 0
+	if md.Key != nil {
+		keyCap = md.Key.Limits.MaxTokensPerRequest
+	}
+	config := s.tokenLimits
+	if config == nil {
+		if keyCap == 0 {
+			return payload, nil
+		}
+		config = &TokenLimitsConfig{MaxTokens: MaxTokensClamp}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var root map[string]any
+	if err := dec.Decode(&root); err != nil || root == nil {
+		return nil, fmt.Errorf("failed to read request for token limits: expected a JSON object")
+	}
+	estimate := estimatePrompt(md.Model, md.Endpoint, root)
+	md.PromptTokens = estimate.total
+
+	model := config.Models[md.Model]
+	if model.ContextWindow > 0 && estimate.largest > model.ContextWindow {
+		return nil, fmt.Errorf("%w: about %d prompt tokens for the %d of %s", domain.ErrContextLengthExceeded, estimate.largest, model.ContextWindow, md.Model)
+	}
+	if keyCap > 0 && estimate.total > keyCap {
+		return nil, fmt.Errorf("%w: about %d prompt tokens for the key's %d per request", domain.ErrTokenLimitExceeded, estimate.total, keyCap)
+	}
+
+	field := maxTokensField(md.Endpoint, root)
+	if field == "" {
+		return payload, nil // Nothing to answer with but the inputs' vectors
+	}
+	// room is the output that fits, -1 when nothing bounds it
+	room, bound := -1, domain.ErrContextLengthExceeded
+	if model.ContextWindow > 0 {
+		room = model.ContextWindow - estimate.total
+	}
+	if model.MaxOutputTokens > 0 && (room < 0 || model.MaxOutputTokens < room) {
+		room = model.MaxOutputTokens
+	}
+	if keyCap > 0 && (room < 0 || keyCap-estimate.total < room) {
+		room, bound = keyCap-estimate.total, domain.ErrTokenLimitExceeded
+	}
+	if room == 0 {
+		return nil, fmt.Errorf("%w: no tokens left for the answer", bound)
+	}
+
+	requested, asked := intField(root, field)
+	var limit int
+	switch {
+	case asked && (room < 0 || requested <= room):
+		return payload, nil
+	case asked && config.MaxTokens == MaxTokensReject:
+		return nil, fmt.Errorf("%w: %s is %d, %d fit", bound, field, requested, room)
+	case asked:
+		limit = room
+	case config.MaxTokens == MaxTokensInject || keyCap > 0:
+		limit = config.DefaultMaxTokens
+		if limit == 0 || (room > 0 && room < limit) {
+			limit = room
+		}
+		if limit < 0 {
+			return payload, nil
+		}
+	default:
+		return payload, nil
+	}
+	limited, err := setField(payload, field, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %s: %w", field, err)
+	}
+	return limited, nil
+}
+
+// maxTokensField is the field capping the request's output, empty for
+// endpoints without one. Chat requests may use the newer
+// max_completion_tokens.
+func maxTokensField(endpoint domain.Endpoint, root map[string]any) string {
+	switch endpoint {
+	case domain.EndpointCompletions:
+		return "max_tokens"
+	case domain.EndpointResponses:
+		return "max_output_tokens"
+	case domain.EndpointEmbeddings, domain.EndpointModerations:
+		return ""
+	}
+	if _, ok := root["max_completion_tokens"]; ok {
+		return "max_completion_tokens"
+	}
+	return "max_tokens"
+}
+
+func intField(root map[string]any, field string) (int, bool) {
+	n, ok := root[field].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	v, err := n.Int64()
+	return int(v), err == nil
+}
+
+// promptEstimate is the estimated prompt of a request. Batches of
+// embeddings are held to the context window one input at a time, so largest
+// is their largest input; for other requests it is the total.
+type promptEstimate struct {
+	total   int
+	largest int
+}
+
+func estimatePrompt(model string, endpoint domain.Endpoint, root map[string]any) promptEstimate {
+	e := newTokenEstimator(model)
+	var estimate promptEstimate
+	switch endpoint {
+	case domain.EndpointCompletions:
+		estimate.total = e.value(root["prompt"]) + e.value(root["suffix"])
+	case domain.EndpointEmbeddings, domain.EndpointModerations:
+		inputs, ok := root["input"].([]any)
+		if !ok {
+			inputs = []any{root["input"]}
+		}
+		for _, input := range inputs {
+			n := e.value(input)
+			estimate.total += n
+			estimate.largest = max(estimate.largest, n)
+		}
+		return estimate
+	case domain.EndpointResponses:
+		estimate.total = e.value(root["instructions"]) + e.value(root["input"]) + e.value(root["tools"])
+	default:
+		messages, _ := root["messages"].([]any)
+		for _, msg := range messages {
+			estimate.total += messageTokens + e.value(msg)
+		}
+		estimate.total += replyTokens + e.value(root["tools"]) + e.value(root["functions"])
+	}
+	estimate.largest = estimate.total
+	return estimate
+}
+
+// tokenEstimator approximates a tokenizer by the characters per token of
+// its model family. Text outside ASCII is counted a token per character,
+// which is about right for CJK scripts and errs on the safe side for others.
+type tokenEstimator struct {
+	charsPerToken float64
+}
+
+func newTokenEstimator(model string) tokenEstimator {
+	name := strings.ToLower(model[strings.LastIndex(model, "/")+1:])
+	for _, family := range tokenFamilies {
+		if strings.HasPrefix(name, family.prefix) {
+			return tokenEstimator{charsPerToken: family.charsPerToken}
+		}
+	}
+	return tokenEstimator{charsPerToken: 4}
+}
+
+func (e tokenEstimator) text(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return int(math.Ceil(float64(ascii)/e.charsPerToken)) + other
+}
+
+// value estimates the text of a JSON value: strings, the strings nested in
+// it and numbers, which are token IDs in prompts. Images count as a fixed
+// cost, and audio and files, which can't be estimated from their encoding,
+// not at all.
+func (e tokenEstimator) value(v any) int {
+	switch v := v.(type) {
+	case string:
+		return e.text(v)
+	case json.Number:
+		return 1
+	case []any:
+		n := 0
+		for _, item := range v {
+			n += e.value(item)
+		}
+		return n
+	case map[string]any:
+		switch v["type"] {
+		case "image_url", "input_image":
+			return imageTokens
+		case "input_audio", "file", "input_file":
+			return 0
+		}
+		n := 0
+		for _, item := range v {
+			n += e.value(item)
+		}
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// AdminHandler serves the lifecycle of virtual keys. It is meant for the
+// admin listener, behind RequireToken.
+type AdminHandler struct {
+	keys ports.KeyAdminPort
+}
+
+func NewAdminHandler(keys ports.KeyAdminPort) *AdminHandler {
+	return &AdminHandler{keys: keys}
+}
+
+// createdKey is a key along with its secret, which is only ever shown here.
+type createdKey struct {
+	*domain.VirtualKey
+	Secret string `json:"secret"`
+}
+
+func (h *AdminHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	var spec domain.KeySpec
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		http.Error(w, "invalid key settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, secret, err := h.keys.Create(r.Context(), spec)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createdKey{VirtualKey: key, Secret: secret})
+}
+
+// HandleList returns every key, or those of ?owner= and ?team=.
+func (h *AdminHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context())
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	owner, team := r.URL.Query().Get("owner"), r.URL.Query().Get("team")
+	listed := []*domain.VirtualKey{}
+	for _, key := range keys {
+		if (owner == "" || key.Owner == owner) && (team == "" || key.Team == team) {
+			listed = append(listed, key)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": listed})
+}
+
+func (h *AdminHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	key, err := h.keys.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// HandleUpdate merges the body into the key's settings, null clears a field.
+func (h *AdminHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	key, err := h.keys.Update(r.Context(), r.PathValue("id"), patch)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+func (h *AdminHandler) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	key, secret, err := h.keys.Rotate(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, createdKey{VirtualKey: key, Secret: secret})
+}
+
+func (h *AdminHandler) HandleStatus(status domain.KeyStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := h.keys.SetStatus(r.Context(), r.PathValue("id"), status)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, key)
+	}
+}
+
+func (h *AdminHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := h.keys.Delete(r.Context(), r.PathValue("id")); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, domain.ErrInvalidKeySpec):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,203 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/handlers"
+)
+
+// mockKeys serves a single key, "vk_1", whose secret is "sk-baldr-good".
+type mockKeys struct {
+	key domain.VirtualKey
+}
+
+func (m *mockKeys) Authenticate(ctx context.Context, secret string) (*domain.VirtualKey, error) {
+	switch secret {
+	case "sk-baldr-good":
+		return &m.key, nil
+	case "sk-baldr-suspended":
+		return nil, domain.ErrKeySuspended
+	}
+	return nil, domain.ErrInvalidKey
+}
+
+func (m *mockKeys) Create(ctx context.Context, spec domain.KeySpec) (*domain.VirtualKey, string, error) {
+	if spec.Owner == "" {
+		return nil, "", fmt.Errorf("%w: owner is required", domain.ErrInvalidKeySpec)
+	}
+	m.key.KeySpec = spec
+	return &m.key, "sk-baldr-good", nil
+}
+func (m *mockKeys) List(ctx context.Context) ([]*domain.VirtualKey, error) {
+	return []*domain.VirtualKey{&m.key}, nil
+}
+func (m *mockKeys) Get(ctx context.Context, id string) (*domain.VirtualKey, error) {
+	if id != m.key.ID {
+		return nil, domain.ErrKeyNotFound
+	}
+	return &m.key, nil
+}
+func (m *mockKeys) Update(ctx context.Context, id string, patch json.RawMessage) (*domain.VirtualKey, error) {
+	key, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return key, json.Unmarshal(patch, &key.KeySpec)
+}
+func (m *mockKeys) Rotate(ctx context.Context, id string) (*domain.VirtualKey, string, error) {
+	key, err := m.Get(ctx, id)
+	return key, "sk-baldr-rotated", err
+}
+func (m *mockKeys) SetStatus(ctx context.Context, id string, status domain.KeyStatus) (*domain.VirtualKey, error) {
+	key, err := m.Get(ctx, id)
+	if err == nil {
+		key.Status = status
+	}
+	return key, err
+}
+func (m *mockKeys) Delete(ctx context.Context, id string) error {
+	_, err := m.Get(ctx, id)
+	return err
+}
+
+func TestAdminHandler(t *testing.T) {
+	keys := &mockKeys{key: domain.VirtualKey{ID: "vk_1", Status: domain.KeyActive}}
+	admin := handlers.NewAdminHandler(keys)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /keys", admin.HandleCreate)
+	mux.HandleFunc("GET /keys", admin.HandleList)
+	mux.HandleFunc("GET /keys/{id}", admin.HandleGet)
+	mux.HandleFunc("PATCH /keys/{id}", admin.HandleUpdate)
+	mux.HandleFunc("POST /keys/{id}/rotate", admin.HandleRotate)
+	mux.HandleFunc("POST /keys/{id}/suspend", admin.HandleStatus(domain.KeySuspended))
+	mux.HandleFunc("DELETE /keys/{id}", admin.HandleDelete)
+	server := handlers.RequireToken("admin-token", mux)
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("wrong", "GET", "/keys", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be refused, got %d", w.Code)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		contains           string
+	}{
+		{"POST", "/keys", `{"owner": "ada", "team": "research", "budget_usd": 10}`, http.StatusCreated, `"secret":"sk-baldr-good"`},
+		{"POST", "/keys", `{"team": "research"}`, http.StatusBadRequest, "owner is required"},
+		{"POST", "/keys", `{"owner": "ada", "status": "active"}`, http.StatusBadRequest, "unknown field"},
+		{"GET", "/keys?team=research", "", http.StatusOK, `"owner":"ada"`},
+		{"GET", "/keys?team=other", "", http.StatusOK, `{"keys":[]}`},
+		{"GET", "/keys/vk_1", "", http.StatusOK, `"budget_usd":10`},
+		{"GET", "/keys/vk_2", "", http.StatusNotFound, ""},
+		{"PATCH", "/keys/vk_1", `{"team": "platform"}`, http.StatusOK, `"team":"platform"`},
+		{"POST", "/keys/vk_1/rotate", "", http.StatusOK, `"secret":"sk-baldr-rotated"`},
+		{"POST", "/keys/vk_1/suspend", "", http.StatusOK, `"status":"suspended"`},
+		{"DELETE", "/keys/vk_1", "", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		w := do("admin-token", tt.method, tt.path, tt.body)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s %s: expected %d with %s, got %d: %s", tt.method, tt.path, tt.status, tt.contains, w.Code, w.Body.String())
+		}
+	}
+	if w := do("admin-token", "GET", "/keys/vk_1", ""); strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Expected the secret to be shown only once, got %s", w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	handlers.RequireToken("", mux).ServeHTTP(w, httptest.NewRequest("GET", "/keys", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an empty admin token to let no one in, got %d", w.Code)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := &mockKeys{key: domain.VirtualKey{ID: "vk_1", KeySpec: domain.KeySpec{Owner: "ada", Team: "research"}}}
+	var seen *domain.VirtualKey
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = domain.VirtualKeyFrom(r.Context())
+		md := handlers.RequestMetadata(r, nil)
+		if seen != nil && (md.KeyID != "vk_1" || md.Team != "research") {
+			t.Errorf("Expected the key to decide who the caller is, got %+v", md)
+		}
+	})
+
+	tests := []struct {
+		token    string
+		required bool
+		status   int
+		key      bool
+	}{
+		{"sk-baldr-good", false, http.StatusOK, true},
+		{"sk-baldr-unknown", false, http.StatusUnauthorized, false},
+		{"sk-baldr-suspended", false, http.StatusUnauthorized, false},
+		{"sk-upstream", false, http.StatusOK, false},
+		{"sk-upstream", true, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		seen = nil
+		r := httptest.NewRequest("POST", "/chat/completions", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		r.Header.Set("X-Baldr-Team", "spoofed")
+		w := httptest.NewRecorder()
+		handlers.Authenticate(keys, tt.required, next).ServeHTTP(w, r)
+		if w.Code != tt.status || (seen != nil) != tt.key {
+			t.Errorf("%s (required %v): expected %d, got %d: %s", tt.token, tt.required, tt.status, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && !strings.Contains(w.Body.String(), "invalid_api_key") {
+			t.Errorf("Expected an OpenAI-style error, got %s", w.Body.String())
+		}
+	}
+}
+
+// refusingService refuses every request with err.
+type refusingService struct{ err error }
+
+func (s refusingService) Execute(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+	return nil, s.err
+}
+
+func TestHandleProxy_Refusals(t *testing.T) {
+	valid := `{"model": "m", "messages": [{"role": "user", "content": "hi"}]}`
+	for err, status := range map[error]int{
+		fmt.Errorf("%w: 10 requests per minute", domain.ErrRateLimited): http.StatusTooManyRequests,
+		domain.ErrBudgetExceeded:  http.StatusTooManyRequests,
+		domain.ErrModelNotAllowed: http.StatusForbidden,
+		fmt.Errorf("%w: about 120 prompt tokens", domain.ErrContextLengthExceeded): http.StatusBadRequest,
+		domain.ErrTokenLimitExceeded: http.StatusBadRequest,
+		errors.New("blocked: pii"):   http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		handlers.NewHTTPHandler(refusingService{err}).HandleProxy(w, httptest.NewRequest("POST", "/chat/completions", strings.NewReader(valid)))
+		if w.Code != status {
+			t.Errorf("%v: expected %d, got %d", err, status, w.Code)
+		}
+	}
+
+	violation := &domain.PolicyViolation{Policy: "support", Rule: "max_tokens", Param: "max_tokens", Reason: "max_tokens is 2000, at most 1000 is allowed"}
+	w := httptest.NewRecorder()
+	handlers.NewHTTPHandler(refusingService{fmt.Errorf("wrapped: %w", violation)}).HandleProxy(w, httptest.NewRequest("POST", "/chat/completions", strings.NewReader(valid)))
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Baldr-Policy-Violation") != "support/max_tokens" {
+		t.Errorf("Expected a 403 naming the rule, got %d and %q", w.Code, w.Header().Get("X-Baldr-Policy-Violation"))
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"param":"max_tokens"`) || !strings.Contains(body, `"code":"policy_violation"`) {
+		t.Errorf("Unexpected error %s", body)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// Authenticate resolves the virtual key a request presents and attaches it
+// to the request context. Unknown, suspended or expired virtual keys are
+// refused; other bearer tokens pass through unless required is set.
+func Authenticate(keys ports.KeyAuthPort, required bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if !strings.HasPrefix(token, domain.VirtualKeyPrefix) {
+			if required {
+				writeRefusal(w, fmt.Errorf("%w: a virtual key is required", domain.ErrInvalidKey))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := keys.Authenticate(r.Context(), token)
+		if err != nil {
+			writeRefusal(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(domain.WithVirtualKey(r.Context(), key)))
+	})
+}
+
+// RequireToken guards a handler with a static bearer token. An empty token
+// lets no one in.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
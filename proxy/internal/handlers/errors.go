@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// apiError is the body of an OpenAI-style error response, which clients
+// built on the OpenAI SDKs know how to surface.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// writeInvalidRequest rejects a request the client must fix. param names the
+// offending field, empty when the problem is with the body as a whole.
+func writeInvalidRequest(w http.ResponseWriter, status int, message, param string) {
+	detail := apiErrorDetail{Message: message, Type: "invalid_request_error"}
+	if param != "" {
+		detail.Param = &param
+	}
+	writeAPIError(w, status, detail)
+}
+
+// writeRefusal rejects a request its virtual key, its model's limits or its
+// policies don't allow, with the status and code the OpenAI API uses for the
+// same cases, and returns the status. Policy violations name the rule that
+// fired in the X-Baldr-Policy-Violation header.
+func writeRefusal(w http.ResponseWriter, err error) int {
+	status, errType, code := http.StatusForbidden, "invalid_request_error", "model_not_found"
+	var param *string
+	var violation *domain.PolicyViolation
+	switch {
+	case errors.As(err, &violation):
+		code = "policy_violation"
+		if violation.Param != "" {
+			param = &violation.Param
+		}
+		w.Header().Set("X-Baldr-Policy-Violation", violation.Policy+"/"+violation.Rule)
+	case errors.Is(err, domain.ErrContextLengthExceeded):
+		status, code = http.StatusBadRequest, "context_length_exceeded"
+	case errors.Is(err, domain.ErrTokenLimitExceeded):
+		status, code = http.StatusBadRequest, "token_limit_exceeded"
+	case errors.Is(err, domain.ErrInvalidKey), errors.Is(err, domain.ErrKeySuspended), errors.Is(err, domain.ErrKeyExpired):
+		status, code = http.StatusUnauthorized, "invalid_api_key"
+	case errors.Is(err, domain.ErrRateLimited):
+		status, errType, code = http.StatusTooManyRequests, "requests", "rate_limit_exceeded"
+	case errors.Is(err, domain.ErrBudgetExceeded):
+		status, errType, code = http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"
+	}
+	writeAPIError(w, status, apiErrorDetail{Message: err.Error(), Type: errType, Param: param, Code: &code})
+	return status
+}
+
+// isRefusal reports whether err is a virtual key, a model's limits or a
+// policy refusing a request.
+func isRefusal(err error) bool {
+	for _, target := range []error{
+		domain.ErrInvalidKey, domain.ErrKeySuspended, domain.ErrKeyExpired, domain.ErrModelNotAllowed, domain.ErrRateLimited, domain.ErrBudgetExceeded,
+		domain.ErrContextLengthExceeded, domain.ErrTokenLimitExceeded, domain.ErrPolicyViolation,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeAPIError(w http.ResponseWriter, status int, detail apiErrorDetail) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: detail})
+}
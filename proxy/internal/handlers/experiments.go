@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// ExperimentsHandler serves the per-variant results of A/B experiments and
+// takes user feedback on their requests.
+type ExperimentsHandler struct {
+	stats ports.ExperimentStatsPort
+}
+
+func NewExperimentsHandler(stats ports.ExperimentStatsPort) *ExperimentsHandler {
+	return &ExperimentsHandler{stats: stats}
+}
+
+// HandleResults returns the aggregates of every variant, or of a single
+// experiment with ?experiment=name.
+func (h *ExperimentsHandler) HandleResults(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("experiment")
+	variants := []domain.VariantStats{}
+	for _, v := range h.stats.Results() {
+		if name == "" || v.Experiment == name {
+			variants = append(variants, v)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"variants": variants})
+}
+
+// HandleFeedback scores a request: {"request_id": "...", "score": 1}, where
+// the score goes from 0 (bad) to 1 (good).
+func (h *ExperimentsHandler) HandleFeedback(w http.ResponseWriter, r *http.Request) {
+	var feedback struct {
+		RequestID string   `json:"request_id"`
+		Score     *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&feedback); err != nil || feedback.RequestID == "" || feedback.Score == nil {
+		http.Error(w, "expected {\"request_id\": string, \"score\": number}", http.StatusBadRequest)
+		return
+	}
+	err := h.stats.Feedback(feedback.RequestID, *feedback.Score)
+	switch {
+	case errors.Is(err, domain.ErrUnknownRequest):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
 	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
 )
 
@@ -15,32 +18,84 @@ type HTTPHandler struct {
 func NewHTTPHandler(s ports.ProxyServicePort) *HTTPHandler {
 	return &HTTPHandler{service: s}
 }
+
+// HandleProxy serves chat completions.
 func (h *HTTPHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
+	h.HandleEndpoint(domain.EndpointChat)(w, r)
+}
+
+// HandleEndpoint serves one of the OpenAI-compatible endpoints that take a
+// JSON body, which are all proxied the same way.
+func (h *HTTPHandler) HandleEndpoint(endpoint domain.Endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.proxy(endpoint, w, r)
+	}
+}
+
+func (h *HTTPHandler) proxy(endpoint domain.Endpoint, w http.ResponseWriter, r *http.Request) {
 	// 1. Buffer the body (We need it for both Guardrail and LLM)
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeInvalidRequest(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large, the limit is %d bytes.", tooLarge.Limit), "")
+			return
+		}
 		http.Error(w, "Failed to read body", http.StatusBadRequest)
 		return
 	}
 	r.Body.Close() // Close the original reader
 
+	// Malformed requests stop here, before they take a guardrail slot or
+	// upstream quota
+	if invalid := validateEndpointRequest(endpoint, body); invalid != nil {
+		writeInvalidRequest(w, http.StatusBadRequest, invalid.message, invalid.param)
+		return
+	}
+
 	// Extract headers to forward
 	headers := make(map[string]string)
 	headers["Content-Type"] = r.Header.Get("Content-Type")
 	headers["Authorization"] = r.Header.Get("Authorization")
 
+	// Describe the caller so the guardrail knows who is asking and for what
+	md := RequestMetadata(r, body)
+	md.Endpoint = endpoint
+	w.Header().Set("X-Request-ID", md.RequestID)
+	ctx := domain.WithRequestMetadata(r.Context(), md)
+
 	// 2. Call Service
-	respStream, err := h.service.Execute(r.Context(), body, headers)
+	respStream, err := h.service.Execute(ctx, body, headers)
+	if md.GuardrailAction != "" {
+		w.Header().Set("X-Baldr-Guardrail", string(md.GuardrailAction))
+	}
+	if md.CacheStatus != "" {
+		w.Header().Set("X-Baldr-Cache-Status", string(md.CacheStatus))
+	}
+	if md.Experiment != "" {
+		w.Header().Set("X-Baldr-Experiment", md.Experiment)
+		w.Header().Set("X-Baldr-Variant", md.Variant)
+	}
+	if err != nil && isRefusal(err) {
+		logRequest(md, writeRefusal(w, err))
+		return
+	}
 	if err != nil {
+		logRequest(md, http.StatusForbidden)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
+	defer logRequest(md, http.StatusOK)
 	defer respStream.Close()
 
-	// headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	// headers for SSE. Other endpoints only stream when asked to.
+	if endpoint.IsChat() || isStreaming(body) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
 
 	// The Flushing Loop
 	flusher, ok := w.(http.Flusher)
@@ -71,3 +126,11 @@ func (h *HTTPHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+func isStreaming(body []byte) bool {
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Stream
+}
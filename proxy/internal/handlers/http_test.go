@@ -0,0 +1,194 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/handlers"
+)
+
+type TestMockService struct {
+	calls    int
+	endpoint domain.Endpoint
+}
+
+func (s *TestMockService) Execute(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+	s.calls++
+	s.endpoint = domain.RequestMetadataFrom(ctx).Endpoint
+	return io.NopCloser(strings.NewReader("data: [DONE]\n\n")), nil
+}
+
+func TestHandleProxy_Validation(t *testing.T) {
+	valid := `{"model": "m", "messages": [{"role": "user", "content": "hi"}]}`
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		param  string
+	}{
+		{"valid", valid, http.StatusOK, ""},
+		{"content parts and tool calls", `{"model": "m", "temperature": 0.2, "stop": ["a"], "messages": [
+			{"role": "user", "content": [{"type": "text", "text": "hi"}, {"type": "image_url", "image_url": {"url": "x"}}]},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "c1"}]},
+			{"role": "tool", "tool_call_id": "c1", "content": "42"}]}`, http.StatusOK, ""},
+		{"not JSON", `{"model": `, http.StatusBadRequest, ""},
+		{"trailing data", valid + `{}`, http.StatusBadRequest, ""},
+		{"not an object", `[]`, http.StatusBadRequest, ""},
+		{"no model", `{"messages": [{"role": "user", "content": "hi"}]}`, http.StatusBadRequest, "model"},
+		{"no messages", `{"model": "m"}`, http.StatusBadRequest, "messages"},
+		{"empty messages", `{"model": "m", "messages": []}`, http.StatusBadRequest, "messages"},
+		{"bad role", `{"model": "m", "messages": [{"role": "root", "content": "hi"}]}`, http.StatusBadRequest, "messages[0].role"},
+		{"missing content", `{"model": "m", "messages": [{"role": "user"}]}`, http.StatusBadRequest, "messages[0].content"},
+		{"tool without call id", `{"model": "m", "messages": [{"role": "tool", "content": "42"}]}`, http.StatusBadRequest, "messages[0].tool_call_id"},
+		{"text part without text", `{"model": "m", "messages": [{"role": "user", "content": [{"type": "text"}]}]}`, http.StatusBadRequest, "messages[0].content[0].text"},
+		{"temperature too high", `{"model": "m", "temperature": 3, "messages": [{"role": "user", "content": "hi"}]}`, http.StatusBadRequest, "temperature"},
+		{"temperature as string", `{"model": "m", "temperature": "hot", "messages": [{"role": "user", "content": "hi"}]}`, http.StatusBadRequest, "temperature"},
+		{"fractional n", `{"model": "m", "n": 1.5, "messages": [{"role": "user", "content": "hi"}]}`, http.StatusBadRequest, "n"},
+		{"too many stops", `{"model": "m", "stop": ["a", "b", "c", "d", "e"], "messages": [{"role": "user", "content": "hi"}]}`, http.StatusBadRequest, "stop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &TestMockService{}
+			handler := handlers.NewHTTPHandler(service)
+			w := httptest.NewRecorder()
+			handler.HandleProxy(w, httptest.NewRequest("POST", "/chat/completions", strings.NewReader(tt.body)))
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			if service.calls != 0 {
+				t.Error("Invalid requests must not reach the service")
+			}
+			var body struct {
+				Error struct {
+					Message string  `json:"message"`
+					Type    string  `json:"type"`
+					Param   *string `json:"param"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected an OpenAI-style error, got %s", w.Body.String())
+			}
+			param := ""
+			if body.Error.Param != nil {
+				param = *body.Error.Param
+			}
+			if body.Error.Type != "invalid_request_error" || body.Error.Message == "" || param != tt.param {
+				t.Errorf("Unexpected error %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	service := &TestMockService{}
+	handler := handlers.LimitBody(64, http.HandlerFunc(handlers.NewHTTPHandler(service).HandleProxy))
+
+	body := `{"model": "m", "messages": [{"role": "user", "content": "` + strings.Repeat("a", 100) + `"}]}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/chat/completions", strings.NewReader(body)))
+
+	if w.Code != http.StatusRequestEntityTooLarge || service.calls != 0 {
+		t.Errorf("Expected 413 without calling the service, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "invalid_request_error") {
+		t.Errorf("Expected an OpenAI-style error, got %s", w.Body.String())
+	}
+}
+
+func TestHandleEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    domain.Endpoint
+		body        string
+		status      int
+		param       string
+		contentType string
+	}{
+		{"completion", domain.EndpointCompletions, `{"model": "m", "prompt": ["a", "b"], "logprobs": 2}`, http.StatusOK, "", "application/json"},
+		{"streamed completion", domain.EndpointCompletions, `{"model": "m", "prompt": "a", "stream": true}`, http.StatusOK, "", "text/event-stream"},
+		{"completion without prompt", domain.EndpointCompletions, `{"model": "m"}`, http.StatusBadRequest, "prompt", ""},
+		{"completion as token IDs", domain.EndpointCompletions, `{"model": "m", "prompt": [[1234, 567]]}`, http.StatusBadRequest, "prompt", ""},
+		{"completion logprobs too high", domain.EndpointCompletions, `{"model": "m", "prompt": "a", "logprobs": 6}`, http.StatusBadRequest, "logprobs", ""},
+		{"embeddings", domain.EndpointEmbeddings, `{"model": "m", "input": "a"}`, http.StatusOK, "", "application/json"},
+		{"embeddings without model", domain.EndpointEmbeddings, `{"input": "a"}`, http.StatusBadRequest, "model", ""},
+		{"embeddings empty input", domain.EndpointEmbeddings, `{"model": "m", "input": []}`, http.StatusBadRequest, "input", ""},
+		{"embeddings as token IDs", domain.EndpointEmbeddings, `{"model": "m", "input": [1234, 567]}`, http.StatusBadRequest, "input", ""},
+		{"embeddings input as object", domain.EndpointEmbeddings, `{"model": "m", "input": {}}`, http.StatusBadRequest, "input", ""},
+		{"moderations without model", domain.EndpointModerations, `{"input": "a"}`, http.StatusOK, "", "application/json"},
+		{"responses without input", domain.EndpointResponses, `{"model": "m", "previous_response_id": "r"}`, http.StatusOK, "", "application/json"},
+		{"responses bad max_output_tokens", domain.EndpointResponses, `{"model": "m", "input": "a", "max_output_tokens": 0}`, http.StatusBadRequest, "max_output_tokens", ""},
+		{"not an object", domain.EndpointResponses, `"a"`, http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &TestMockService{}
+			handler := handlers.NewHTTPHandler(service).HandleEndpoint(tt.endpoint)
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest("POST", "/v1/"+string(tt.endpoint), strings.NewReader(tt.body)))
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if service.calls != 0 || !strings.Contains(w.Body.String(), `"param":`+paramJSON(tt.param)) {
+					t.Errorf("Expected an error on %q without calling the service, got %s", tt.param, w.Body.String())
+				}
+				return
+			}
+			if service.endpoint != tt.endpoint {
+				t.Errorf("Expected the request to be tagged %s, got %q", tt.endpoint, service.endpoint)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Expected content type %s, got %s", tt.contentType, got)
+			}
+		})
+	}
+}
+
+func paramJSON(param string) string {
+	if param == "" {
+		return "null"
+	}
+	return `"` + param + `"`
+}
+
+type modelsFunc func(ctx context.Context) (io.ReadCloser, error)
+
+func (f modelsFunc) Models(ctx context.Context) (io.ReadCloser, error) { return f(ctx) }
+
+func TestHandleModels(t *testing.T) {
+	list := `{"object": "list", "data": [{"id": "m", "object": "model"}]}`
+	handler := handlers.NewModelsHandler(modelsFunc(func(ctx context.Context) (io.ReadCloser, error) {
+		if domain.RequestMetadataFrom(ctx).Endpoint != domain.EndpointModels {
+			t.Error("Expected the request to be tagged as a models request")
+		}
+		return io.NopCloser(strings.NewReader(list)), nil
+	}))
+	w := httptest.NewRecorder()
+	handler.HandleModels(w, httptest.NewRequest("GET", "/v1/models", nil))
+	if w.Code != http.StatusOK || w.Body.String() != list {
+		t.Errorf("Expected the upstream list, got %d: %s", w.Code, w.Body.String())
+	}
+
+	failing := handlers.NewModelsHandler(modelsFunc(func(ctx context.Context) (io.ReadCloser, error) {
+		return nil, errors.New("upstream down")
+	}))
+	w = httptest.NewRecorder()
+	failing.HandleModels(w, httptest.NewRequest("GET", "/models", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 when the upstream fails, got %d", w.Code)
+	}
+}
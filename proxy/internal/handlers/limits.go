@@ -0,0 +1,15 @@
+package handlers
+
+import "net/http"
+
+// LimitBody caps the size of request bodies on a route. Handlers reading
+// past the limit get an *http.MaxBytesError.
+func LimitBody(maxBytes int64, next http.Handler) http.Handler {
+	if maxBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
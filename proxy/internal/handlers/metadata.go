@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// RequestMetadata describes the caller from the request headers and body.
+func RequestMetadata(r *http.Request, body []byte) *domain.RequestMetadata {
+	md := &domain.RequestMetadata{
+		RequestID: r.Header.Get("X-Request-ID"),
+		KeyID:     keyFingerprint(r.Header.Get("Authorization")),
+		Team:      r.Header.Get("X-Baldr-Team"),
+		Direction: domain.DirectionInput,
+		PolicyID:  r.Header.Get("X-Baldr-Policy"),
+		User:      r.Header.Get("X-Baldr-User"),
+		// "true" or "false" to force or skip the response cache
+		ResponseCache: strings.ToLower(r.Header.Get("X-Baldr-Cache")),
+	}
+	if md.RequestID == "" {
+		md.RequestID = newRequestID()
+	}
+	// A virtual key decides who the caller is, headers can't override it
+	if key := domain.VirtualKeyFrom(r.Context()); key != nil {
+		md.Key = key
+		md.KeyID = key.ID
+		md.Team = key.Team
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		User  string `json:"user"`
+	}
+	if json.Unmarshal(body, &req) == nil {
+		md.Model = req.Model
+		if md.User == "" {
+			md.User = req.User
+		}
+	}
+	return md
+}
+
+// logRequest writes the access log line of a request, tagged with its
+// experiment and variant when it took part in one.
+func logRequest(md *domain.RequestMetadata, status int) {
+	line := fmt.Sprintf("Request %s: status %d, model %s", md.RequestID, status, md.Model)
+	if md.GuardrailAction != "" {
+		line += ", guardrail " + string(md.GuardrailAction)
+	}
+	if md.CacheStatus != "" {
+		line += ", cache " + string(md.CacheStatus)
+	}
+	if md.Experiment != "" {
+		line += fmt.Sprintf(", experiment %s, variant %s", md.Experiment, md.Variant)
+	}
+	log.Print(line)
+}
+
+// keyFingerprint identifies the caller's key without ever logging or
+// forwarding the secret itself.
+func keyFingerprint(authorization string) string {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "key-" + hex.EncodeToString(sum[:6])
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
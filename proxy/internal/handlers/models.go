@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// ModelsHandler serves the upstream's model list.
+type ModelsHandler struct {
+	models ports.ModelsPort
+}
+
+func NewModelsHandler(models ports.ModelsPort) *ModelsHandler {
+	return &ModelsHandler{models: models}
+}
+
+func (h *ModelsHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
+	md := RequestMetadata(r, nil)
+	md.Endpoint = domain.EndpointModels
+	w.Header().Set("X-Request-ID", md.RequestID)
+
+	models, err := h.models.Models(domain.WithRequestMetadata(r.Context(), md))
+	if err != nil {
+		logRequest(md, http.StatusBadGateway)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer models.Close()
+	logRequest(md, http.StatusOK)
+
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, models)
+}
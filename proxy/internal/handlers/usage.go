@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/core/ports"
+)
+
+// UsageHandler serves the token usage and cost of upstream calls.
+type UsageHandler struct {
+	usage ports.UsagePort
+}
+
+func NewUsageHandler(usage ports.UsagePort) *UsageHandler {
+	return &UsageHandler{usage: usage}
+}
+
+// HandleSummary returns the totals by endpoint and model.
+func (h *UsageHandler) HandleSummary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"usage": h.usage.Summary()})
+}
+
+// HandleReport returns the totals over a range, e.g.
+// ?from=2026-09-01&to=2026-10-01&group_by=team,model&interval=day. The range
+// defaults to the current month, and &format=csv exports it as CSV.
+func (h *UsageHandler) HandleReport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	now := time.Now().UTC()
+	query := domain.UsageQuery{
+		From:     time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+		Interval: params.Get("interval"),
+	}
+	for name, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := params.Get(name); value != "" {
+			t, err := parseTime(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: expected a date or an RFC 3339 time, got %q", name, value), http.StatusBadRequest)
+				return
+			}
+			*bound = t
+		}
+	}
+	if groupBy := params.Get("group_by"); groupBy != "" {
+		query.GroupBy = strings.Split(groupBy, ",")
+	}
+
+	report, err := h.usage.Report(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.Get("format") == "csv" || r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		writeUsageCSV(w, query, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		From  time.Time             `json:"from"`
+		To    time.Time             `json:"to,omitzero"`
+		Usage []domain.UsageSummary `json:"usage"`
+	}{query.From, query.To, report})
+}
+
+func writeUsageCSV(w http.ResponseWriter, query domain.UsageQuery, report []domain.UsageSummary) {
+	var header []string
+	if query.Interval != "" {
+		header = append(header, "period")
+	}
+	header = append(header, query.GroupBy...)
+	header = append(header, "requests", "errors", "prompt_tokens", "completion_tokens", "total_tokens", "cost_usd")
+
+	out := csv.NewWriter(w)
+	out.Write(header)
+	for _, row := range report {
+		var record []string
+		if query.Interval != "" {
+			record = append(record, row.Period.Format(time.RFC3339))
+		}
+		for _, dim := range query.GroupBy {
+			record = append(record, map[string]string{
+				"key":      row.KeyID,
+				"team":     row.Team,
+				"model":    row.Model,
+				"provider": row.Provider,
+				"endpoint": string(row.Endpoint),
+			}[dim])
+		}
+		record = append(record,
+			strconv.Itoa(row.Requests),
+			strconv.Itoa(row.Errors),
+			strconv.Itoa(row.PromptTokens),
+			strconv.Itoa(row.CompletionTokens),
+			strconv.Itoa(row.TotalTokens),
+			strconv.FormatFloat(row.CostUSD, 'f', 6, 64),
+		)
+		out.Write(record)
+	}
+	out.Flush()
+}
+
+// parseTime reads a date (2026-10-01, midnight UTC) or an RFC 3339 time.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
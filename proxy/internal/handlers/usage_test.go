@@ -0,0 +1,65 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/handlers"
+)
+
+// usageReports answers every report with the same rows and keeps the query.
+type usageReports struct {
+	query domain.UsageQuery
+	rows  []domain.UsageSummary
+}
+
+func (u *usageReports) Record(record domain.UsageRecord) {}
+func (u *usageReports) Summary() []domain.UsageSummary   { return u.rows }
+func (u *usageReports) Report(query domain.UsageQuery) ([]domain.UsageSummary, error) {
+	u.query = query
+	if query.Interval == "week" {
+		return nil, errors.New("unknown interval")
+	}
+	return u.rows, nil
+}
+
+func TestHandleReport(t *testing.T) {
+	day := time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)
+	usage := &usageReports{rows: []domain.UsageSummary{
+		{Period: day, Team: "research", Model: "flash", Requests: 2, TotalTokens: 30, CostUSD: 0.5},
+	}}
+	handler := handlers.NewUsageHandler(usage)
+
+	w := httptest.NewRecorder()
+	handler.HandleReport(w, httptest.NewRequest("GET", "/usage/report?from=2026-09-30&to=2026-10-01T00:00:00Z&group_by=team,model&interval=day", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"team":"research"`) || !strings.Contains(w.Body.String(), `"from":"2026-09-30T00:00:00Z"`) {
+		t.Errorf("Unexpected report %d: %s", w.Code, w.Body.String())
+	}
+	if !usage.query.From.Equal(day) || !usage.query.To.Equal(day.Add(24*time.Hour)) || strings.Join(usage.query.GroupBy, ",") != "team,model" {
+		t.Errorf("Unexpected query %+v", usage.query)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleReport(w, httptest.NewRequest("GET", "/usage/report?group_by=team,model&interval=day&format=csv", nil))
+	expected := "period,team,model,requests,errors,prompt_tokens,completion_tokens,total_tokens,cost_usd\n" +
+		"2026-09-30T00:00:00Z,research,flash,2,0,0,0,30,0.500000\n"
+	if w.Header().Get("Content-Type") != "text/csv" || w.Body.String() != expected {
+		t.Errorf("Unexpected CSV %q", w.Body.String())
+	}
+	if now := time.Now().UTC(); usage.query.From.Day() != 1 || usage.query.From.Month() != now.Month() {
+		t.Errorf("Expected the range to default to the current month, got %v", usage.query.From)
+	}
+
+	for _, query := range []string{"from=yesterday", "interval=week"} {
+		w = httptest.NewRecorder()
+		handler.HandleReport(w, httptest.NewRequest("GET", "/usage/report?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// validationError explains why a request was rejected and which field is
+// at fault, in the shape of the OpenAI error's param ("messages[2].role").
+type validationError struct {
+	param   string
+	message string
+}
+
+func (e *validationError) Error() string { return e.message }
+
+func invalid(param, format string, args ...any) *validationError {
+	return &validationError{param: param, message: fmt.Sprintf(format, args...)}
+}
+
+var chatRoles = []string{"system", "developer", "user", "assistant", "tool", "function"}
+
+// numberRange bounds a numeric parameter of a chat request.
+type numberRange struct {
+	min, max float64
+	integer  bool
+}
+
+var chatParams = map[string]numberRange{
+	"temperature":           {min: 0, max: 2},
+	"top_p":                 {min: 0, max: 1},
+	"presence_penalty":      {min: -2, max: 2},
+	"frequency_penalty":     {min: -2, max: 2},
+	"n":                     {min: 1, max: 128, integer: true},
+	"max_tokens":            {min: 1, max: math.MaxInt32, integer: true},
+	"max_completion_tokens": {min: 1, max: math.MaxInt32, integer: true},
+	"top_logprobs":          {min: 0, max: 20, integer: true},
+	"seed":                  {min: math.MinInt64, max: math.MaxInt64, integer: true},
+}
+
+// validateChatRequest checks a chat completion body against the OpenAI
+// schema: a model, a well formed list of messages and parameters within
+// range. Fields it doesn't know are left for the upstream to judge.
+func validateChatRequest(body []byte) *validationError {
+	fields, err := decodeObject(body)
+	if err != nil {
+		return invalid("", "We could not parse the JSON body of your request: %v", err)
+	}
+
+	var model string
+	if err := decodeField(fields, "model", &model); err != nil {
+		return err
+	}
+	if model == "" {
+		return invalid("model", "you must provide a model parameter")
+	}
+
+	if isNull(fields["messages"]) {
+		return invalid("messages", "Missing required parameter: 'messages'.")
+	}
+	var messages []json.RawMessage
+	if err := decodeField(fields, "messages", &messages); err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return invalid("messages", "Invalid 'messages': empty array. Expected an array with minimum length 1.")
+	}
+	for i, raw := range messages {
+		if err := validateMessage(fmt.Sprintf("messages[%d]", i), raw); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{"stream", "logprobs", "parallel_tool_calls"} {
+		var b bool
+		if err := decodeField(fields, name, &b); err != nil {
+			return err
+		}
+	}
+	if err := validateNumbers(fields, chatParams); err != nil {
+		return err
+	}
+	return validateStop(fields["stop"])
+}
+
+var completionParams = map[string]numberRange{
+	"temperature":       chatParams["temperature"],
+	"top_p":             chatParams["top_p"],
+	"presence_penalty":  chatParams["presence_penalty"],
+	"frequency_penalty": chatParams["frequency_penalty"],
+	"n":                 chatParams["n"],
+	"max_tokens":        chatParams["max_tokens"],
+	"seed":              chatParams["seed"],
+	"logprobs":          {min: 0, max: 5, integer: true},
+}
+
+var responseParams = map[string]numberRange{
+	"temperature":       chatParams["temperature"],
+	"top_p":             chatParams["top_p"],
+	"max_output_tokens": {min: 1, max: math.MaxInt32, integer: true},
+}
+
+// validateEndpointRequest checks a request to an endpoint other than chat
+// completions: a JSON object with the fields the endpoint can't do without,
+// the free text inputs in a shape the guardrail can read and parameters
+// within range.
+func validateEndpointRequest(endpoint domain.Endpoint, body []byte) *validationError {
+	if endpoint.IsChat() {
+		return validateChatRequest(body)
+	}
+	fields, err := decodeObject(body)
+	if err != nil {
+		return invalid("", "We could not parse the JSON body of your request: %v", err)
+	}
+
+	var model string
+	if err := decodeField(fields, "model", &model); err != nil {
+		return err
+	}
+	// Moderations fall back to the upstream's default model
+	if model == "" && endpoint != domain.EndpointModerations {
+		return invalid("model", "you must provide a model parameter")
+	}
+	var stream bool
+	if err := decodeField(fields, "stream", &stream); err != nil {
+		return err
+	}
+
+	switch endpoint {
+	case domain.EndpointCompletions:
+		if err := validateInput(fields, "prompt", true); err != nil {
+			return err
+		}
+		if err := validateNumbers(fields, completionParams); err != nil {
+			return err
+		}
+		return validateStop(fields["stop"])
+	case domain.EndpointEmbeddings, domain.EndpointModerations:
+		return validateInput(fields, "input", true)
+	case domain.EndpointResponses:
+		var instructions string
+		if err := decodeField(fields, "instructions", &instructions); err != nil {
+			return err
+		}
+		// A response may continue a previous one without new input
+		if err := validateInput(fields, "input", false); err != nil {
+			return err
+		}
+		return validateNumbers(fields, responseParams)
+	}
+	return nil
+}
+
+// validateInput checks that an input field is a string or an array, and not
+// of token IDs, which the guardrail can't read.
+func validateInput(fields map[string]json.RawMessage, name string, required bool) *validationError {
+	raw := fields[name]
+	if isNull(raw) {
+		if required {
+			return invalid(name, "Missing required parameter: '%s'.", name)
+		}
+		return nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return nil
+	}
+	var items []json.RawMessage
+	if json.Unmarshal(raw, &items) != nil {
+		return invalid(name, "Invalid type for '%s': expected a string or an array.", name)
+	}
+	if len(items) == 0 {
+		return invalid(name, "Invalid '%s': empty array. Expected an array with minimum length 1.", name)
+	}
+	for _, item := range items {
+		if item = bytes.TrimSpace(item); len(item) > 0 && (item[0] == '[' || item[0] == '-' || (item[0] >= '0' && item[0] <= '9')) {
+			return invalid(name, "Invalid '%s': token IDs are not supported, the guardrail can only check text.", name)
+		}
+	}
+	return nil
+}
+
+func validateNumbers(fields map[string]json.RawMessage, params map[string]numberRange) *validationError {
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		if err := validateNumber(fields, name, params[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateMessage(param string, raw json.RawMessage) *validationError {
+	message, err := decodeObject(raw)
+	if err != nil {
+		return invalid(param, "Invalid type for '%s': expected an object.", param)
+	}
+
+	var role string
+	if err := decodeField(message, "role", &role, param); err != nil {
+		return err
+	}
+	if role == "" {
+		return invalid(param+".role", "Missing required parameter: '%s.role'.", param)
+	}
+	if !slices.Contains(chatRoles, role) {
+		return invalid(param+".role", "Invalid value: '%s'. Supported values are: %s.", role, quoteAll(chatRoles))
+	}
+	if role == "tool" {
+		var toolCallID string
+		if err := decodeField(message, "tool_call_id", &toolCallID, param); err != nil {
+			return err
+		}
+		if toolCallID == "" {
+			return invalid(param+".tool_call_id", "Missing required parameter: '%s.tool_call_id'.", param)
+		}
+	}
+
+	content := message["content"]
+	if isNull(content) {
+		// Only an assistant calling tools may leave its content out
+		if role == "assistant" && (!isNull(message["tool_calls"]) || !isNull(message["function_call"])) {
+			return nil
+		}
+		return invalid(param+".content", "Missing required parameter: '%s.content'.", param)
+	}
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return nil
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(content, &parts) != nil {
+		return invalid(param+".content", "Invalid type for '%s.content': expected a string or an array of content parts.", param)
+	}
+	for j, rawPart := range parts {
+		partParam := fmt.Sprintf("%s.content[%d]", param, j)
+		part, err := decodeObject(rawPart)
+		if err != nil {
+			return invalid(partParam, "Invalid type for '%s': expected an object.", partParam)
+		}
+		var partType string
+		if err := decodeField(part, "type", &partType, partParam); err != nil {
+			return err
+		}
+		if partType == "" {
+			return invalid(partParam+".type", "Missing required parameter: '%s.type'.", partParam)
+		}
+		if partType == "text" {
+			var partText string
+			if err := decodeField(part, "text", &partText, partParam); err != nil {
+				return err
+			}
+			if isNull(part["text"]) {
+				return invalid(partParam+".text", "Missing required parameter: '%s.text'.", partParam)
+			}
+		}
+	}
+	return nil
+}
+
+func validateNumber(fields map[string]json.RawMessage, name string, bounds numberRange) *validationError {
+	var value float64
+	if err := decodeField(fields, name, &value); err != nil || isNull(fields[name]) {
+		return err
+	}
+	if bounds.integer && value != math.Trunc(value) {
+		return invalid(name, "Invalid type for '%s': expected an integer, but got a decimal number instead.", name)
+	}
+	if value < bounds.min {
+		return invalid(name, "Invalid '%s': decimal below minimum value. Expected a value >= %v, but got %v instead.", name, bounds.min, value)
+	}
+	if value > bounds.max {
+		return invalid(name, "Invalid '%s': decimal above maximum value. Expected a value <= %v, but got %v instead.", name, bounds.max, value)
+	}
+	return nil
+}
+
+func validateStop(raw json.RawMessage) *validationError {
+	if isNull(raw) {
+		return nil
+	}
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return nil
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) != nil {
+		return invalid("stop", "Invalid type for 'stop': expected a string or an array of strings.")
+	}
+	if len(list) > 4 {
+		return invalid("stop", "Invalid 'stop': array too long. Expected an array with maximum length 4, but got an array with length %d instead.", len(list))
+	}
+	return nil
+}
+
+// decodeObject decodes a single JSON object, rejecting trailing data.
+func decodeObject(data []byte) (map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var object map[string]json.RawMessage
+	if err := dec.Decode(&object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, fmt.Errorf("expected an object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+	return object, nil
+}
+
+// decodeField decodes an optional field into target, leaving it untouched
+// when the field is missing or null. prefix is the param path of the object.
+func decodeField(object map[string]json.RawMessage, name string, target any, prefix ...string) *validationError {
+	raw := object[name]
+	if isNull(raw) {
+		return nil
+	}
+	param := name
+	if len(prefix) > 0 {
+		param = prefix[0] + "." + name
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return invalid(param, "Invalid type for '%s': expected %s.", param, typeName(target))
+	}
+	return nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(bytes.TrimSpace(raw)) == "null"
+}
+
+func typeName(target any) string {
+	switch target.(type) {
+	case *string:
+		return "a string"
+	case *bool:
+		return "a boolean"
+	case *float64:
+		return "a number"
+	case *[]json.RawMessage:
+		return "an array"
+	default:
+		return "a different type"
+	}
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + v + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,201 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core"
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+	"github.com/simone-trubian/baldr/proxy/internal/handlers"
+	"github.com/simone-trubian/baldr/proxy/internal/replay"
+)
+
+type TestMockGuardrail struct {
+	mockValidate func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error)
+}
+
+func (a *TestMockGuardrail) Validate(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+	return a.mockValidate(ctx, payload)
+}
+
+type TestMockLLM struct{}
+
+func (m *TestMockLLM) Generate(ctx context.Context, payload []byte, headers map[string]string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("data: {}\n\ndata: [DONE]\n\n")), nil
+}
+
+func TestLoad(t *testing.T) {
+	input := strings.Join([]string{
+		`{"model":"m","messages":[{"role":"user","content":"hi"}]}`,
+		``,
+		`{"id":"r-1","body":{"model":"m","messages":[]},"headers":{"X-Baldr-Team":"red"}}`,
+		`{"request_id":"r-2","title":"t","body":"Summarise the report"}`,
+		`{"body":"{\"model\":\"m\",\"messages\":[]}"}`,
+	}, "\n")
+
+	requests, err := replay.Load(strings.NewReader(input), "default-model")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(requests) != 4 {
+		t.Fatalf("Expected 4 requests, got %d", len(requests))
+	}
+
+	if requests[0].ID != "line-1" || !strings.Contains(string(requests[0].Body), `"content":"hi"`) {
+		t.Errorf("Bare body not loaded as is: %+v", requests[0])
+	}
+	if requests[1].ID != "r-1" || requests[1].Headers["X-Baldr-Team"] != "red" {
+		t.Errorf("Envelope not loaded: %+v", requests[1])
+	}
+
+	var prompt struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(requests[2].Body, &prompt); err != nil {
+		t.Fatalf("Prompt body is not JSON: %v", err)
+	}
+	if requests[2].ID != "r-2" || prompt.Model != "default-model" || len(prompt.Messages) != 1 || prompt.Messages[0].Content != "Summarise the report" {
+		t.Errorf("Prompt not wrapped in a chat request: %s", requests[2].Body)
+	}
+	if string(requests[3].Body) != `{"model":"m","messages":[]}` {
+		t.Errorf("Encoded body not decoded: %s", requests[3].Body)
+	}
+
+	if _, err := replay.Load(strings.NewReader("{not json"), "m"); err == nil {
+		t.Error("Expected an error for a malformed line")
+	}
+}
+
+func TestRun_HTTPTarget(t *testing.T) {
+	// Scenario: A proxy that blocks one request and streams the others
+	// Expected: Statuses and verdicts are tallied and responses recorded
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("attack")) {
+			w.Header().Set("X-Baldr-Guardrail", "block")
+			http.Error(w, "blocked: injection", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("X-Request-ID") == "" {
+			t.Error("Expected the request ID to be forwarded")
+		}
+		w.Header().Set("X-Baldr-Guardrail", "allow")
+		w.Write([]byte("data: {}\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer proxy.Close()
+
+	requests := []replay.Request{
+		{ID: "a", Body: []byte(`{"messages":[{"role":"user","content":"hello"}]}`)},
+		{ID: "b", Body: []byte(`{"messages":[{"role":"user","content":"attack"}]}`)},
+		{ID: "c", Body: []byte(`{"messages":[{"role":"user","content":"bye"}]}`)},
+	}
+	var record bytes.Buffer
+	target := &replay.HTTPTarget{URL: proxy.URL, Client: proxy.Client()}
+	results, err := replay.Run(context.Background(), target, requests, replay.RunConfig{Concurrency: 2, Record: &record})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	report := replay.Summarize(results, time.Second)
+	if report.Statuses[200] != 2 || report.Statuses[403] != 1 {
+		t.Errorf("Unexpected statuses: %v", report.Statuses)
+	}
+	if report.Verdicts["allow"] != 2 || report.Verdicts["block"] != 1 {
+		t.Errorf("Unexpected verdicts: %v", report.Verdicts)
+	}
+	if results[0].TTFT <= 0 || results[0].TTFT > results[0].Latency {
+		t.Errorf("Expected TTFT within the latency, got %v and %v", results[0].TTFT, results[0].Latency)
+	}
+
+	lines := strings.Split(strings.TrimSpace(record.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 recorded results, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var r replay.Result
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Recorded line is not JSON: %v", err)
+		}
+		if r.ID == "b" && !strings.HasPrefix(r.Response, "blocked") {
+			t.Errorf("Expected the recorded response body, got %q", r.Response)
+		}
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	for _, want := range []string{"Requests: 3", "403", "block", "TTFT", "Latency"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Report is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRun_ServiceTarget(t *testing.T) {
+	// Scenario: Replay through the proxy's handler in process
+	// Expected: The enforced action is reported, guardrail failures are
+	// errors, and statuses and timeouts are those of HTTP replays
+	guardrail := &TestMockGuardrail{
+		mockValidate: func(ctx context.Context, payload []byte) (*domain.GuardrailResponse, error) {
+			switch {
+			case bytes.Contains(payload, []byte("down")):
+				return nil, errors.New("guardrail unreachable")
+			case bytes.Contains(payload, []byte("attack")):
+				return &domain.GuardrailResponse{Allowed: false, Reason: "injection"}, nil
+			case bytes.Contains(payload, []byte("slow")):
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			if domain.RequestMetadataFrom(ctx).RequestID == "" {
+				t.Error("Expected request metadata on the context")
+			}
+			return &domain.GuardrailResponse{Allowed: true}, nil
+		},
+	}
+	service := core.NewBaldrService(guardrail, &TestMockLLM{})
+
+	chat := func(content string) []byte {
+		return []byte(`{"model":"m","messages":[{"role":"user","content":"` + content + `"}]}`)
+	}
+	requests := []replay.Request{
+		{ID: "a", Body: chat("hello")},
+		{ID: "b", Body: chat("attack")},
+		{ID: "c", Body: chat("down")},
+		{ID: "d", Body: []byte(`{"content":"hello"}`)},
+		{ID: "e", Body: chat("slow")},
+	}
+	target := &replay.ServiceTarget{Handler: http.HandlerFunc(handlers.NewHTTPHandler(service).HandleProxy), Timeout: 50 * time.Millisecond}
+	results, err := replay.Run(context.Background(), target, requests, replay.RunConfig{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []struct {
+		status  int
+		verdict string
+	}{{200, "allow"}, {403, "block"}, {403, replay.VerdictError}, {400, replay.VerdictUnknown}, {403, replay.VerdictError}}
+	for i, w := range want {
+		if results[i].Status != w.status || results[i].Verdict != w.verdict {
+			t.Errorf("Request %s: expected %d/%s, got %d/%s", results[i].ID, w.status, w.verdict, results[i].Status, results[i].Verdict)
+		}
+	}
+	if results[0].TTFT == 0 || results[0].TTFT > results[0].Latency {
+		t.Errorf("Expected a time to first byte within the latency, got %v of %v", results[0].TTFT, results[0].Latency)
+	}
+	if !strings.Contains(results[4].Error, "deadline exceeded") {
+		t.Errorf("Expected the timeout to apply, got %q", results[4].Error)
+	}
+}
@@ -0,0 +1,91 @@
+// Package replay sends recorded traffic through the proxy and reports how it
+// fared: status codes, guardrail verdicts and latency percentiles.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Request is one recorded request.
+type Request struct {
+	ID      string
+	Body    []byte
+	Headers map[string]string
+}
+
+// recordedRequest is the envelope form of a JSONL line. Body is either the
+// request body itself or, for logs of prompts, plain text to send as a
+// single user message.
+type recordedRequest struct {
+	ID        string            `json:"id"`
+	RequestID string            `json:"request_id"`
+	Body      json.RawMessage   `json:"body"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// Load reads JSONL requests. A line is either a chat completion body or an
+// envelope with id, body and headers. Prompts without a body of their own
+// are sent to defaultModel.
+func Load(r io.Reader, defaultModel string) ([]Request, error) {
+	var requests []Request
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20) // Recorded bodies can be large
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		req, err := parseLine(raw, defaultModel)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if req.ID == "" {
+			req.ID = fmt.Sprintf("line-%d", line)
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read requests: %w", err)
+	}
+	return requests, nil
+}
+
+func parseLine(raw []byte, defaultModel string) (Request, error) {
+	var envelope recordedRequest
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return Request{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if len(envelope.Body) == 0 {
+		// A bare request body
+		return Request{Body: bytes.Clone(raw)}, nil
+	}
+
+	req := Request{ID: envelope.ID, Headers: envelope.Headers}
+	if req.ID == "" {
+		req.ID = envelope.RequestID
+	}
+
+	var text string
+	if json.Unmarshal(envelope.Body, &text) != nil {
+		req.Body = bytes.Clone(envelope.Body)
+		return req, nil
+	}
+	// A string body holds either an encoded request or a prompt
+	if json.Valid([]byte(text)) && bytes.HasPrefix(bytes.TrimSpace([]byte(text)), []byte("{")) {
+		req.Body = []byte(text)
+		return req, nil
+	}
+	body, err := json.Marshal(map[string]any{
+		"model":    defaultModel,
+		"messages": []map[string]string{{"role": "user", "content": text}},
+	})
+	if err != nil {
+		return Request{}, err
+	}
+	req.Body = body
+	return req, nil
+}
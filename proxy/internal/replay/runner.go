@@ -0,0 +1,183 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// RunConfig controls how fast requests are replayed.
+type RunConfig struct {
+	// Concurrency is the number of requests in flight at once
+	Concurrency int
+	// Rate caps requests started per second, 0 means no cap
+	Rate float64
+	// Record, when set, receives one JSON line per result including the
+	// response body, to diff runs against each other
+	Record io.Writer
+}
+
+// Run replays requests against target and returns a result per request, in
+// the order of the input. When ctx is cancelled, only the requests already
+// sent are returned.
+func Run(ctx context.Context, target Target, requests []Request, config RunConfig) ([]Result, error) {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	var tick <-chan time.Time
+	if config.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / config.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	results := make([]Result, len(requests))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var recordMu sync.Mutex
+	var recordErr error
+
+	for range config.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := target.Do(ctx, requests[i], config.Record != nil)
+				results[i] = result
+				if config.Record == nil {
+					continue
+				}
+				recordMu.Lock()
+				if err := json.NewEncoder(config.Record).Encode(result); err != nil && recordErr == nil {
+					recordErr = fmt.Errorf("failed to record result: %w", err)
+				}
+				recordMu.Unlock()
+			}
+		}()
+	}
+
+	sent := 0
+dispatch:
+	for i := range requests {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- i:
+			sent++
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return results[:sent], err
+	}
+	return results, recordErr
+}
+
+// Report summarises a replay.
+type Report struct {
+	Total    int
+	Statuses map[int]int    // Status code to count, 0 for transport errors
+	Verdicts map[string]int // Guardrail verdict to count
+	TTFT     Percentiles    // Over responses that produced a byte
+	Latency  Percentiles
+	Duration time.Duration
+}
+
+// Percentiles of a set of durations.
+type Percentiles struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// Summarize builds a report over results of a run that took duration.
+func Summarize(results []Result, duration time.Duration) Report {
+	report := Report{
+		Total:    len(results),
+		Statuses: make(map[int]int),
+		Verdicts: make(map[string]int),
+		Duration: duration,
+	}
+	var ttft, latency []time.Duration
+	for _, r := range results {
+		report.Statuses[r.Status]++
+		if r.Verdict != "" {
+			report.Verdicts[r.Verdict]++
+		}
+		if r.TTFT > 0 {
+			ttft = append(ttft, r.TTFT)
+		}
+		latency = append(latency, r.Latency)
+	}
+	report.TTFT = percentiles(ttft)
+	report.Latency = percentiles(latency)
+	return report
+}
+
+func percentiles(values []time.Duration) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(values)
+	return Percentiles{
+		P50: domain.Percentile(values, 0.50),
+		P90: domain.Percentile(values, 0.90),
+		P99: domain.Percentile(values, 0.99),
+		Max: values[len(values)-1],
+	}
+}
+
+// Print writes the report in a human readable form.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Requests: %d in %s", r.Total, r.Duration.Round(time.Millisecond))
+	if r.Duration > 0 {
+		fmt.Fprintf(w, " (%.1f req/s)", float64(r.Total)/r.Duration.Seconds())
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Status:")
+	for _, status := range slices.Sorted(maps.Keys(r.Statuses)) {
+		label := fmt.Sprint(status)
+		if status == 0 {
+			label = "error"
+		}
+		fmt.Fprintf(w, "  %-8s %6d\n", label, r.Statuses[status])
+	}
+
+	fmt.Fprintln(w, "Guardrail:")
+	verdicts := slices.Collect(maps.Keys(r.Verdicts))
+	sort.Slice(verdicts, func(i, j int) bool {
+		if r.Verdicts[verdicts[i]] != r.Verdicts[verdicts[j]] {
+			return r.Verdicts[verdicts[i]] > r.Verdicts[verdicts[j]]
+		}
+		return verdicts[i] < verdicts[j]
+	})
+	for _, verdict := range verdicts {
+		fmt.Fprintf(w, "  %-8s %6d\n", verdict, r.Verdicts[verdict])
+	}
+
+	fmt.Fprintf(w, "%-9s %10s %10s %10s %10s\n", "", "p50", "p90", "p99", "max")
+	for _, row := range []struct {
+		name string
+		p    Percentiles
+	}{{"TTFT", r.TTFT}, {"Latency", r.Latency}} {
+		fmt.Fprintf(w, "%-9s %10s %10s %10s %10s\n", row.name,
+			row.p.P50.Round(time.Millisecond), row.p.P90.Round(time.Millisecond),
+			row.p.P99.Round(time.Millisecond), row.p.Max.Round(time.Millisecond))
+	}
+}
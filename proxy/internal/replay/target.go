@@ -0,0 +1,188 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/simone-trubian/baldr/proxy/internal/core/domain"
+)
+
+// Verdicts reported next to the guardrail actions.
+const (
+	VerdictError   = "error"   // The guardrail failed, the request was rejected
+	VerdictSkipped = "skipped" // Answered from cache without a guardrail call
+	VerdictUnknown = "unknown" // The target didn't say
+)
+
+// Result is the outcome of one replayed request.
+type Result struct {
+	ID       string        `json:"id"`
+	Status   int           `json:"status"`
+	Verdict  string        `json:"verdict"`
+	TTFT     time.Duration `json:"ttft_ns"` // Time to the first response byte
+	Latency  time.Duration `json:"latency_ns"`
+	Error    string        `json:"error,omitempty"`
+	Response string        `json:"response,omitempty"` // Only when recording
+}
+
+// Target executes a request.
+type Target interface {
+	Do(ctx context.Context, req Request, keepBody bool) Result
+}
+
+// HTTPTarget replays against a running proxy.
+type HTTPTarget struct {
+	URL    string
+	Client *http.Client
+}
+
+func (t *HTTPTarget) Do(ctx context.Context, req Request, keepBody bool) Result {
+	result := Result{ID: req.ID}
+	start := time.Now()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", t.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Request-ID", req.ID)
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := t.Client.Do(httpReq)
+	if err != nil {
+		result.Error = err.Error()
+		result.Latency = time.Since(start)
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	body := readTimed(resp.Body, start, &result, keepBody)
+	result.Verdict = resp.Header.Get("X-Baldr-Guardrail")
+	if result.Verdict == "" {
+		result.Verdict = inferVerdict(resp.StatusCode, resp.Header.Get("X-Baldr-Cache-Status"), body)
+	}
+	return result
+}
+
+// ServiceTarget replays through the proxy's handler in process, without a
+// network. Requests are validated, authenticated and answered with the same
+// statuses as over HTTP.
+type ServiceTarget struct {
+	Handler http.Handler
+	// Timeout of each request, 0 for none
+	Timeout time.Duration
+}
+
+func (t *ServiceTarget) Do(ctx context.Context, req Request, keepBody bool) Result {
+	result := Result{ID: req.ID}
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "/chat/completions", bytes.NewReader(req.Body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Request-ID", req.ID)
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	w := &timedWriter{header: http.Header{}, start: time.Now(), result: &result}
+	t.Handler.ServeHTTP(w, httpReq)
+	result.Latency = time.Since(w.start)
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	if err := ctx.Err(); err != nil {
+		result.Error = err.Error()
+	}
+	if keepBody {
+		result.Response = w.body.String()
+	}
+	result.Verdict = w.header.Get("X-Baldr-Guardrail")
+	if result.Verdict == "" {
+		result.Verdict = inferVerdict(result.Status, w.header.Get("X-Baldr-Cache-Status"), w.body.Bytes())
+	}
+	return result
+}
+
+// timedWriter keeps what a handler answers, noting when the first byte of
+// the body was written.
+type timedWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	start  time.Time
+	result *Result
+}
+
+func (w *timedWriter) Header() http.Header { return w.header }
+
+func (w *timedWriter) WriteHeader(status int) {
+	if w.result.Status == 0 {
+		w.result.Status = status
+	}
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.result.TTFT == 0 && len(p) > 0 {
+		w.result.TTFT = time.Since(w.start)
+	}
+	return w.body.Write(p)
+}
+
+// Flush lets the handler stream, as it does to clients.
+func (w *timedWriter) Flush() {}
+
+// readTimed drains a response, noting when the first byte arrived.
+func readTimed(r io.Reader, start time.Time, result *Result, keepBody bool) []byte {
+	var body bytes.Buffer
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if result.TTFT == 0 {
+				result.TTFT = time.Since(start)
+			}
+			body.Write(buf[:n])
+		}
+		if err != nil {
+			if err != io.EOF {
+				result.Error = err.Error()
+			}
+			break
+		}
+	}
+	result.Latency = time.Since(start)
+	if keepBody {
+		result.Response = body.String()
+	}
+	return body.Bytes()
+}
+
+// inferVerdict reads the verdict off a response from a proxy that doesn't
+// send the X-Baldr-Guardrail header.
+func inferVerdict(status int, cacheStatus string, body []byte) string {
+	switch {
+	case cacheStatus == string(domain.CacheHit):
+		return VerdictSkipped
+	case status == http.StatusForbidden && bytes.HasPrefix(body, []byte("blocked")):
+		return string(domain.ActionBlock)
+	case status == http.StatusForbidden && bytes.Contains(body, []byte("guardrail")):
+		return VerdictError
+	default:
+		return VerdictUnknown
+	}
+}
@@ -44,8 +44,17 @@ func TestGuardrail_HappyPath_SchemaValidation(t *testing.T) {
 	// 2. Define the CONTRACT (What Python Pydantic expects/returns)
 	// We define this LOCALLY to ensure we are testing the Wire Format,
 	// not just reusing Go structs.
+	type PythonMetadataContract struct {
+		RequestID string `json:"request_id"`
+		KeyID     string `json:"key_id"`
+		Direction string `json:"direction"`
+		PolicyID  string `json:"policy_id"`
+	}
+
 	type PythonRequestContract struct {
-		Prompt string `json:"prompt"`
+		Version  string                 `json:"version"`
+		Payload  map[string]string      `json:"payload"`
+		Metadata PythonMetadataContract `json:"metadata"`
 	}
 
 	type PythonResponseContract struct {
@@ -55,7 +64,16 @@ func TestGuardrail_HappyPath_SchemaValidation(t *testing.T) {
 	}
 
 	// 3. Prepare the Data
-	contractReq := PythonRequestContract{Prompt: "SELECT * FROM users"}
+	contractReq := PythonRequestContract{
+		Version: domain.GuardrailContractVersion,
+		Payload: map[string]string{"prompt": "SELECT * FROM users"},
+		Metadata: PythonMetadataContract{
+			RequestID: "req-42",
+			KeyID:     "key-abc",
+			Direction: "input",
+			PolicyID:  "strict",
+		},
+	}
 	contractResp := PythonResponseContract{
 		Allowed:        false,
 		Reason:         "SQL Injection Detected",
@@ -63,24 +81,27 @@ func TestGuardrail_HappyPath_SchemaValidation(t *testing.T) {
 	}
 
 	// 4. Program MockServer
-	// This ensures Go sends EXACTLY {"prompt": "..."}
-	// and can handle the response.
+	// The adapter only sends the envelope to sidecars advertising version 3,
+	// and the body must then match it field by field.
+	createCapabilitiesExpectation(t, mockServerURL, []string{"1", "2", "3"})
 	createStrictJSONExpectation(t, mockServerURL, contractReq, contractResp)
 
 	// 5. Configure Adapter
 	config := adapters.GuardrailConfig{
-		BaseURL: mockServerURL,
-		Timeout: 2 * time.Second,
+		BaseURL:        mockServerURL + "/validate",
+		Timeout:        2 * time.Second,
+		MaxConcurrency: 1,
 	}
 	adapter := adapters.NewRemoteGuardrail(config)
 
 	// 6. Execute Logic (Domain Model Input)
-	domainInput := domain.RequestPayload{
-		Model:  "gpt-4", // Note: This field is currently dropped by the adapter
-		Prompt: "SELECT * FROM users",
+	md := &domain.RequestMetadata{
+		RequestID: "req-42",
+		KeyID:     "key-abc",
+		Direction: domain.DirectionInput,
+		PolicyID:  "strict",
 	}
-
-	result, err := adapter.Validate(ctx, []byte(domainInput.Prompt))
+	result, err := adapter.Validate(domain.WithRequestMetadata(ctx, md), []byte(`{"prompt": "SELECT * FROM users"}`))
 
 	// 7. Assertions
 	assert.NoError(t, err)
@@ -89,7 +110,7 @@ func TestGuardrail_HappyPath_SchemaValidation(t *testing.T) {
 	assert.False(t, result.Allowed)
 	assert.Equal(t, "SQL Injection Detected", result.Reason)
 	// Verify the Snake_case JSON was mapped to CamelCase Go Struct correctly
-	assert.Equal(t, "SELECT * FROM users", result.SanitizedInput)
+	assert.JSONEq(t, `{"prompt": "SELECT * FROM users"}`, string(result.SanitizedInput))
 }
 
 func TestGuardrail_FailClosed_OnTimeout(t *testing.T) {
@@ -217,3 +238,32 @@ func createStrictJSONExpectation(t *testing.T, baseURL string, expectedReq, mock
 	assert.NoError(t, err)
 	assert.Equal(t, 201, resp.StatusCode, "MockServer rejected the expectation configuration")
 }
+
+// createCapabilitiesExpectation makes MockServer advertise the given
+// contract versions on GET /capabilities.
+func createCapabilitiesExpectation(t *testing.T, baseURL string, versions []string) {
+	respBytes, err := json.Marshal(map[string][]string{"versions": versions})
+	assert.NoError(t, err)
+
+	payload := map[string]interface{}{
+		"httpRequest": map[string]interface{}{
+			"method": "GET",
+			"path":   "/capabilities",
+		},
+		"httpResponse": map[string]interface{}{
+			"statusCode": 200,
+			"headers": map[string][]string{
+				"content-type": {"application/json"},
+			},
+			"body": json.RawMessage(respBytes),
+		},
+	}
+
+	finalBody, err := json.Marshal(payload)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("PUT", baseURL+"/mockserver/expectation", strings.NewReader(string(finalBody)))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode, "MockServer rejected the expectation configuration")
+}
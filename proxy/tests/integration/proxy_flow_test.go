@@ -51,9 +51,9 @@ func TestProxyFlow_Sanitization(t *testing.T) {
 		mockServerBaseUrl,
 		"/guardrail",
 		"POST",
-		`{"prompt": "my secret password"}`, // Expected Input
+		`{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "my secret password"}]}`, // Expected Input
 		200,
-		`{"allowed": true, "sanitized_input": {"prompt": "my secret [REDACTED]"}}`) // Response
+		`{"allowed": true, "sanitized_input": {"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "my secret [REDACTED]"}]}}`) // Response
 
 	// B. LLM Expectation: MUST receive "sanitized" input
 	configureMockServer(
@@ -61,7 +61,7 @@ func TestProxyFlow_Sanitization(t *testing.T) {
 		mockServerBaseUrl,
 		"/v1/chat/completions",
 		"POST",
-		`{"prompt": "my secret [REDACTED]"}`, // If it receives "password", this won't match -> 404
+		`{"messages": [{"role": "user", "content": "my secret [REDACTED]"}]}`, // If it receives "password", this won't match -> 404
 		200,
 		`{"id": "chatcmpl-123", "choices": [{"message": {"content": "Hello"}}]}`,
 	)
@@ -86,7 +86,7 @@ func TestProxyFlow_Sanitization(t *testing.T) {
 	// --- EXECUTE TEST ---
 
 	// The User sends the "Dirty" request
-	reqBody := `{"prompt": "my secret password"}`
+	reqBody := `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "my secret password"}]}`
 	r := httptest.NewRequest("POST", "/proxy", strings.NewReader(reqBody))
 	r.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()